@@ -3,6 +3,7 @@ module github.com/grow/ingestion-service
 go 1.21
 
 require (
+	github.com/grow/monitor-ghm v0.0.0
 	github.com/nats-io/nats.go v1.31.0
 	github.com/spf13/pflag v1.0.5
 )
@@ -20,3 +21,5 @@ require (
 )
 
 require github.com/castai/promwrite v0.5.0
+
+replace github.com/grow/monitor-ghm => ../monitor-ghm
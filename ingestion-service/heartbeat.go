@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/castai/promwrite"
+	"github.com/nats-io/nats.go"
+
+	"github.com/grow/ingestion-service/pkg/options"
+)
+
+// heartbeats missed before a device is considered down
+const missedHeartbeats = 3
+
+var loadPeriods = []string{"1m", "5m", "15m"}
+
+// Heartbeat is the periodic status sent by the monitors, system values that
+// couldn't be read are missing.
+type Heartbeat struct {
+	Device
+
+	Timestamp     time.Time
+	Interval      float64
+	Version       string
+	Uptime        float64
+	Sensors       []string
+	PublishErrors map[string]int64 `json:"publish_errors"`
+
+	SystemUptime   *float64 `json:"system_uptime"`
+	CPUTemperature *float64 `json:"cpu_temperature"`
+	Load           []float64
+	DiskFree       *uint64 `json:"disk_free"`
+	DiskTotal      *uint64 `json:"disk_total"`
+	WiFi           *struct {
+		Interface   string
+		LinkQuality float64 `json:"link_quality"`
+		Signal      float64
+	}
+}
+
+// seenDevice is the latest heartbeat of a device
+type seenDevice struct {
+	labels   []promwrite.Label
+	seen     time.Time
+	interval time.Duration
+}
+
+// devices tracks the devices that sent heartbeats, to report the ones that
+// stopped sending them as down
+type devices struct {
+	mu   sync.Mutex
+	seen map[string]seenDevice
+}
+
+// consumeHeartbeats writes the heartbeats as grow_device_* metrics, and
+// grow_device_up every options.DeviceUpInterval.
+func consumeHeartbeats(nc *nats.Conn, options options.Options) (*nats.Subscription, error) {
+	d := &devices{seen: map[string]seenDevice{}}
+	go d.writeUp(options)
+
+	return nc.Subscribe(options.NATS.HeartbeatSubject, func(msg *nats.Msg) {
+		slog.Debug("received heartbeat", "msg", string(msg.Data))
+
+		hb := Heartbeat{}
+		err := json.Unmarshal(msg.Data, &hb)
+		if err != nil || hb.Device.Device == "" {
+			slog.Warn("heartbeat with invalid format - ignoring it", "error", err)
+			return
+		}
+		labels := deviceLabels(hb.Device)
+		d.see(hb, labels)
+
+		err = storeMetrics(heartbeatSeries(hb, labels), options.Prometheus)
+		if err != nil {
+			slog.Warn("could not write heartbeat to prometheus", "device", hb.Device.Device, "error", err)
+		}
+	})
+}
+
+func heartbeatSeries(hb Heartbeat, labels []promwrite.Label) []promwrite.TimeSeries {
+	ts := hb.Timestamp
+	with := func(name, value string) []promwrite.Label {
+		return append(slices.Clone(labels), promwrite.Label{Name: name, Value: value})
+	}
+
+	series := []promwrite.TimeSeries{
+		timeSeries("grow_device_info", with("version", hb.Version), ts, 1),
+		timeSeries("grow_device_uptime_seconds", labels, ts, hb.Uptime),
+		timeSeries("grow_device_sensors", labels, ts, float64(len(hb.Sensors))),
+	}
+	publishers := []string{}
+	for name := range hb.PublishErrors {
+		publishers = append(publishers, name)
+	}
+	slices.Sort(publishers)
+	for _, name := range publishers {
+		series = append(series, timeSeries("grow_device_publish_errors_total", with("publisher", name), ts, float64(hb.PublishErrors[name])))
+	}
+
+	if hb.SystemUptime != nil {
+		series = append(series, timeSeries("grow_device_system_uptime_seconds", labels, ts, *hb.SystemUptime))
+	}
+	if hb.CPUTemperature != nil {
+		series = append(series, timeSeries("grow_device_cpu_temperature_celsius", labels, ts, *hb.CPUTemperature))
+	}
+	for i, load := range hb.Load {
+		if i < len(loadPeriods) {
+			series = append(series, timeSeries("grow_device_load", with("period", loadPeriods[i]), ts, load))
+		}
+	}
+	if hb.DiskFree != nil && hb.DiskTotal != nil {
+		series = append(series,
+			timeSeries("grow_device_disk_free_bytes", labels, ts, float64(*hb.DiskFree)),
+			timeSeries("grow_device_disk_total_bytes", labels, ts, float64(*hb.DiskTotal)))
+	}
+	if hb.WiFi != nil {
+		series = append(series,
+			timeSeries("grow_device_wifi_signal_dbm", with("interface", hb.WiFi.Interface), ts, hb.WiFi.Signal),
+			timeSeries("grow_device_wifi_link_quality", with("interface", hb.WiFi.Interface), ts, hb.WiFi.LinkQuality))
+	}
+	return series
+}
+
+func (d *devices) see(hb Heartbeat, labels []promwrite.Label) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seen[hb.Device.Device] = seenDevice{
+		labels:   labels,
+		seen:     time.Now(),
+		interval: time.Duration(hb.Interval * float64(time.Second)),
+	}
+}
+
+// writeUp writes grow_device_up, 1 for the devices that sent a heartbeat in
+// the last missedHeartbeats intervals and 0 for the others.
+func (d *devices) writeUp(options options.Options) {
+	ticker := time.NewTicker(options.DeviceUpInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		series := d.up(now)
+		if len(series) == 0 {
+			continue
+		}
+		err := storeMetrics(series, options.Prometheus)
+		if err != nil {
+			slog.Warn("could not write device up to prometheus", "error", err)
+		}
+	}
+}
+
+func (d *devices) up(now time.Time) []promwrite.TimeSeries {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ids := []string{}
+	for id := range d.seen {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	series := []promwrite.TimeSeries{}
+	for _, id := range ids {
+		device := d.seen[id]
+		value := 0.0
+		if now.Sub(device.seen) <= missedHeartbeats*device.interval {
+			value = 1
+		}
+		series = append(series, timeSeries("grow_device_up", device.labels, now, value))
+	}
+	return series
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatSeries(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "minimal",
+			data: `{"device": "pi-one", "timestamp": "2024-05-01T10:00:00Z", "interval": 60, "version": "v1.2.0", "uptime": 3600, "sensors": ["pilea", "basil"]}`,
+			want: []string{
+				`grow_device_info{device="pi-one",version="v1.2.0"} 1`,
+				`grow_device_uptime_seconds{device="pi-one"} 3600`,
+				`grow_device_sensors{device="pi-one"} 2`,
+			},
+		},
+		{
+			name: "system",
+			data: `{"device": "pi-one", "timestamp": "2024-05-01T10:00:00Z", "version": "dev", "uptime": 60,
+				"publish_errors": {"nats": 3, "mqtt": 1},
+				"system_uptime": 86400, "cpu_temperature": 48.3, "load": [0.5, 0.25, 0.1],
+				"disk_free": 1000, "disk_total": 4000,
+				"wifi": {"interface": "wlan0", "link_quality": 0.7, "signal": -55}}`,
+			want: []string{
+				`grow_device_info{device="pi-one",version="dev"} 1`,
+				`grow_device_uptime_seconds{device="pi-one"} 60`,
+				`grow_device_sensors{device="pi-one"} 0`,
+				`grow_device_publish_errors_total{device="pi-one",publisher="mqtt"} 1`,
+				`grow_device_publish_errors_total{device="pi-one",publisher="nats"} 3`,
+				`grow_device_system_uptime_seconds{device="pi-one"} 86400`,
+				`grow_device_cpu_temperature_celsius{device="pi-one"} 48.3`,
+				`grow_device_load{device="pi-one",period="1m"} 0.5`,
+				`grow_device_load{device="pi-one",period="5m"} 0.25`,
+				`grow_device_load{device="pi-one",period="15m"} 0.1`,
+				`grow_device_disk_free_bytes{device="pi-one"} 1000`,
+				`grow_device_disk_total_bytes{device="pi-one"} 4000`,
+				`grow_device_wifi_signal_dbm{device="pi-one",interface="wlan0"} -55`,
+				`grow_device_wifi_link_quality{device="pi-one",interface="wlan0"} 0.7`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := Heartbeat{}
+			err := json.Unmarshal([]byte(tt.data), &hb)
+			if err != nil {
+				t.Fatalf("invalid heartbeat: %v", err)
+			}
+			got := seriesStrings(heartbeatSeries(hb, deviceLabels(hb.Device)))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("heartbeatSeries() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDevicesUp(t *testing.T) {
+	d := &devices{seen: map[string]seenDevice{}}
+	for _, id := range []string{"pi-two", "pi-one"} {
+		hb := Heartbeat{Device: Device{Device: id}, Interval: 60}
+		d.see(hb, deviceLabels(hb.Device))
+	}
+
+	now := time.Now()
+	got := seriesStrings(d.up(now.Add(2 * time.Minute)))
+	want := []string{`grow_device_up{device="pi-one"} 1`, `grow_device_up{device="pi-two"} 1`}
+	if !slices.Equal(got, want) {
+		t.Errorf("up() = %v, want %v", got, want)
+	}
+
+	// down after missing the heartbeats, until it sends one again
+	d.see(Heartbeat{Device: Device{Device: "pi-two"}, Interval: 600}, deviceLabels(Device{Device: "pi-two"}))
+	got = seriesStrings(d.up(now.Add(missedHeartbeats*time.Minute + time.Second)))
+	want = []string{`grow_device_up{device="pi-one"} 0`, `grow_device_up{device="pi-two"} 1`}
+	if !slices.Equal(got, want) {
+		t.Errorf("up() = %v, want %v", got, want)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -19,10 +22,41 @@ import (
 	"github.com/grow/ingestion-service/pkg/options"
 )
 
+// sensor health states sent by the monitors
+var healths = []string{"ok", "stale", "out-of-range", "disconnected"}
+
+// labels set by the service, device labels with the same name are left out
+var reservedLabels = []string{"__name__", "name", "state", "connector", "device", "hostname", "location", "version", "period", "publisher", "interface", "pump", "dose", "status", "dry_run"}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Device identifies the monitor that sent a message
+type Device struct {
+	Device   string
+	Hostname string
+	Location string
+	Labels   map[string]string
+}
+
 type Reading struct {
 	Timestamp time.Time
 	Name      string
+	Metric    string
+	Unit      string
 	Value     string
+	Raw       string
+	Health    string
+
+	Connector    string
+	Frequency    string
+	MinFrequency string `json:"min_frequency"`
+	MaxFrequency string `json:"max_frequency"`
+
+	Voltage    string
+	DryVoltage string `json:"dry_voltage"`
+	WetVoltage string `json:"wet_voltage"`
+
+	Device
 }
 
 func main() {
@@ -37,14 +71,35 @@ func main() {
 	handler := slog.NewTextHandler(os.Stdout, slogOptions)
 	slog.SetDefault(slog.New(handler))
 
+	nc, err := connect(options.NATS)
+	if err != nil {
+		slog.Error("could not connect to nats", "error", err)
+		os.Exit(1)
+	}
+	defer nc.Close()
+
 	// starts message processing
-	cc, err := consumeMessages(options)
+	cc, err := consumeMessages(nc, options)
 	if err != nil {
 		slog.Error("error processing messages", "error", err)
 		os.Exit(1)
 	}
 	defer cc.Stop()
 
+	sub, err := consumeHeartbeats(nc, options)
+	if err != nil {
+		slog.Error("error processing heartbeats", "error", err)
+		os.Exit(1)
+	}
+	defer sub.Unsubscribe()
+
+	wcc, err := consumeWatering(nc, options)
+	if err != nil {
+		slog.Error("error processing watering events", "error", err)
+		os.Exit(1)
+	}
+	defer wcc.Stop()
+
 	// inits probes
 	go func() {
 		probe := func(w http.ResponseWriter, r *http.Request) {
@@ -61,9 +116,7 @@ func main() {
 	<-sig
 }
 
-func consumeMessages(options options.Options) (jetstream.ConsumeContext, error) {
-	nc, _ := nats.Connect(options.NATS.URL)
-
+func consumeMessages(nc *nats.Conn, options options.Options) (jetstream.ConsumeContext, error) {
 	js, _ := jetstream.New(nc)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -80,27 +133,20 @@ func consumeMessages(options options.Options) (jetstream.ConsumeContext, error)
 	cc, err := cons.Consume(func(msg jetstream.Msg) {
 		slog.Debug("received jetstream message", "msg", string(msg.Data()))
 
-		reading := Reading{}
-		err := json.Unmarshal(msg.Data(), &reading)
-		if err != nil {
-			slog.Warn("message with invalid format - ignoring it", "error", err)
-			msg.Ack()
-			return
-		}
-		value, err := strconv.ParseFloat(reading.Value, 64)
+		reading, series, err := readingSeries(msg.Data())
 		if err != nil {
 			slog.Warn("message with invalid format - ignoring it", "error", err)
 			msg.Ack()
 			return
 		}
 
-		slog.Debug("writing to prometheus", "name", reading.Name, "value", value, "ts", reading.Timestamp)
-		err = storeMetric(reading.Name, reading.Timestamp, value, options.Prometheus)
+		slog.Debug("writing to prometheus", "name", reading.Name, "value", reading.Value, "frequency", reading.Frequency, "ts", reading.Timestamp)
+		err = storeMetrics(series, options.Prometheus)
 		if err != nil {
 			slog.Warn("could not write reading to prometheus", "error", err)
 			return
 		}
-		slog.Debug("writing done", "name", reading.Name, "value", value, "ts", reading.Timestamp)
+		slog.Debug("writing done", "name", reading.Name, "value", reading.Value, "ts", reading.Timestamp)
 		msg.Ack()
 	})
 	if err != nil {
@@ -111,27 +157,152 @@ func consumeMessages(options options.Options) (jetstream.ConsumeContext, error)
 	return cc, nil
 }
 
-func storeMetric(name string, timestamp time.Time, value float64, promConfig options.PrometheusConfig) error {
+// readingSeries parses a reading and returns its time series: the value of
+// healthy sensors, the health, the raw frequency or voltage and the
+// calibration bounds.
+func readingSeries(data []byte) (Reading, []promwrite.TimeSeries, error) {
+	reading := Reading{}
+	err := json.Unmarshal(data, &reading)
+	if err != nil {
+		return reading, nil, err
+	}
+	value, err := strconv.ParseFloat(reading.Value, 64)
+	if err != nil {
+		return reading, nil, err
+	}
+
+	labels := append([]promwrite.Label{{Name: "name", Value: reading.Name}}, deviceLabels(reading.Device)...)
+	series := []promwrite.TimeSeries{}
+
+	// readings of unhealthy sensors have no value, older monitors
+	// don't send the health
+	if reading.Health == "" || reading.Health == "ok" {
+		series = append(series, timeSeries(metricName(reading), labels, reading.Timestamp, value))
+	}
+	if reading.Health != "" {
+		metric := reading.Metric
+		if metric == "" {
+			metric = "moisture"
+		}
+		for _, h := range healths {
+			healthLabels := append(slices.Clone(labels), promwrite.Label{Name: "metric", Value: metric}, promwrite.Label{Name: "state", Value: h})
+			healthValue := 0.0
+			if h == reading.Health {
+				healthValue = 1
+			}
+			series = append(series, timeSeries("sensor_health", healthLabels, reading.Timestamp, healthValue))
+		}
+	}
+
+	// the raw frequency allows recomputing the moisture after a new
+	// calibration, older monitors don't send it
+	if reading.Frequency != "" {
+		frequency, err := strconv.ParseFloat(reading.Frequency, 64)
+		if err != nil {
+			slog.Warn("message with invalid frequency - ignoring it", "error", err)
+		} else {
+			frequencyLabels := slices.Clone(labels)
+			if reading.Connector != "" {
+				frequencyLabels = append(frequencyLabels, promwrite.Label{Name: "connector", Value: reading.Connector})
+			}
+			series = append(series, timeSeries("soil_moisture_frequency_hz", frequencyLabels, reading.Timestamp, frequency))
+		}
+	}
+
+	// the same for the voltage of analog sensors
+	if reading.Voltage != "" {
+		voltage, err := strconv.ParseFloat(reading.Voltage, 64)
+		if err != nil {
+			slog.Warn("message with invalid voltage - ignoring it", "error", err)
+		} else {
+			series = append(series, timeSeries("soil_moisture_voltage_volts", labels, reading.Timestamp, voltage))
+		}
+	}
+
+	// the calibration bounds used for the moisture, to recompute it and to
+	// tell when the range of a sensor drifted
+	for _, b := range []struct{ name, bound, value string }{
+		{"soil_moisture_frequency_bound_hz", "min", reading.MinFrequency},
+		{"soil_moisture_frequency_bound_hz", "max", reading.MaxFrequency},
+		{"soil_moisture_voltage_bound_volts", "dry", reading.DryVoltage},
+		{"soil_moisture_voltage_bound_volts", "wet", reading.WetVoltage},
+	} {
+		if b.value == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(b.value, 64)
+		if err != nil {
+			slog.Warn("message with invalid calibration bound - ignoring it", "bound", b.bound, "error", err)
+			continue
+		}
+		boundLabels := append(slices.Clone(labels), promwrite.Label{Name: "bound", Value: b.bound})
+		series = append(series, timeSeries(b.name, boundLabels, reading.Timestamp, value))
+	}
+	return reading, series, nil
+}
+
+// metricName returns the name of the reading metric, like
+// temperature_celsius. Soil moisture keeps its soil_moisture name, older
+// monitors only send moisture and no metric.
+func metricName(reading Reading) string {
+	if reading.Metric == "" || reading.Metric == "moisture" {
+		return "soil_moisture"
+	}
+	name := invalidLabelChars.ReplaceAllString(reading.Metric, "_")
+	if reading.Unit != "" {
+		name += "_" + invalidLabelChars.ReplaceAllString(reading.Unit, "_")
+	}
+	return name
+}
+
+// deviceLabels returns the labels identifying the device that sent a
+// message, so sensors with the same name on different devices don't
+// collide. Older monitors don't send them.
+func deviceLabels(device Device) []promwrite.Label {
+	labels := []promwrite.Label{}
+	for _, l := range []promwrite.Label{
+		{Name: "device", Value: device.Device},
+		{Name: "hostname", Value: device.Hostname},
+		{Name: "location", Value: device.Location},
+	} {
+		if l.Value != "" {
+			labels = append(labels, l)
+		}
+	}
+
+	names := []string{}
+	for name := range device.Labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		labelName := invalidLabelChars.ReplaceAllString(name, "_")
+		if labelName == "" || (labelName[0] >= '0' && labelName[0] <= '9') {
+			labelName = "_" + labelName
+		}
+		if slices.Contains(reservedLabels, labelName) || strings.HasPrefix(labelName, "__") {
+			slog.Warn("ignoring reserved device label", "device", device.Device, "label", name)
+			continue
+		}
+		labels = append(labels, promwrite.Label{Name: labelName, Value: device.Labels[name]})
+	}
+	return labels
+}
+
+func timeSeries(metric string, labels []promwrite.Label, timestamp time.Time, value float64) promwrite.TimeSeries {
+	return promwrite.TimeSeries{
+		Labels: append([]promwrite.Label{{Name: "__name__", Value: metric}}, labels...),
+		Sample: promwrite.Sample{
+			Time:  timestamp,
+			Value: value,
+		},
+	}
+}
+
+func storeMetrics(series []promwrite.TimeSeries, promConfig options.PrometheusConfig) error {
 	client := promwrite.NewClient(promConfig.URL)
 	_, err := client.Write(context.Background(), &promwrite.WriteRequest{
-		TimeSeries: []promwrite.TimeSeries{
-			{
-				Labels: []promwrite.Label{
-					{
-						Name:  "__name__",
-						Value: "soil_moisture",
-					},
-					{
-						Name:  "name",
-						Value: name,
-					},
-				},
-				Sample: promwrite.Sample{
-					Time:  timestamp,
-					Value: value,
-				},
-			},
-		},
+		TimeSeries: series,
 	})
 
 	return err
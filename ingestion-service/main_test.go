@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/castai/promwrite"
+)
+
+// seriesStrings returns the series in the Prometheus text format, without
+// the timestamps, so they are easy to compare.
+func seriesStrings(series []promwrite.TimeSeries) []string {
+	lines := []string{}
+	for _, s := range series {
+		name := ""
+		labels := []string{}
+		for _, l := range s.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+				continue
+			}
+			labels = append(labels, fmt.Sprintf("%s=%q", l.Name, l.Value))
+		}
+		lines = append(lines, fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), s.Sample.Value))
+	}
+	return lines
+}
+
+func TestReadingSeries(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "older monitor",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "42.5"}`,
+			want: []string{`soil_moisture{name="pilea"} 42.5`},
+		},
+		{
+			name: "device",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "42.5",
+				"device": "pi-one", "hostname": "pi", "location": "kitchen", "labels": {"room": "north", "9lives": "yes", "device": "other", "__x": "y", "rack-id": "2"}}`,
+			want: []string{`soil_moisture{name="pilea",device="pi-one",hostname="pi",location="kitchen",_9lives="yes",rack_id="2",room="north"} 42.5`},
+		},
+		{
+			name: "frequency",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "42.5", "frequency": "310.5", "connector": "2",
+				"min_frequency": "6.5", "max_frequency": "25.5"}`,
+			want: []string{
+				`soil_moisture{name="pilea"} 42.5`,
+				`soil_moisture_frequency_hz{name="pilea",connector="2"} 310.5`,
+				`soil_moisture_frequency_bound_hz{name="pilea",bound="min"} 6.5`,
+				`soil_moisture_frequency_bound_hz{name="pilea",bound="max"} 25.5`,
+			},
+		},
+		{
+			name: "voltage",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "basil", "value": "61", "voltage": "1.85", "dry_voltage": "2.8", "wet_voltage": "1.3"}`,
+			want: []string{
+				`soil_moisture{name="basil"} 61`,
+				`soil_moisture_voltage_volts{name="basil"} 1.85`,
+				`soil_moisture_voltage_bound_volts{name="basil",bound="dry"} 2.8`,
+				`soil_moisture_voltage_bound_volts{name="basil",bound="wet"} 1.3`,
+			},
+		},
+		{
+			name: "invalid frequency",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "42.5", "frequency": "fast", "min_frequency": "low"}`,
+			want: []string{`soil_moisture{name="pilea"} 42.5`},
+		},
+		{
+			name:    "invalid value",
+			data:    `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "wet"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			data:    `{"name": `,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, series, err := readingSeries([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readingSeries() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got := seriesStrings(series); !slices.Equal(got, tt.want) && !tt.wantErr {
+				t.Errorf("readingSeries() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestReadingSeriesHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "healthy moisture",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "pilea", "value": "42.5", "health": "ok"}`,
+			want: []string{
+				`soil_moisture{name="pilea"} 42.5`,
+				`sensor_health{name="pilea",metric="moisture",state="ok"} 1`,
+				`sensor_health{name="pilea",metric="moisture",state="stale"} 0`,
+				`sensor_health{name="pilea",metric="moisture",state="out-of-range"} 0`,
+				`sensor_health{name="pilea",metric="moisture",state="disconnected"} 0`,
+			},
+		},
+		{
+			name: "disconnected light",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "window", "metric": "light", "unit": "lux", "value": "NaN", "health": "disconnected"}`,
+			want: []string{
+				`sensor_health{name="window",metric="light",state="ok"} 0`,
+				`sensor_health{name="window",metric="light",state="stale"} 0`,
+				`sensor_health{name="window",metric="light",state="out-of-range"} 0`,
+				`sensor_health{name="window",metric="light",state="disconnected"} 1`,
+			},
+		},
+		{
+			name: "temperature",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "room", "metric": "temperature", "unit": "celsius", "value": "21.5"}`,
+			want: []string{`temperature_celsius{name="room"} 21.5`},
+		},
+		{
+			name: "proximity",
+			data: `{"timestamp": "2024-05-01T10:00:00Z", "name": "window", "metric": "proximity", "value": "12"}`,
+			want: []string{`proximity{name="window"} 12`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, series, err := readingSeries([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("readingSeries() error = %v", err)
+			}
+			if got := seriesStrings(series); !slices.Equal(got, tt.want) {
+				t.Errorf("readingSeries() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/grow/ingestion-service/pkg/options"
+)
+
+// connect opens the NATS connection with the TLS and authentication options.
+func connect(config options.NATSConfig) (*nats.Conn, error) {
+	security, err := config.Security.Options()
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(config.URL, security...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to nats %s: %w", config.URL, err)
+	}
+	return nc, nil
+}
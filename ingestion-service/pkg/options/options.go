@@ -3,19 +3,33 @@ package options
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"github.com/grow/monitor-ghm/pkg/natsclient"
 )
 
 const (
 	DefaultNATSURL       = "nats://192.168.1.2:4222"
 	DefaultPrometheusURL = "http://localhost:9090/api/v1/write"
+
+	DefaultHeartbeatSubject = "GrowHeartbeats.>"
+	DefaultWateringSubject  = "GrowWatering.>"
+	DefaultWateringStream   = "GrowWatering"
+	DefaultDeviceUpInterval = 30 * time.Second
 )
 
 type NATSConfig struct {
-	StreamName    string
-	StreamSubject string
-	URL           string
+	HeartbeatSubject string
+	WateringSubject  string
+	WateringStream   string
+	StreamName       string
+	StreamSubject    string
+	URL              string
+
+	// TLS and authentication, shared with the monitor
+	natsclient.Security
 }
 
 type PrometheusConfig struct {
@@ -23,10 +37,11 @@ type PrometheusConfig struct {
 }
 
 type Options struct {
-	LogLevel   *slog.LevelVar
-	NATS       NATSConfig
-	Prometheus PrometheusConfig
-	ProbesAddr string
+	DeviceUpInterval time.Duration
+	LogLevel         *slog.LevelVar
+	NATS             NATSConfig
+	Prometheus       PrometheusConfig
+	ProbesAddr       string
 }
 
 func Get() (Options, error) {
@@ -34,9 +49,21 @@ func Get() (Options, error) {
 	opt := Options{}
 	var logLevelValue string
 
+	pflag.StringVar(&opt.NATS.HeartbeatSubject, "nats-heartbeat-sub", DefaultHeartbeatSubject, "NATS subject of the device heartbeats")
+	pflag.StringVar(&opt.NATS.WateringSubject, "nats-watering-sub", DefaultWateringSubject, "NATS subject of the watering events")
+	pflag.StringVar(&opt.NATS.WateringStream, "nats-watering-stream", DefaultWateringStream, "NATS stream name of the watering events")
+	pflag.DurationVar(&opt.DeviceUpInterval, "device-up-interval", DefaultDeviceUpInterval, "How frequently grow_device_up is written for the devices that sent heartbeats")
 	pflag.StringVar(&opt.NATS.StreamName, "nats-stream", "PlantReadings", "NATS stream name to publish messages")
 	pflag.StringVar(&opt.NATS.StreamSubject, "nats-stream-sub", "PlantReadings.home", "NATS stream subject name to publish messages")
 	pflag.StringVar(&opt.NATS.URL, "nats-url", DefaultNATSURL, "NATS URL to publish the messages")
+	pflag.StringVar(&opt.NATS.CAFile, "nats-ca", "", "CA certificate file to verify the NATS server")
+	pflag.StringVar(&opt.NATS.CertFile, "nats-cert", "", "Client certificate file for the NATS server")
+	pflag.StringVar(&opt.NATS.KeyFile, "nats-key", "", "Client key file for the NATS server")
+	pflag.StringVar(&opt.NATS.CredsFile, "nats-creds", "", "NATS credentials file with the user JWT and NKey seed")
+	pflag.StringVar(&opt.NATS.NKeyFile, "nats-nkey", "", "NATS NKey seed file")
+	pflag.StringVar(&opt.NATS.Token, "nats-token", "", "NATS authentication token")
+	pflag.StringVar(&opt.NATS.User, "nats-user", "", "NATS user")
+	pflag.StringVar(&opt.NATS.Password, "nats-password", "", "NATS password")
 	pflag.StringVar(&opt.Prometheus.URL, "prom-url", DefaultPrometheusURL, "Prometheus URL to send metrics")
 	pflag.StringVar(&opt.ProbesAddr, "probes-addr", ":8222", "The bind address for health and readiness probes")
 	pflag.StringVar(&logLevelValue, "log-level", "info", "Changes the log level like info, warn, error, and debug")
@@ -50,5 +77,9 @@ func Get() (Options, error) {
 	}
 	opt.LogLevel = levelVar
 
-	return opt, nil
+	if opt.DeviceUpInterval <= 0 {
+		return opt, fmt.Errorf("invalid device up interval %s, must be greater than 0", opt.DeviceUpInterval)
+	}
+
+	return opt, opt.NATS.Validate()
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/castai/promwrite"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/grow/ingestion-service/pkg/options"
+)
+
+// WateringEvent is a dose of water given, or skipped, by a monitor.
+type WateringEvent struct {
+	Device
+
+	Timestamp time.Time
+	Sensor    string
+	Pump      int
+	Moisture  float64
+	Dose      int
+	Duration  float64
+	DryRun    bool `json:"dry_run"`
+	Skipped   string
+	Error     string
+}
+
+// consumeWatering writes the watering events as grow_watering_dose_seconds,
+// how long the pump ran, labeled by the status of the dose. Events are read
+// from the watering stream with a durable consumer, like the readings, so
+// the ones sent while the service is down are written once it's back.
+func consumeWatering(nc *nats.Conn, options options.Options) (jetstream.ConsumeContext, error) {
+	js, _ := jetstream.New(nc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cons, err := js.CreateConsumer(ctx, options.NATS.WateringStream, jetstream.ConsumerConfig{
+		Durable:       "GrowWateringIngestion",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: options.NATS.WateringSubject,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create watering consumer: %w", err)
+	}
+
+	return cons.Consume(func(msg jetstream.Msg) {
+		slog.Debug("received watering event", "msg", string(msg.Data()))
+
+		event := WateringEvent{}
+		err := json.Unmarshal(msg.Data(), &event)
+		if err != nil || event.Device.Device == "" || event.Sensor == "" {
+			slog.Warn("watering event with invalid format - ignoring it", "error", err)
+			msg.Ack()
+			return
+		}
+
+		err = storeMetrics([]promwrite.TimeSeries{wateringSeries(event)}, options.Prometheus)
+		if err != nil {
+			slog.Warn("could not write watering event to prometheus", "device", event.Device.Device, "sensor", event.Sensor, "error", err)
+			return
+		}
+		msg.Ack()
+	})
+}
+
+func wateringSeries(event WateringEvent) promwrite.TimeSeries {
+	// doses are given, skipped for a reason like daily-max, or failed
+	status := "given"
+	if event.Error != "" {
+		status = "error"
+	} else if event.Skipped != "" {
+		status = event.Skipped
+	}
+	labels := append(deviceLabels(event.Device),
+		promwrite.Label{Name: "name", Value: event.Sensor},
+		promwrite.Label{Name: "pump", Value: strconv.Itoa(event.Pump)},
+		promwrite.Label{Name: "dose", Value: strconv.Itoa(event.Dose)},
+		promwrite.Label{Name: "status", Value: status},
+		promwrite.Label{Name: "dry_run", Value: strconv.FormatBool(event.DryRun)},
+	)
+	return timeSeries("grow_watering_dose_seconds", labels, event.Timestamp, event.Duration)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+// calibrate walks the user through measuring the sensor frequency with dry
+// and wet soil, the bounds used to compute the moisture percentage.
+func calibrate(args []string) error {
+	opt, err := options.GetCalibrate(args)
+	if err != nil {
+		return err
+	}
+
+	var reader MoistureReader
+	switch opt.ReaderBackend {
+	case options.Simulated:
+		reader = grow.NewSimulatedMoistureReader(opt.Sensor, options.MaxMoisture, options.MinMoisture, grow.DefaultSimulationConfig)
+	default:
+		config := grow.MeasurementConfig{
+			Window:     opt.SamplingWindow,
+			StaleAfter: grow.DefaultMeasurementConfig.StaleAfter,
+		}
+		reader, err = grow.NewGrowHatMoistureReader(opt.Sensor, opt.GPIOChip, opt.Connector, options.MaxMoisture, options.MinMoisture, config)
+		if err != nil {
+			return err
+		}
+	}
+	defer reader.Close()
+
+	in := bufio.NewReader(os.Stdin)
+	fmt.Printf("Calibrating sensor %s on connector %d\n\n", opt.Sensor, opt.Connector)
+
+	fmt.Print("1. Take the sensor out of the soil, dry it and leave it in the air. Press Enter when ready.")
+	err = waitForEnter(in)
+	if err != nil {
+		return err
+	}
+	dry, err := sample(reader, opt.Duration, opt.SamplingWindow)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   dry frequency: %.2f Hz\n\n", dry)
+
+	fmt.Print("2. Submerge the sensor in water, up to the line. Press Enter when ready.")
+	err = waitForEnter(in)
+	if err != nil {
+		return err
+	}
+	wet, err := sample(reader, opt.Duration, opt.SamplingWindow)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   wet frequency: %.2f Hz\n\n", wet)
+
+	if dry <= wet {
+		return fmt.Errorf("dry frequency %.2f Hz should be higher than the wet frequency %.2f Hz, check the sensor and try again", dry, wet)
+	}
+
+	if opt.ConfigFile != "" {
+		err = options.SaveCalibration(opt.ConfigFile, opt.Sensor, opt.Connector, dry, wet)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved the calibration to %s\n", opt.ConfigFile)
+	}
+	fmt.Printf("Suggested flag: --sensor \"%s\"\n", options.SensorFlag(opt.Sensor, opt.Connector, dry, wet))
+	return nil
+}
+
+func waitForEnter(in *bufio.Reader) error {
+	_, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("could not read input: %w", err)
+	}
+	return nil
+}
+
+// sample returns the average frequency of the windows measured during the
+// given duration, weighted by their length.
+func sample(reader MoistureReader, duration time.Duration, window time.Duration) (float64, error) {
+	fmt.Printf("   sampling for %s", duration)
+
+	// discards the window in progress when the sensor was moved
+	time.Sleep(window)
+	last := reader.Measure().Time
+
+	pulses := 0.0
+	length := time.Duration(0)
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		time.Sleep(window)
+		fmt.Print(".")
+		m := reader.Measure()
+		if m.Stale || !m.Time.After(last) {
+			continue
+		}
+		last = m.Time
+		pulses += m.Frequency * m.Window.Seconds()
+		length += m.Window
+	}
+	fmt.Println()
+
+	if length == 0 {
+		return 0, fmt.Errorf("no pulses read from sensor, check the connection")
+	}
+	return pulses / length.Seconds(), nil
+}
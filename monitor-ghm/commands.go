@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/remote"
+	"github.com/nats-io/nats.go"
+)
+
+// maxPumpRun is the longest pump run requested by a command.
+const maxPumpRun = time.Minute
+
+// currentOptions are the options applied last, by the config watchers or
+// the commands.
+type currentOptions struct {
+	mu  sync.Mutex
+	opt options.Options
+}
+
+func (c *currentOptions) Get() options.Options {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opt
+}
+
+func (c *currentOptions) Set(opt options.Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opt = opt
+}
+
+// readingResult is a reading in the reply of the read command, values of
+// unhealthy sensors are null.
+type readingResult struct {
+	Name      string    `json:"name"`
+	Metric    string    `json:"metric"`
+	Unit      string    `json:"unit"`
+	Value     *float64  `json:"value"`
+	Raw       *float64  `json:"raw"`
+	Health    string    `json:"health"`
+	Timestamp time.Time `json:"timestamp"`
+	Error     string    `json:"error,omitempty"` // of the reading or the publishers
+}
+
+func setupCommands(ctx context.Context, nc *nats.Conn, current *currentOptions, apply func(options.Options), sched *scheduler, publishers *publisherSet, waterers *waterSet) {
+	opt := current.Get()
+	if opt.NATS.CommandSecret == "" {
+		slog.Warn("commands authorized only by the NATS permissions, set --nats-command-secret to require a secret")
+	}
+	commands := remote.NewCommands(opt.NATS.CommandSubject, opt.Device.ID, opt.NATS.CommandSecret, commandHandlers(ctx, current, apply, sched, publishers, waterers))
+	_, err := commands.Subscribe(nc)
+	if err != nil {
+		slog.Error("could not serve commands", "error", err)
+	}
+}
+
+// commandHandlers returns the handlers of the commands, changing the options
+// with apply. Changes are kept until the next change of the config file or
+// the remote config.
+func commandHandlers(ctx context.Context, current *currentOptions, apply func(options.Options), sched *scheduler, publishers *publisherSet, waterers *waterSet) map[string]remote.CommandHandler {
+	return map[string]remote.CommandHandler{
+		// reads and publishes all the sensors now
+		"read": func(json.RawMessage) (any, error) {
+			results := []readingResult{}
+			for _, r := range sched.readers.Readers() {
+				reading, err := sched.ReadNow(ctx, r)
+				result := readingResult{
+					Name:      reading.Name,
+					Metric:    reading.Metric,
+					Unit:      reading.Unit,
+					Value:     jsonFloat(reading.Value),
+					Raw:       jsonFloat(reading.Raw),
+					Health:    reading.Health,
+					Timestamp: reading.Timestamp,
+				}
+				if err != nil {
+					result.Error = err.Error()
+				}
+				results = append(results, result)
+			}
+			return results, nil
+		},
+
+		"config": func(json.RawMessage) (any, error) {
+			return configResult(current.Get()), nil
+		},
+
+		"set-frequency": func(args json.RawMessage) (any, error) {
+			var a struct {
+				Frequency string `json:"frequency"`
+			}
+			err := remote.ParseArgs(args, &a)
+			if err != nil {
+				return nil, err
+			}
+			frequency, err := time.ParseDuration(a.Frequency)
+			if err != nil || frequency <= 0 {
+				return nil, fmt.Errorf("invalid frequency: %s", a.Frequency)
+			}
+			opt := current.Get()
+			opt.Frequency = frequency
+			err = opt.Validate()
+			if err != nil {
+				return nil, err
+			}
+			apply(opt)
+			return map[string]string{"frequency": frequency.String()}, nil
+		},
+
+		"set-log-level": func(args json.RawMessage) (any, error) {
+			var a struct {
+				Level string `json:"level"`
+			}
+			err := remote.ParseArgs(args, &a)
+			if err != nil {
+				return nil, err
+			}
+			level := current.Get().LogLevel
+			err = level.UnmarshalText([]byte(a.Level))
+			if err != nil {
+				return nil, fmt.Errorf("invalid log level: %s", a.Level)
+			}
+			return map[string]string{"level": level.Level().String()}, nil
+		},
+
+		"run-pump": func(args json.RawMessage) (any, error) {
+			var a struct {
+				Pump    int     `json:"pump"`
+				Seconds float64 `json:"seconds"`
+			}
+			err := remote.ParseArgs(args, &a)
+			if err != nil {
+				return nil, err
+			}
+			duration := time.Duration(a.Seconds * float64(time.Second))
+			if duration <= 0 || duration > maxPumpRun {
+				return nil, fmt.Errorf("invalid seconds %g, must be between 0 and %g", a.Seconds, maxPumpRun.Seconds())
+			}
+			sensor, err := waterers.RunPump(a.Pump, duration)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"pump": a.Pump, "sensor": sensor, "seconds": duration.Seconds()}, nil
+		},
+
+		// applies the publishers changes of the config file
+		"restart-publishers": func(json.RawMessage) (any, error) {
+			opt := current.Get()
+			ctx, cancel := context.WithTimeout(ctx, opt.ShutdownTimeout)
+			defer cancel()
+			err := publishers.Restart(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"publishers": opt.Publishers}, nil
+		},
+	}
+}
+
+// configResult returns the options with the names of the config file,
+// leaving out the credentials.
+func configResult(opt options.Options) map[string]any {
+	sensors := []map[string]any{}
+	for _, s := range opt.Sensors {
+		sensor := map[string]any{
+			"name":      s.Name,
+			"backend":   opt.SensorBackend(s),
+			"metric":    opt.SensorMetric(s),
+			"connector": s.Connector,
+			"address":   s.Address,
+			"interval":  opt.SensorInterval(s).String(),
+			"jitter":    opt.SensorJitter(s).String(),
+			"filters":   s.Filters,
+			// the file uses the frequencies read with dry and wet soil
+			"minMoisture": s.MaxMoisture,
+			"maxMoisture": s.MinMoisture,
+		}
+		if opt.SensorBackend(s) == options.ADS1115 {
+			sensor["adc"] = map[string]any{
+				"channel":    s.ADC.Channel,
+				"gain":       s.ADC.Gain,
+				"sampleRate": s.ADC.SampleRate,
+				"dryVoltage": s.ADC.DryVoltage,
+				"wetVoltage": s.ADC.WetVoltage,
+			}
+		}
+		if s.Watering.Pump != 0 {
+			sensor["watering"] = map[string]any{
+				"pump":      s.Watering.Pump,
+				"speed":     s.Watering.Speed,
+				"threshold": s.Watering.Threshold,
+				"target":    s.Watering.Target,
+				"dose":      s.Watering.Dose.String(),
+				"soak":      s.Watering.Soak.String(),
+				"maxDoses":  s.Watering.MaxDoses,
+				"cooldown":  s.Watering.Cooldown.String(),
+				"dailyMax":  s.Watering.DailyMax,
+			}
+		}
+		sensors = append(sensors, sensor)
+	}
+	return map[string]any{
+		"device": map[string]any{
+			"id":       opt.Device.ID,
+			"hostname": opt.Device.Hostname,
+			"location": opt.Device.Location,
+			"labels":   opt.Device.Labels,
+		},
+		"frequency":      opt.Frequency.String(),
+		"jitter":         opt.Jitter.String(),
+		"workers":        opt.Workers,
+		"logLevel":       opt.LogLevel.Level().String(),
+		"readerBackend":  opt.ReaderBackend,
+		"publishers":     opt.Publishers,
+		"pumpDriver":     opt.PumpDriver,
+		"wateringDryRun": opt.WateringDryRun,
+		"sensors":        sensors,
+	}
+}
+
+// jsonFloat returns nil for the values JSON can't encode.
+func jsonFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
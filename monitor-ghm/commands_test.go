@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/remote"
+	"github.com/grow/monitor-ghm/pkg/water"
+)
+
+// commandsTest runs the command handlers of a simulated monitor with a fake
+// pump on pump 1 of pilea.
+type commandsTest struct {
+	commands *remote.Commands
+	current  *currentOptions
+	applied  []options.Options
+	events   chan water.Event
+}
+
+func newCommandsTest(t *testing.T) *commandsTest {
+	t.Helper()
+	readers, _ := fakeReaders(t, time.Minute, "pilea", "basil")
+	opt := simulatedOptions(time.Minute, "pilea", "basil")
+	opt.Device = options.Device{ID: "pi.one", Hostname: "pi"}
+	opt.LogLevel = &slog.LevelVar{}
+	opt.Publishers = []string{options.Console}
+	opt.PumpDriver = options.FakePump
+	opt.Samples = 1
+	opt.ShutdownTimeout = time.Second
+	opt.Sensors[0].Watering = options.WateringConfig{Pump: 1, Speed: 1, Config: water.Config{Threshold: 30, Target: 40, Dose: time.Second, MaxDoses: 1}}
+
+	ct := &commandsTest{current: &currentOptions{opt: opt}, events: make(chan water.Event, 1)}
+	waterers := newWaterSet(readers, func(e water.Event) { ct.events <- e }, opt)
+	err := waterers.Apply(opt)
+	if err != nil {
+		t.Fatalf("waterSet.Apply() error = %v", err)
+	}
+	t.Cleanup(func() { waterers.Close() })
+
+	publishers := &publisherSet{opt: opt}
+	apply := func(opt options.Options) {
+		ct.applied = append(ct.applied, opt)
+		ct.current.Set(opt)
+	}
+	handlers := commandHandlers(context.Background(), ct.current, apply, testScheduler(readers, nil, 1), publishers, waterers)
+	ct.commands = remote.NewCommands("grow.cmd.{device}", opt.Device.ID, "", handlers)
+	return ct
+}
+
+// request sends a request to the commands like a NATS client, returning the
+// decoded reply.
+func (ct *commandsTest) request(t *testing.T, command, args string) map[string]any {
+	t.Helper()
+	data := ""
+	if args != "" {
+		data = `{"args": ` + args + `}`
+	}
+	reply, err := json.Marshal(ct.commands.Handle("grow.cmd.pi_one."+command, []byte(data)))
+	if err != nil {
+		t.Fatalf("could not marshal reply: %v", err)
+	}
+	decoded := map[string]any{}
+	err = json.Unmarshal(reply, &decoded)
+	if err != nil {
+		t.Fatalf("could not unmarshal reply: %v", err)
+	}
+	return decoded
+}
+
+func TestCommandHandlers(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    string
+		want    string // result, as JSON
+		wantErr string
+	}{
+		{
+			name:    "set frequency",
+			command: "set-frequency",
+			args:    `{"frequency": "30s"}`,
+			want:    `{"frequency":"30s"}`,
+		},
+		{
+			name:    "invalid frequency",
+			command: "set-frequency",
+			args:    `{"frequency": "-1s"}`,
+			wantErr: "invalid frequency: -1s",
+		},
+		{
+			name:    "unknown argument",
+			command: "set-frequency",
+			args:    `{"interval": "30s"}`,
+			wantErr: "invalid arguments",
+		},
+		{
+			name:    "set log level",
+			command: "set-log-level",
+			args:    `{"level": "debug"}`,
+			want:    `{"level":"DEBUG"}`,
+		},
+		{
+			name:    "invalid log level",
+			command: "set-log-level",
+			args:    `{"level": "loud"}`,
+			wantErr: "invalid log level: loud",
+		},
+		{
+			name:    "run pump",
+			command: "run-pump",
+			args:    `{"pump": 1, "seconds": 0.01}`,
+			want:    `{"pump":1,"seconds":0.01,"sensor":"pilea"}`,
+		},
+		{
+			name:    "run pump too long",
+			command: "run-pump",
+			args:    `{"pump": 1, "seconds": 120}`,
+			wantErr: "invalid seconds 120, must be between 0 and 60",
+		},
+		{
+			name:    "run unused pump",
+			command: "run-pump",
+			args:    `{"pump": 2, "seconds": 1}`,
+			wantErr: "pump 2 not used by any sensor",
+		},
+		{
+			name:    "restart publishers",
+			command: "restart-publishers",
+			want:    `{"publishers":["console"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := newCommandsTest(t)
+			reply := ct.request(t, tt.command, tt.args)
+			if tt.wantErr != "" {
+				errMsg, _ := reply["error"].(string)
+				if reply["ok"] == true || !strings.Contains(errMsg, tt.wantErr) {
+					t.Fatalf("reply = %v, want error %q", reply, tt.wantErr)
+				}
+				return
+			}
+			if reply["ok"] != true {
+				t.Fatalf("reply = %v, want ok", reply)
+			}
+			result, err := json.Marshal(reply["result"])
+			if err != nil {
+				t.Fatalf("could not marshal result: %v", err)
+			}
+			if string(result) != tt.want {
+				t.Errorf("result = %s, want %s", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandSetFrequency(t *testing.T) {
+	ct := newCommandsTest(t)
+	ct.request(t, "set-frequency", `{"frequency": "30s"}`)
+	if len(ct.applied) != 1 || ct.applied[0].Frequency != 30*time.Second {
+		t.Fatalf("applied %v, want the frequency 30s", ct.applied)
+	}
+	if got := ct.current.Get().Frequency; got != 30*time.Second {
+		t.Errorf("current frequency = %s, want 30s", got)
+	}
+
+	ct.request(t, "set-frequency", `{"frequency": "soon"}`)
+	if len(ct.applied) != 1 {
+		t.Errorf("invalid frequency applied")
+	}
+}
+
+func TestCommandRunPump(t *testing.T) {
+	ct := newCommandsTest(t)
+	reply := ct.request(t, "run-pump", `{"pump": 1, "seconds": 0.01}`)
+	if reply["ok"] != true {
+		t.Fatalf("reply = %v, want ok", reply)
+	}
+	select {
+	case e := <-ct.events:
+		if e.Sensor != "pilea" || e.Pump != 1 || e.Error != "" {
+			t.Errorf("event = %+v, want a run of pump 1 of pilea", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pump didn't run")
+	}
+}
+
+func TestCommandRead(t *testing.T) {
+	ct := newCommandsTest(t)
+	reply := ct.request(t, "read", "")
+	results, _ := reply["result"].([]any)
+	if reply["ok"] != true || len(results) != 2 {
+		t.Fatalf("reply = %v, want the readings of pilea and basil", reply)
+	}
+	pilea, _ := results[0].(map[string]any)
+	if pilea["name"] != "pilea" || pilea["health"] != "ok" || pilea["value"] != 0.0 {
+		t.Errorf("pilea = %v, want a healthy reading of 0", pilea)
+	}
+}
+
+func TestConfigResult(t *testing.T) {
+	fc, err := options.ParseConfig([]byte(`
+sensors:
+  - name: pilea
+    connector: 1
+    minMoisture: 120
+    maxMoisture: 950
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	sensors, err := fc.SensorsOptions()
+	if err != nil {
+		t.Fatalf("SensorsOptions() error = %v", err)
+	}
+	opt := simulatedOptions(time.Minute)
+	opt.LogLevel = &slog.LevelVar{}
+	opt.Sensors = sensors
+
+	// the file's minMoisture and maxMoisture come back unchanged
+	result := configResult(opt)
+	sensor := result["sensors"].([]map[string]any)[0]
+	if sensor["minMoisture"] != 120.0 || sensor["maxMoisture"] != 950.0 {
+		t.Errorf("configResult() minMoisture %v, maxMoisture %v, want 120, 950", sensor["minMoisture"], sensor["maxMoisture"])
+	}
+	if _, found := sensor["watering"]; found {
+		t.Errorf("configResult() has the watering of a sensor without a pump")
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("could not marshal config: %v", err)
+	}
+	for _, secret := range []string{"password", "token", "secret"} {
+		if strings.Contains(strings.ToLower(string(data)), secret) {
+			t.Errorf("configResult() includes %s", secret)
+		}
+	}
+}
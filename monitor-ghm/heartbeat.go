@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+	"github.com/grow/monitor-ghm/pkg/telemetry"
+)
+
+// version is set when building, see the Makefile
+var version = "dev"
+
+var startTime = time.Now()
+
+// sendHeartbeats publishes the status of the monitor every interval, until
+// ctx is done.
+func sendHeartbeats(ctx context.Context, send func(telemetry.Heartbeat) error, opt options.Options, readers *readerSet) {
+	ticker := time.NewTicker(opt.NATS.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		err := send(heartbeat(opt, readers))
+		if err != nil {
+			slog.Warn("could not send heartbeat", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func heartbeat(opt options.Options, readers *readerSet) telemetry.Heartbeat {
+	sensors := []string{}
+	for _, r := range readers.Readers() {
+		sensors = append(sensors, r.Name())
+	}
+	return telemetry.Heartbeat{
+		Device:        opt.Device.ID,
+		Hostname:      opt.Device.Hostname,
+		Location:      opt.Device.Location,
+		Labels:        opt.Device.Labels,
+		Timestamp:     time.Now(),
+		Interval:      opt.NATS.HeartbeatInterval.Seconds(),
+		Version:       version,
+		Uptime:        time.Since(startTime).Seconds(),
+		Sensors:       sensors,
+		PublishErrors: publish.Failures(),
+		System:        telemetry.Collect("/"),
+	}
+}
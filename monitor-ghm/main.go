@@ -1,111 +1,364 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/grow/monitor-ghm/pkg/grow"
 	"github.com/grow/monitor-ghm/pkg/options"
 	"github.com/grow/monitor-ghm/pkg/publish"
+	"github.com/grow/monitor-ghm/pkg/remote"
+	"github.com/grow/monitor-ghm/pkg/water"
+	"github.com/nats-io/nats.go"
 )
 
+// Reader reads a sensor, it is a MoistureReader, an AnalogReader or a
+// grow.ValueReader.
+type Reader interface {
+	Close() error
+	Name() string
+}
+
+// AnalogReader reads the voltage of an analog soil moisture sensor.
+type AnalogReader interface {
+	Calibrate(dryVoltage, wetVoltage float64)
+	Close() error
+	Moisture(voltage float64) float64
+	Name() string
+	ReadVoltage() (float64, error)
+}
+
 type MoistureReader interface {
+	Calibrate(minMoisture, maxMoisture float64)
 	Close() error
+	Measure() grow.Measurement
 	Name() string
 	Read() float64
 }
 
-type Publisher func(name string, value float64) error
-
 func main() {
-	options, err := options.Get()
+	if len(os.Args) > 1 && os.Args[1] == options.Calibrate {
+		err := calibrate(os.Args[2:])
+		if err != nil {
+			fmt.Println("calibration failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	opt, err := options.Get()
 	if err != nil {
 		fmt.Println("invalid options:", err)
 		os.Exit(1)
 	}
 	slogOptions := &slog.HandlerOptions{
-		Level: options.LogLevel,
+		Level: opt.LogLevel,
 	}
 	handler := slog.NewTextHandler(os.Stdout, slogOptions)
 	slog.SetDefault(slog.New(handler))
 
-	slog.Info("sensors configured", "sensors", options.Sensors)
-	slog.Info("publishers configured", "publishers", options.Publishers)
+	// connects to NATS, shared by the publisher and the remote config
+	nc := setupNATS(opt)
+
+	// loads the sensors from the remote config, falling back to the flags
+	local := opt
+	kvConfig, opt := setupRemoteConfig(nc, opt)
+
+	slog.Info("sensors configured", "sensors", opt.Sensors)
+	slog.Info("publishers configured", "publishers", opt.Publishers)
 
 	// starts sensor readers
-	readers := setupReaders(options.Sensors)
-	defer func() {
-		for _, r := range readers {
-			r.Close()
-		}
-	}()
+	readers := setupReaders(opt)
 
 	// initializes the publishers
-	publishers := setupPublishers(options)
+	publishers := setupPublishers(nc, opt)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// main loop, read sensor values and publish
-	go func() {
-		for {
-			readAndPublish(readers, publishers, options.Frequency)
+	// reads the sensors once and exits, for cron and battery powered setups
+	if opt.Once {
+		code := readOnce(ctx, readers, publishers.Publishers(), opt)
+		ctx, cancel := context.WithTimeout(context.Background(), opt.ShutdownTimeout)
+		defer cancel()
+		err := shutdown(ctx, publishers, readers, nc)
+		if err != nil && code == exitOK {
+			code = exitPublishFailed
 		}
-	}()
+		slog.Info("readings done", "status", code)
+		os.Exit(code)
+	}
+
+	// waters the plants of the sensors with a pump, fed by the readings
+	waterers, wateringEvents := setupWatering(nc, opt, readers)
+	publishers.AddHook(waterers.Publish)
+
+	// current options, changed by the config watchers and the commands
+	current := &currentOptions{opt: opt}
 
-	// waits for termination
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
+	applyOptions := func(o options.Options) {
+		prev := current.Get()
+		err := readers.Apply(o)
+		if err != nil {
+			slog.Error("could not apply sensors", "error", err)
+		}
+		err = waterers.Apply(o)
+		if err != nil {
+			slog.Error("could not apply watering", "error", err)
+		}
+		if publishersChanged(o, publishers.Options()) {
+			slog.Warn("publishers changes are applied only after a restart or the restart-publishers command")
+		}
+		// warns once per change, not on every reload after it
+		if o.PumpDriver != prev.PumpDriver || o.WateringDryRun != prev.WateringDryRun {
+			slog.Warn("pump driver and watering dry run changes are applied only after a restart")
+		}
+		if o.StatusAddress != prev.StatusAddress {
+			slog.Warn("status address changes are applied only after a restart")
+		}
+		slog.Info("sensors configured", "sensors", o.Sensors)
+		current.Set(o)
+	}
+
+	watchers := []func(){}
+	layers := &configLayers{local: local, kv: kvConfig, apply: applyOptions}
+
+	// reloads the sensors and the frequency when the config file changes
+	if opt.ConfigFile != "" {
+		stop := options.WatchConfig(opt.ConfigFile, options.DefaultConfigWatchInterval, local, layers.SetLocal)
+		watchers = append(watchers, stop)
+	}
+
+	// reloads the sensors and the frequency when the remote config changes
+	if kvConfig != nil {
+		stop, err := kvConfig.Watch(layers.Local, layers.Reload)
+		if err != nil {
+			slog.Error("could not watch remote config", "error", err)
+		} else {
+			watchers = append(watchers, stop)
+		}
+	}
+
+	// publishes the device status, when connected to NATS
+	if nc != nil && opt.NATS.HeartbeatInterval > 0 {
+		go sendHeartbeats(ctx, publish.NewHeartbeatPublisher(nc, opt.NATS.HeartbeatSubject, opt.Device), opt, readers)
+	}
+
+	// reads the sensors on their schedule, and on the read command
+	sched := newScheduler(readers, publishers.Publishers, opt.Workers)
+
+	// answers the commands, when connected to NATS
+	if nc != nil && opt.NATS.CommandSubject != "" {
+		setupCommands(ctx, nc, current, applyOptions, sched, publishers, waterers)
+	}
+
+	// serves the status page, for debugging next to the plants
+	status := setupStatus(opt, current, readers)
+
+	// main loop, read sensor values and publish until terminated
+	sched.Run(ctx)
+
+	slog.Info("shutting down", "timeout", opt.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), opt.ShutdownTimeout)
+	defer cancel()
+
+	// stops the config changes first, so no reader is opened again
+	for _, stop := range watchers {
+		stop()
+	}
+	err = sched.Wait(ctx)
+	if err != nil {
+		slog.Warn("readings in flight cancelled", "error", err)
+	}
+	err = waterers.Close()
+	if err != nil {
+		slog.Warn("could not close pumps", "error", err)
+	}
+	if wateringEvents != nil {
+		err := wateringEvents.Close(ctx)
+		if err != nil {
+			slog.Warn("could not close watering events publisher", "error", err)
+		}
+	}
+	if status != nil {
+		err := status.Shutdown(ctx)
+		if err != nil {
+			slog.Warn("could not stop status server", "error", err)
+		}
+	}
+	shutdown(ctx, publishers, readers, nc)
+	slog.Info("stopped")
 }
 
-func setupReaders(plants []options.Sensors) []MoistureReader {
-	readers := make([]MoistureReader, len(plants))
-	for i := range plants {
-		r, err := grow.NewGrowHatMoistureReader(plants[i].Name, plants[i].Connector, plants[i].MinMoisture, plants[i].MaxMoisture)
+// shutdown closes the publishers, sending their pending readings, then the
+// readers and the NATS connection. It returns the errors of the publishers.
+func shutdown(ctx context.Context, publishers *publisherSet, readers *readerSet, nc *nats.Conn) error {
+	publishErr := publishers.Close(ctx)
+	err := readers.Close()
+	if err != nil {
+		slog.Warn("could not close readers", "error", err)
+	}
+	if nc != nil {
+		err := remote.Drain(ctx, nc)
 		if err != nil {
-			slog.Error("could not init reader", "plant", plants[i].Name, "error", err)
-			os.Exit(1)
+			slog.Warn("could not drain NATS", "error", err)
 		}
-		readers[i] = r
+	}
+	return publishErr
+}
+
+// publishersChanged tells if the publishers or their settings are different,
+// which can't be applied while running.
+func publishersChanged(a, b options.Options) bool {
+	return !slices.Equal(a.Publishers, b.Publishers) || a.NATS != b.NATS || a.MQTT != b.MQTT ||
+		a.MetricsAddress != b.MetricsAddress || !reflect.DeepEqual(a.HTTP, b.HTTP) || a.Influx != b.Influx ||
+		!reflect.DeepEqual(a.Device, b.Device)
+}
+
+func setupReaders(opt options.Options) *readerSet {
+	readers := &readerSet{}
+	err := readers.Apply(opt)
+	if err != nil {
+		slog.Error("could not init readers", "error", err)
+		readers.Close()
+		os.Exit(1)
 	}
 	return readers
 }
 
-func setupPublishers(opt options.Options) []publish.Publisher {
-	publishers := []publish.Publisher{}
-	for _, pt := range opt.Publishers {
-		switch pt {
-		case options.Console:
-			publishers = append(publishers, publish.NewConsolePublisher())
-		case options.NATS:
-			natsPub, err := publish.NewNATSPublisher(opt.NATS)
-			if err != nil {
-				slog.Error("could not init NATS publisher", "error", err)
-				os.Exit(1)
+func setupWatering(nc *nats.Conn, opt options.Options, readers *readerSet) (*waterSet, *publish.WateringPublisher) {
+	var send func(water.Event) error
+	var events *publish.WateringPublisher
+	if nc != nil {
+		var err error
+		events, err = openWateringPublisher(nc, opt)
+		if err != nil {
+			slog.Error("could not init watering events publisher, only logging them", "error", err)
+		} else {
+			send = func(e water.Event) error {
+				return events.Publish(context.Background(), e)
 			}
-			publishers = append(publishers, natsPub)
 		}
 	}
-	return publishers
+	waterers := newWaterSet(readers, wateringNotifier(send), opt)
+	err := waterers.Apply(opt)
+	if err != nil {
+		slog.Error("could not init watering", "error", err)
+		waterers.Close()
+		readers.Close()
+		os.Exit(1)
+	}
+	return waterers, events
 }
 
-func readAndPublish(readers []MoistureReader, publishers []publish.Publisher, frequency time.Duration) {
-	for _, reader := range readers {
-		reading := reader.Read()
-		slog.Debug("reading", "name", reader.Name(), "value", reading)
+// setupStatus serves the status page when an address is set, a failure
+// doesn't stop the monitor.
+func setupStatus(opt options.Options, current *currentOptions, readers *readerSet) *http.Server {
+	if opt.StatusAddress == "" {
+		return nil
+	}
+	server, err := serveStatus(opt.StatusAddress, current, readers)
+	if err != nil {
+		slog.Error("could not serve status", "error", err)
+		return nil
+	}
+	return server
+}
 
-		for _, publisher := range publishers {
-			err := publisher(publish.Reading{
-				Timestamp: time.Now(),
-				Name:      reader.Name(),
-				Value:     reading,
-			})
-			if err != nil {
-				slog.Error("could not publish", "error", err)
-			}
+func setupNATS(opt options.Options) *nats.Conn {
+	natsPublisher := slices.Contains(opt.Publishers, options.NATS)
+	if !natsPublisher && opt.NATS.KVBucket == "" {
+		return nil
+	}
+
+	nc, err := remote.Connect(opt.NATS)
+	if err != nil {
+		if natsPublisher {
+			slog.Error("could not connect to NATS", "error", err)
+			os.Exit(1)
 		}
+		slog.Warn("could not connect to NATS", "error", err)
+		return nil
+	}
+	return nc
+}
+
+// configLayers applies the remote config on top of the options of the flags
+// and the config file, so the changes of each one are applied on the latest
+// of the other.
+type configLayers struct {
+	mu    sync.Mutex
+	local options.Options
+	kv    *remote.KVConfig
+	apply func(options.Options)
+}
+
+// Local returns the options of the flags and the config file.
+func (l *configLayers) Local() options.Options {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.local
+}
+
+// SetLocal applies new options of the flags and the config file.
+func (l *configLayers) SetLocal(opt options.Options) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.local = opt
+	l.applyLayers()
+}
+
+// Reload applies the latest remote config.
+func (l *configLayers) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.applyLayers()
+}
+
+func (l *configLayers) applyLayers() {
+	opt := l.local
+	if l.kv != nil {
+		remoteOpt, err := l.kv.Apply(opt)
+		if err != nil {
+			slog.Error("could not apply remote config, using the local one", "error", err)
+		} else {
+			opt = remoteOpt
+		}
+	}
+	l.apply(opt)
+}
+
+func setupRemoteConfig(nc *nats.Conn, opt options.Options) (*remote.KVConfig, options.Options) {
+	if nc == nil || opt.NATS.KVBucket == "" {
+		return nil, opt
+	}
+
+	kvConfig, err := remote.NewKVConfig(nc, opt.NATS.KVBucket, opt.Device.ID)
+	if err != nil {
+		slog.Warn("remote config unavailable, using local config", "error", err)
+		return nil, opt
+	}
+	remoteOpt, err := kvConfig.Load(opt)
+	if err != nil {
+		slog.Warn("remote config unavailable, using local config", "error", err)
+		return kvConfig, opt
+	}
+	return kvConfig, remoteOpt
+}
+
+func setupPublishers(nc *nats.Conn, opt options.Options) *publisherSet {
+	publishers, closers, err := newPublishers(nc, opt)
+	if err != nil {
+		slog.Error("could not init publishers", "error", err)
+		os.Exit(1)
 	}
-	time.Sleep(frequency)
+	return &publisherSet{nc: nc, opt: opt, publishers: publishers, closers: closers}
 }
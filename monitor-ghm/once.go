@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// exit codes of the --once mode
+const (
+	exitOK             = 0
+	exitPublishFailed  = 1
+	exitSensorsFailing = 2
+)
+
+// readOnce takes opt.Samples readings from each sensor once the pulse
+// frequency windows settle, publishes them and returns the exit code.
+func readOnce(ctx context.Context, readers *readerSet, publishers []publish.Publisher, opt options.Options) int {
+	waitSettled(ctx, readers.Readers(), opt.SamplingWindow+opt.StaleTimeout)
+
+	code := exitOK
+	for i := 0; i < opt.Samples && ctx.Err() == nil; i++ {
+		if i > 0 {
+			// waits for a new window, so samples aren't repeated
+			sleep(ctx, opt.SamplingWindow)
+		}
+		for _, r := range readers.Readers() {
+			reading, err := readAndPublish(ctx, r, publishers)
+			if err != nil {
+				code = exitPublishFailed
+			} else if reading.Health != string(grow.HealthOK) && code == exitOK {
+				code = exitSensorsFailing
+			}
+		}
+	}
+	return code
+}
+
+// waitSettled waits until every reader measured a full window after it was
+// opened, or the timeout elapses for the ones without pulses.
+func waitSettled(ctx context.Context, readers []*sensorReader, timeout time.Duration) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		settled := true
+		for _, r := range readers {
+			// only the pulse frequencies need a window
+			mr, ok := r.Reader.(MoistureReader)
+			if ok && !mr.Measure().Time.After(start) {
+				settled = false
+			}
+		}
+		if settled {
+			return
+		}
+		sleep(ctx, 100*time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	slog.Warn("sensors not settled, reading anyway", "timeout", timeout)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
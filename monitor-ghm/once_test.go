@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// fakeMoistureReader is a MoistureReader measuring its first window at
+// settled, never when zero.
+type fakeMoistureReader struct {
+	mu      sync.Mutex
+	settled time.Time
+}
+
+func (r *fakeMoistureReader) Calibrate(float64, float64) {}
+func (r *fakeMoistureReader) Close() error                { return nil }
+func (r *fakeMoistureReader) Name() string                { return "pilea" }
+func (r *fakeMoistureReader) Read() float64               { return 50 }
+
+func (r *fakeMoistureReader) Measure() grow.Measurement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.settled.IsZero() || time.Now().Before(r.settled) {
+		return grow.Measurement{}
+	}
+	return grow.Measurement{Time: r.settled}
+}
+
+func TestReadOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    int
+		publishErr error
+		readErr    error // of basil
+		want       int
+	}{
+		{
+			name:    "ok",
+			samples: 1,
+			want:    exitOK,
+		},
+		{
+			name:    "samples",
+			samples: 3,
+			want:    exitOK,
+		},
+		{
+			name:       "publish failed",
+			samples:    1,
+			publishErr: errors.New("broker down"),
+			want:       exitPublishFailed,
+		},
+		{
+			name:       "queued",
+			samples:    1,
+			publishErr: publish.ErrQueued,
+			want:       exitPublishFailed,
+		},
+		{
+			name:    "unhealthy sensor",
+			samples: 1,
+			readErr: errors.New("i2c: no device"),
+			want:    exitSensorsFailing,
+		},
+		{
+			name:       "publish failed with an unhealthy sensor",
+			samples:    1,
+			publishErr: errors.New("broker down"),
+			readErr:    errors.New("i2c: no device"),
+			want:       exitPublishFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers, fakes := fakeReaders(t, time.Minute, "pilea", "basil")
+			fakes[1].err = tt.readErr
+			var mu sync.Mutex
+			published := 0
+			publisher := func(context.Context, publish.Reading) error {
+				mu.Lock()
+				defer mu.Unlock()
+				published++
+				return tt.publishErr
+			}
+			opt := simulatedOptions(time.Minute, "pilea", "basil")
+			opt.Samples = tt.samples
+			opt.SamplingWindow = time.Millisecond
+			opt.StaleTimeout = time.Millisecond
+
+			got := readOnce(context.Background(), readers, []publish.Publisher{publisher}, opt)
+			if got != tt.want {
+				t.Errorf("readOnce() = %d, want %d", got, tt.want)
+			}
+			for _, f := range fakes {
+				if f.Reads() != tt.samples {
+					t.Errorf("%s read %d times, want %d", f.Name(), f.Reads(), tt.samples)
+				}
+			}
+			if want := 2 * tt.samples; published != want {
+				t.Errorf("published %d readings, want %d", published, want)
+			}
+		})
+	}
+}
+
+func TestWaitSettled(t *testing.T) {
+	tests := []struct {
+		name    string
+		settle  time.Duration // after the start, never when zero
+		timeout time.Duration
+		cancel  bool
+		min     time.Duration
+		max     time.Duration
+	}{
+		{
+			name:    "settled",
+			settle:  150 * time.Millisecond,
+			timeout: 5 * time.Second,
+			min:     150 * time.Millisecond,
+			max:     time.Second,
+		},
+		{
+			name:    "timeout",
+			timeout: 200 * time.Millisecond,
+			min:     200 * time.Millisecond,
+			max:     time.Second,
+		},
+		{
+			name:    "cancelled",
+			timeout: 5 * time.Second,
+			cancel:  true,
+			max:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers, _ := fakeReaders(t, time.Minute, "basil", "pilea")
+			// basil stays a value reader, which doesn't need to settle
+			pilea := readers.Readers()[1]
+			start := time.Now()
+			fake := &fakeMoistureReader{}
+			if tt.settle > 0 {
+				fake.settled = start.Add(tt.settle)
+			}
+			pilea.Reader = fake
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			waitSettled(ctx, readers.Readers(), tt.timeout)
+			elapsed := time.Since(start)
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("waitSettled() took %s, want between %s and %s", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestWaitSettledValueReaders(t *testing.T) {
+	readers, _ := fakeReaders(t, time.Minute, "window")
+	start := time.Now()
+	waitSettled(context.Background(), readers.Readers(), 5*time.Second)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("waitSettled() took %s for value readers, want none", elapsed)
+	}
+}
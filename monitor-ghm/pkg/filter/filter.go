@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const (
+	Clamp   = "clamp"   // Clamps values to [min, max]
+	EMA     = "ema"     // Exponential moving average
+	Median  = "median"  // Moving median
+	Outlier = "outlier" // Rejects values out of [min, max] or jumping more than maxDelta
+
+	DefaultMaxRejections = 3
+)
+
+// Config configures one filter of a sensor filter chain.
+type Config struct {
+	Type          string   `yaml:"type" json:"type,omitempty"`
+	Size          int      `yaml:"size" json:"size,omitempty"`                   // median
+	Alpha         float64  `yaml:"alpha" json:"alpha,omitempty"`                 // ema
+	Min           *float64 `yaml:"min" json:"min,omitempty"`                     // clamp and outlier
+	Max           *float64 `yaml:"max" json:"max,omitempty"`                     // clamp and outlier
+	MaxDelta      float64  `yaml:"maxDelta" json:"maxDelta,omitempty"`           // outlier
+	MaxRejections int      `yaml:"maxRejections" json:"maxRejections,omitempty"` // outlier
+}
+
+// Filter transforms a stream of values. The returned bool is false when the
+// value was rejected and should not be published.
+type Filter interface {
+	Apply(value float64) (float64, bool)
+}
+
+// Chain applies filters in order, stopping on the first rejection.
+type Chain []Filter
+
+func (c Chain) Apply(value float64) (float64, bool) {
+	for _, f := range c {
+		var ok bool
+		value, ok = f.Apply(value)
+		if !ok {
+			return value, false
+		}
+	}
+	return value, true
+}
+
+func NewChain(configs []Config) (Chain, error) {
+	chain := Chain{}
+	for i, c := range configs {
+		f, err := New(c)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %w", i, err)
+		}
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+func New(c Config) (Filter, error) {
+	min, max := math.Inf(-1), math.Inf(1)
+	if c.Min != nil {
+		min = *c.Min
+	}
+	if c.Max != nil {
+		max = *c.Max
+	}
+	if min > max {
+		return nil, fmt.Errorf("min %f greater than max %f", min, max)
+	}
+
+	switch c.Type {
+	case Median:
+		if c.Size < 1 {
+			return nil, fmt.Errorf("invalid median size: %d", c.Size)
+		}
+		return &MovingMedian{size: c.Size}, nil
+	case EMA:
+		if c.Alpha <= 0 || c.Alpha > 1 {
+			return nil, fmt.Errorf("invalid ema alpha: %f", c.Alpha)
+		}
+		return &ExponentialMovingAverage{alpha: c.Alpha}, nil
+	case Clamp:
+		return &Clamping{min: min, max: max}, nil
+	case Outlier:
+		if c.MaxDelta < 0 {
+			return nil, fmt.Errorf("invalid outlier max delta: %f", c.MaxDelta)
+		}
+		maxRejections := c.MaxRejections
+		if maxRejections == 0 {
+			maxRejections = DefaultMaxRejections
+		}
+		return &OutlierRejection{min: min, max: max, maxDelta: c.MaxDelta, maxRejections: maxRejections}, nil
+	}
+	return nil, fmt.Errorf("invalid filter type: %s", c.Type)
+}
+
+// MovingMedian returns the median of the last size values.
+type MovingMedian struct {
+	size   int
+	values []float64
+}
+
+func (f *MovingMedian) Apply(value float64) (float64, bool) {
+	f.values = append(f.values, value)
+	if len(f.values) > f.size {
+		f.values = f.values[1:]
+	}
+
+	sorted := slices.Clone(f.values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2], true
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2, true
+}
+
+// ExponentialMovingAverage smooths values, giving a weight of alpha to the
+// newest one.
+type ExponentialMovingAverage struct {
+	alpha   float64
+	average float64
+	started bool
+}
+
+func (f *ExponentialMovingAverage) Apply(value float64) (float64, bool) {
+	if !f.started {
+		f.average = value
+		f.started = true
+		return value, true
+	}
+	f.average = f.alpha*value + (1-f.alpha)*f.average
+	return f.average, true
+}
+
+// Clamping limits values to [min, max].
+type Clamping struct {
+	min float64
+	max float64
+}
+
+func (f *Clamping) Apply(value float64) (float64, bool) {
+	return math.Max(f.min, math.Min(f.max, value)), true
+}
+
+// OutlierRejection rejects values out of [min, max] and, when maxDelta is
+// set, values that differ more than maxDelta from the last accepted one.
+// After maxRejections consecutive jumps the new level is accepted, so real
+// changes like a watering aren't rejected forever.
+type OutlierRejection struct {
+	min           float64
+	max           float64
+	maxDelta      float64
+	maxRejections int
+
+	last       float64
+	started    bool
+	rejections int
+}
+
+func (f *OutlierRejection) Apply(value float64) (float64, bool) {
+	if math.IsNaN(value) || value < f.min || value > f.max {
+		return value, false
+	}
+
+	if f.started && f.maxDelta > 0 && math.Abs(value-f.last) > f.maxDelta {
+		f.rejections++
+		if f.rejections <= f.maxRejections {
+			return value, false
+		}
+	}
+
+	f.last = value
+	f.started = true
+	f.rejections = 0
+	return value, true
+}
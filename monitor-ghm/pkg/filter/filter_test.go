@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"math"
+	"testing"
+)
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []Config
+		values  []float64
+		want    []float64 // NaN for rejected values
+	}{
+		{
+			name:    "no filters",
+			configs: nil,
+			values:  []float64{1, 2, 3},
+			want:    []float64{1, 2, 3},
+		},
+		{
+			name:    "median",
+			configs: []Config{{Type: Median, Size: 3}},
+			values:  []float64{10, 90, 12, 11, 13},
+			want:    []float64{10, 50, 12, 12, 12},
+		},
+		{
+			name:    "ema",
+			configs: []Config{{Type: EMA, Alpha: 0.5}},
+			values:  []float64{10, 20, 20},
+			want:    []float64{10, 15, 17.5},
+		},
+		{
+			name:    "clamp",
+			configs: []Config{{Type: Clamp, Min: ptr(0), Max: ptr(100)}},
+			values:  []float64{-5, 50, 120},
+			want:    []float64{0, 50, 100},
+		},
+		{
+			name:    "outlier bounds",
+			configs: []Config{{Type: Outlier, Min: ptr(-10), Max: ptr(110)}},
+			values:  []float64{50, 200, -20, 60},
+			want:    []float64{50, math.NaN(), math.NaN(), 60},
+		},
+		{
+			name:    "outlier jump accepted after max rejections",
+			configs: []Config{{Type: Outlier, MaxDelta: 20, MaxRejections: 2}},
+			values:  []float64{30, 80, 31, 80, 81, 82},
+			want:    []float64{30, math.NaN(), 31, math.NaN(), math.NaN(), 82},
+		},
+		{
+			name: "rejected values don't reach the next filters",
+			configs: []Config{
+				{Type: Outlier, MaxDelta: 20},
+				{Type: EMA, Alpha: 0.5},
+			},
+			values: []float64{30, 90, 40},
+			want:   []float64{30, math.NaN(), 35},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := NewChain(tt.configs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, v := range tt.values {
+				got, ok := chain.Apply(v)
+				if math.IsNaN(tt.want[i]) {
+					if ok {
+						t.Errorf("value %d: Apply(%f) = %f, want rejected", i, v, got)
+					}
+					continue
+				}
+				if !ok || math.Abs(got-tt.want[i]) > 0.0001 {
+					t.Errorf("value %d: Apply(%f) = %f, %t, want %f", i, v, got, ok, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewChainInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown type", config: Config{Type: "kalman"}},
+		{name: "median size", config: Config{Type: Median}},
+		{name: "ema alpha", config: Config{Type: EMA, Alpha: 2}},
+		{name: "min greater than max", config: Config{Type: Clamp, Min: ptr(10), Max: ptr(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewChain([]Config{tt.config})
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
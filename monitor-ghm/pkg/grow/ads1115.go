@@ -0,0 +1,182 @@
+package grow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ADS1115Address is the I2C address of the ADS1115 with ADDR to ground
+const ADS1115Address = 0x48
+
+// ADS1115 registers, of 16 bits
+const (
+	ads1115Conversion = 0x00
+	ads1115Config     = 0x01
+)
+
+// ADS1115 configuration bits
+const (
+	ads1115Start      = 0x8000 // starts a conversion, reads 1 when idle
+	ads1115SingleEnd  = 0x4000 // input against ground, the channel is in bits 13:12
+	ads1115SingleShot = 0x0100
+	ads1115NoComp     = 0x0003 // disables the comparator
+)
+
+// ads1115Gains are the full scale voltages, by the PGA bits 11:9
+var ads1115Gains = []float64{6.144, 4.096, 2.048, 1.024, 0.512, 0.256}
+
+// ads1115Rates are the samples per second, by the DR bits 7:5
+var ads1115Rates = []int{8, 16, 32, 64, 128, 250, 475, 860}
+
+// ADS1115Config selects the input and the conversion settings.
+type ADS1115Config struct {
+	Channel    int     // single ended input, from 0 to 3
+	Gain       float64 // full scale voltage, like 4.096
+	SampleRate int     // samples per second, like 128
+}
+
+var DefaultADS1115Config = ADS1115Config{
+	Channel:    0,
+	Gain:       4.096,
+	SampleRate: 128,
+}
+
+// analog sensors reading less are disconnected, capacitive probes output
+// more than 1V
+const minSensorVoltage = 0.1
+
+// ADS1115Reader reads an analog soil moisture sensor, like the capacitive
+// probes, on a channel of an ADS1115 converter. The voltage drops as the
+// soil gets wetter.
+type ADS1115Reader struct {
+	name   string
+	bus    I2CBus
+	addr   uint16
+	device *sync.Mutex // shared with the readers of the other channels
+	config uint16
+	gain   float64
+	wait   time.Duration
+
+	mu         sync.Mutex
+	dryVoltage float64
+	wetVoltage float64
+}
+
+// NewADS1115Reader checks the converter answers and the settings are valid.
+// The moisture is computed from the voltages read with dry (0%) and wet
+// (100%) soil.
+func NewADS1115Reader(name string, bus I2CBus, addr uint16, config ADS1115Config, dryVoltage, wetVoltage float64) (*ADS1115Reader, error) {
+	slog.Debug("initializing ADS1115 reader", "name", name, "address", addr, "channel", config.Channel)
+	if config.Channel < 0 || config.Channel > 3 {
+		return nil, fmt.Errorf("invalid ADS1115 channel: %d", config.Channel)
+	}
+	gain := slices.Index(ads1115Gains, config.Gain)
+	if gain < 0 {
+		return nil, fmt.Errorf("invalid ADS1115 gain: %g, valid ones are %v", config.Gain, ads1115Gains)
+	}
+	rate := slices.Index(ads1115Rates, config.SampleRate)
+	if rate < 0 {
+		return nil, fmt.Errorf("invalid ADS1115 sample rate: %d, valid ones are %v", config.SampleRate, ads1115Rates)
+	}
+
+	device := deviceLock(bus, addr)
+	data := make([]byte, 2)
+	device.Lock()
+	err := bus.ReadRegisters(addr, ads1115Config, data)
+	device.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ADS1115Reader{
+		name:   name,
+		bus:    bus,
+		addr:   addr,
+		device: device,
+		config: ads1115Start | ads1115SingleEnd | uint16(config.Channel)<<12 |
+			uint16(gain)<<9 | ads1115SingleShot | uint16(rate)<<5 | ads1115NoComp,
+		gain: config.Gain,
+		// a conversion takes a sample period, plus the wakeup
+		wait:       time.Second/time.Duration(config.SampleRate) + time.Millisecond,
+		dryVoltage: dryVoltage,
+		wetVoltage: wetVoltage,
+	}, nil
+}
+
+// ReadVoltage runs a single conversion and returns the voltage of the
+// channel. The readers of the other channels wait until it is read.
+func (r *ADS1115Reader) ReadVoltage() (float64, error) {
+	r.device.Lock()
+	defer r.device.Unlock()
+
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, r.config)
+	err := r.bus.WriteRegisters(r.addr, ads1115Config, data...)
+	if err != nil {
+		return 0, err
+	}
+
+	// waits for the conversion, polling in case the clock is slower
+	time.Sleep(r.wait)
+	for i := 0; ; i++ {
+		err := r.bus.ReadRegisters(r.addr, ads1115Config, data)
+		if err != nil {
+			return 0, err
+		}
+		if binary.BigEndian.Uint16(data)&ads1115Start != 0 {
+			break
+		}
+		if i == 10 {
+			return 0, fmt.Errorf("ADS1115 conversion at 0x%02x not finished", r.addr)
+		}
+		time.Sleep(r.wait / 10)
+	}
+
+	err = r.bus.ReadRegisters(r.addr, ads1115Conversion, data)
+	if err != nil {
+		return 0, err
+	}
+	raw := int16(binary.BigEndian.Uint16(data))
+	return float64(raw) * r.gain / 32768, nil
+}
+
+// Read returns the moisture percentage.
+func (r *ADS1115Reader) Read() (float64, error) {
+	voltage, err := r.ReadVoltage()
+	if err != nil {
+		return 0, err
+	}
+	return r.Moisture(voltage), nil
+}
+
+// Moisture returns the moisture percentage of a voltage read, with the
+// calibration of the reader.
+func (r *ADS1115Reader) Moisture(voltage float64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Moisture(voltage, r.wetVoltage, r.dryVoltage)
+}
+
+// Calibrate changes the voltages read with dry and wet soil.
+func (r *ADS1115Reader) Calibrate(dryVoltage, wetVoltage float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dryVoltage = dryVoltage
+	r.wetVoltage = wetVoltage
+}
+
+func (r *ADS1115Reader) Metric() Metric {
+	return MetricMoisture
+}
+
+func (r *ADS1115Reader) Close() error {
+	return r.bus.Close()
+}
+
+func (r *ADS1115Reader) Name() string {
+	return r.name
+}
@@ -0,0 +1,195 @@
+package grow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// fakeADS1115 converts the voltages of its channels when a conversion is
+// started, finishing it right away.
+type fakeADS1115 struct {
+	voltages   [4]float64
+	config     uint16
+	conversion int16
+}
+
+func (f *fakeADS1115) ReadRegisters(reg byte, data []byte) error {
+	switch reg {
+	case ads1115Conversion:
+		binary.BigEndian.PutUint16(data, uint16(f.conversion))
+	case ads1115Config:
+		binary.BigEndian.PutUint16(data, f.config|ads1115Start)
+	default:
+		return fmt.Errorf("invalid register 0x%02x", reg)
+	}
+	return nil
+}
+
+func (f *fakeADS1115) WriteRegisters(reg byte, data []byte) error {
+	if reg != ads1115Config || len(data) != 2 {
+		return fmt.Errorf("invalid write of %d bytes to 0x%02x", len(data), reg)
+	}
+	f.config = binary.BigEndian.Uint16(data)
+	if f.config&ads1115Start != 0 {
+		channel := f.config >> 12 & 0x03
+		gain := ads1115Gains[f.config>>9&0x07]
+		raw := math.Round(f.voltages[channel] * 32768 / gain)
+		f.conversion = int16(math.Max(math.Min(raw, math.MaxInt16), math.MinInt16))
+	}
+	return nil
+}
+
+func TestADS1115Reader(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       ADS1115Config
+		voltage      float64
+		wantVoltage  float64
+		wantMoisture float64
+	}{
+		{
+			name:         "half wet",
+			config:       DefaultADS1115Config,
+			voltage:      2.05,
+			wantVoltage:  2.05,
+			wantMoisture: 50,
+		},
+		{
+			name:         "wet on channel 3",
+			config:       ADS1115Config{Channel: 3, Gain: 2.048, SampleRate: 860},
+			voltage:      1.3,
+			wantVoltage:  1.3,
+			wantMoisture: 100,
+		},
+		{
+			name:         "beyond the full scale",
+			config:       ADS1115Config{Channel: 1, Gain: 1.024, SampleRate: 475},
+			voltage:      2.8,
+			wantVoltage:  1.024,
+			wantMoisture: 118.4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adc := &fakeADS1115{}
+			adc.voltages[tt.config.Channel] = tt.voltage
+			bus := NewFakeI2CBus()
+			bus.Attach(ADS1115Address, adc)
+
+			r, err := NewADS1115Reader("pilea", bus, ADS1115Address, tt.config, 2.8, 1.3)
+			if err != nil {
+				t.Fatalf("NewADS1115Reader() error = %v", err)
+			}
+			voltage, err := r.ReadVoltage()
+			if err != nil {
+				t.Fatalf("ReadVoltage() error = %v", err)
+			}
+			// one step of the smallest gain
+			if math.Abs(voltage-tt.wantVoltage) > 0.0002 {
+				t.Errorf("ReadVoltage() = %f, want %f", voltage, tt.wantVoltage)
+			}
+			moisture, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(moisture-tt.wantMoisture) > 0.1 {
+				t.Errorf("Read() = %f, want %f", moisture, tt.wantMoisture)
+			}
+		})
+	}
+}
+
+func TestADS1115ReaderConfig(t *testing.T) {
+	adc := &fakeADS1115{}
+	bus := NewFakeI2CBus()
+	bus.Attach(0x49, adc)
+
+	r, err := NewADS1115Reader("pilea", bus, 0x49, ADS1115Config{Channel: 1, Gain: 2.048, SampleRate: 860}, 2.8, 1.3)
+	if err != nil {
+		t.Fatalf("NewADS1115Reader() error = %v", err)
+	}
+	_, err = r.ReadVoltage()
+	if err != nil {
+		t.Fatalf("ReadVoltage() error = %v", err)
+	}
+	// single shot conversion of AIN1, ±2.048V, 860 samples per second,
+	// without comparator
+	if adc.config != 0xD5E3 {
+		t.Errorf("config = 0x%04x, want 0xd5e3", adc.config)
+	}
+
+	r.Calibrate(3, 1)
+	adc.voltages[1] = 1.5
+	moisture, _ := r.Read()
+	if math.Abs(moisture-75) > 0.1 {
+		t.Errorf("Read() after Calibrate() = %f, want 75", moisture)
+	}
+}
+
+func TestADS1115ReaderErrors(t *testing.T) {
+	bus := NewFakeI2CBus()
+	_, err := NewADS1115Reader("pilea", bus, ADS1115Address, DefaultADS1115Config, 2.8, 1.3)
+	if err == nil {
+		t.Errorf("NewADS1115Reader() without a device didn't fail")
+	}
+
+	bus.Attach(ADS1115Address, &fakeADS1115{})
+	for _, config := range []ADS1115Config{
+		{Channel: 4, Gain: 4.096, SampleRate: 128},
+		{Channel: 0, Gain: 5, SampleRate: 128},
+		{Channel: 0, Gain: 4.096, SampleRate: 100},
+	} {
+		_, err := NewADS1115Reader("pilea", bus, ADS1115Address, config, 2.8, 1.3)
+		if err == nil {
+			t.Errorf("NewADS1115Reader() with %+v didn't fail", config)
+		}
+	}
+}
+
+func TestADS1115ReaderChannels(t *testing.T) {
+	adc := &fakeADS1115{voltages: [4]float64{1.5, 2.5}}
+	bus := NewFakeI2CBus()
+	bus.Attach(ADS1115Address, adc)
+
+	// the readers of both channels converting at the same time
+	voltages := make(chan [2]float64, 40)
+	for channel := 0; channel < 2; channel++ {
+		config := ADS1115Config{Channel: channel, Gain: 4.096, SampleRate: 860}
+		r, err := NewADS1115Reader("pilea", bus, ADS1115Address, config, 2.8, 1.3)
+		if err != nil {
+			t.Fatalf("NewADS1115Reader() error = %v", err)
+		}
+		go func() {
+			for i := 0; i < 20; i++ {
+				voltage, err := r.ReadVoltage()
+				if err != nil {
+					t.Errorf("ReadVoltage() error = %v", err)
+				}
+				voltages <- [2]float64{adc.voltages[config.Channel], voltage}
+			}
+		}()
+	}
+	for i := 0; i < 40; i++ {
+		v := <-voltages
+		if math.Abs(v[1]-v[0]) > 0.001 {
+			t.Fatalf("ReadVoltage() = %f, want %f", v[1], v[0])
+		}
+	}
+}
+
+func TestDeviceLock(t *testing.T) {
+	a := &LinuxI2CBus{path: "/dev/i2c-1"}
+	b := &LinuxI2CBus{path: "/dev/i2c-1"}
+	if deviceLock(a, ADS1115Address) != deviceLock(b, ADS1115Address) {
+		t.Error("handles of the same bus got different locks")
+	}
+	if deviceLock(a, ADS1115Address) == deviceLock(a, 0x49) {
+		t.Error("devices at different addresses got the same lock")
+	}
+	if deviceLock(a, ADS1115Address) == deviceLock(&LinuxI2CBus{path: "/dev/i2c-0"}, ADS1115Address) {
+		t.Error("devices on different buses got the same lock")
+	}
+}
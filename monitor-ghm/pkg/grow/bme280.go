@@ -0,0 +1,226 @@
+package grow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BME280Address is the default I2C address of the BME280 breakouts, the
+// other one is 0x77
+const BME280Address = 0x76
+
+// BME280 registers
+const (
+	bme280CalibrationT = 0x88 // temperature and pressure, 26 bytes
+	bme280CalibrationH = 0xE1 // humidity, 7 bytes
+	bme280ID           = 0xD0
+	bme280CtrlHum      = 0xF2
+	bme280CtrlMeas     = 0xF4
+	bme280Config       = 0xF5
+	bme280Data         = 0xF7 // pressure, temperature and humidity, 8 bytes
+)
+
+const (
+	bme280ChipID = 0x60
+
+	bme280HumOversampling = 0x01 // 1x
+	bme280Normal          = 0x27 // 1x temperature and pressure, normal mode
+	bme280Standby         = 0xA0 // 1s between measurements
+
+	// the first measurement is ready after a conversion
+	bme280StartupDelay = 50 * time.Millisecond
+
+	// data registers value before the first measurement
+	bme280Skipped = 0x80000
+)
+
+// bme280Calibration is the trimming of each chip, read from its memory.
+type bme280Calibration struct {
+	T1         uint16
+	T2, T3     int16
+	P1         uint16
+	P2, P3, P4 int16
+	P5, P6, P7 int16
+	P8, P9     int16
+	H1         uint8
+	H2         int16
+	H3         uint8
+	H4, H5     int16
+	H6         int8
+}
+
+// BME280Reader reads the temperature, humidity or pressure of a BME280
+// breakout.
+type BME280Reader struct {
+	name        string
+	metric      Metric
+	bus         I2CBus
+	addr        uint16
+	device      *sync.Mutex // shared with the readers of the other metrics
+	calibration bme280Calibration
+	ready       time.Time
+}
+
+// NewBME280Reader checks the sensor is a BME280, reads its calibration and
+// starts measuring every second. The metric is MetricTemperature,
+// MetricHumidity or MetricPressure.
+func NewBME280Reader(name string, bus I2CBus, addr uint16, metric Metric) (*BME280Reader, error) {
+	slog.Debug("initializing BME280 reader", "name", name, "address", addr, "metric", metric)
+	if metric != MetricTemperature && metric != MetricHumidity && metric != MetricPressure {
+		return nil, fmt.Errorf("BME280 can't measure %s", metric)
+	}
+	device := deviceLock(bus, addr)
+	device.Lock()
+	defer device.Unlock()
+
+	id := []byte{0}
+	err := bus.ReadRegisters(addr, bme280ID, id)
+	if err != nil {
+		return nil, err
+	}
+	if id[0] != bme280ChipID {
+		return nil, fmt.Errorf("unexpected BME280 chip ID 0x%02x at 0x%02x", id[0], addr)
+	}
+
+	c, err := readBME280Calibration(bus, addr)
+	if err != nil {
+		return nil, err
+	}
+	// the humidity settings only apply after writing ctrl_meas
+	for _, w := range []struct{ reg, value byte }{
+		{bme280Config, bme280Standby},
+		{bme280CtrlHum, bme280HumOversampling},
+		{bme280CtrlMeas, bme280Normal},
+	} {
+		err := bus.WriteRegisters(addr, w.reg, w.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &BME280Reader{
+		name:        name,
+		metric:      metric,
+		bus:         bus,
+		addr:        addr,
+		device:      device,
+		calibration: c,
+		ready:       time.Now().Add(bme280StartupDelay),
+	}, nil
+}
+
+func readBME280Calibration(bus I2CBus, addr uint16) (bme280Calibration, error) {
+	c := bme280Calibration{}
+	tp := make([]byte, 26)
+	err := bus.ReadRegisters(addr, bme280CalibrationT, tp)
+	if err != nil {
+		return c, err
+	}
+	h := make([]byte, 7)
+	err = bus.ReadRegisters(addr, bme280CalibrationH, h)
+	if err != nil {
+		return c, err
+	}
+
+	le := binary.LittleEndian
+	c.T1 = le.Uint16(tp[0:])
+	c.T2 = int16(le.Uint16(tp[2:]))
+	c.T3 = int16(le.Uint16(tp[4:]))
+	c.P1 = le.Uint16(tp[6:])
+	c.P2 = int16(le.Uint16(tp[8:]))
+	c.P3 = int16(le.Uint16(tp[10:]))
+	c.P4 = int16(le.Uint16(tp[12:]))
+	c.P5 = int16(le.Uint16(tp[14:]))
+	c.P6 = int16(le.Uint16(tp[16:]))
+	c.P7 = int16(le.Uint16(tp[18:]))
+	c.P8 = int16(le.Uint16(tp[20:]))
+	c.P9 = int16(le.Uint16(tp[22:]))
+	c.H1 = tp[25]
+	c.H2 = int16(le.Uint16(h[0:]))
+	c.H3 = h[2]
+	// 12 bits values sharing the nibbles of 0xE5
+	c.H4 = int16(int8(h[3]))<<4 | int16(h[4]&0x0F)
+	c.H5 = int16(int8(h[5]))<<4 | int16(h[4]>>4)
+	c.H6 = int8(h[6])
+	return c, nil
+}
+
+func (r *BME280Reader) Read() (float64, error) {
+	time.Sleep(time.Until(r.ready))
+
+	data := make([]byte, 8)
+	r.device.Lock()
+	err := r.bus.ReadRegisters(r.addr, bme280Data, data)
+	r.device.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	adcP := int32(data[0])<<12 | int32(data[1])<<4 | int32(data[2])>>4
+	adcT := int32(data[3])<<12 | int32(data[4])<<4 | int32(data[5])>>4
+	adcH := int32(data[6])<<8 | int32(data[7])
+	if adcT == bme280Skipped {
+		return 0, fmt.Errorf("no BME280 measurement at 0x%02x", r.addr)
+	}
+
+	temperature, fine := r.calibration.temperature(adcT)
+	switch r.metric {
+	case MetricHumidity:
+		return r.calibration.humidity(adcH, fine), nil
+	case MetricPressure:
+		return r.calibration.pressure(adcP, fine), nil
+	}
+	return temperature, nil
+}
+
+// temperature returns the temperature in Celsius and the fine temperature
+// used by the pressure and humidity compensations, from the floating point
+// formulas of the BME280 datasheet.
+func (c bme280Calibration) temperature(adc int32) (float64, float64) {
+	v1 := (float64(adc)/16384 - float64(c.T1)/1024) * float64(c.T2)
+	v2 := float64(adc)/131072 - float64(c.T1)/8192
+	v2 = v2 * v2 * float64(c.T3)
+	fine := v1 + v2
+	return fine / 5120, fine
+}
+
+// pressure returns the pressure in Pascals.
+func (c bme280Calibration) pressure(adc int32, fine float64) float64 {
+	v1 := fine/2 - 64000
+	v2 := v1 * v1 * float64(c.P6) / 32768
+	v2 = v2 + v1*float64(c.P5)*2
+	v2 = v2/4 + float64(c.P4)*65536
+	v1 = (float64(c.P3)*v1*v1/524288 + float64(c.P2)*v1) / 524288
+	v1 = (1 + v1/32768) * float64(c.P1)
+	if v1 == 0 {
+		return 0
+	}
+	p := 1048576 - float64(adc)
+	p = (p - v2/4096) * 6250 / v1
+	v1 = float64(c.P9) * p * p / 2147483648
+	v2 = p * float64(c.P8) / 32768
+	return p + (v1+v2+float64(c.P7))/16
+}
+
+// humidity returns the relative humidity in percent.
+func (c bme280Calibration) humidity(adc int32, fine float64) float64 {
+	h := fine - 76800
+	h = (float64(adc) - (float64(c.H4)*64 + float64(c.H5)/16384*h)) *
+		(float64(c.H2) / 65536 * (1 + float64(c.H6)/67108864*h*(1+float64(c.H3)/67108864*h)))
+	h = h * (1 - float64(c.H1)*h/524288)
+	return min(max(h, 0), 100)
+}
+
+func (r *BME280Reader) Metric() Metric {
+	return r.metric
+}
+
+func (r *BME280Reader) Close() error {
+	return r.bus.Close()
+}
+
+func (r *BME280Reader) Name() string {
+	return r.name
+}
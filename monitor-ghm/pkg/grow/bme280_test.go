@@ -0,0 +1,137 @@
+package grow
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBME280 adds a BME280 to bus with the calibration example of the
+// datasheet, measuring 25.08°C, 100653.27Pa and 55% humidity.
+func fakeBME280(bus *FakeI2CBus) {
+	bus.Attach(BME280Address, fakeBME280Registers())
+}
+
+func fakeBME280Registers() *fakeRegisters {
+	calibration := []int{27504, 26435, -1000, 36477, -10685, 3024, 2855, 140, -7, 15500, -14600, 6000}
+	tp := make([]byte, 26)
+	for i, v := range calibration {
+		binary.LittleEndian.PutUint16(tp[i*2:], uint16(v))
+	}
+	tp[25] = 75 // H1
+
+	registers := &fakeRegisters{}
+	registers[bme280ID] = bme280ChipID
+	copy(registers[bme280CalibrationT:], tp)
+	// H2 362, H3 0, H4 313, H5 50, H6 30
+	copy(registers[bme280CalibrationH:], []byte{0x6A, 0x01, 0x00, 0x13, 0x29, 0x03, 30})
+	// pressure 415148, temperature 519888, humidity 30000
+	copy(registers[bme280Data:], []byte{0x65, 0x5A, 0xC0, 0x7E, 0xED, 0x00, 0x75, 0x30})
+	return registers
+}
+
+func TestBME280Reader(t *testing.T) {
+	tests := []struct {
+		metric    Metric
+		want      float64
+		tolerance float64
+	}{
+		{metric: MetricTemperature, want: 25.08, tolerance: 0.01},
+		{metric: MetricPressure, want: 100653.27, tolerance: 1},
+		{metric: MetricHumidity, want: 55, tolerance: 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			bus := NewFakeI2CBus()
+			fakeBME280(bus)
+			r, err := NewBME280Reader("air", bus, BME280Address, tt.metric)
+			if err != nil {
+				t.Fatalf("NewBME280Reader() error = %v", err)
+			}
+			r.ready = time.Time{}
+
+			got, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(got-tt.want) > tt.tolerance {
+				t.Errorf("Read() = %f, want %f", got, tt.want)
+			}
+			if bus.Register(BME280Address, bme280CtrlMeas) != bme280Normal {
+				t.Errorf("measurements not started, ctrl_meas = 0x%02x", bus.Register(BME280Address, bme280CtrlMeas))
+			}
+		})
+	}
+}
+
+func TestBME280ReaderErrors(t *testing.T) {
+	bus := NewFakeI2CBus()
+	_, err := NewBME280Reader("air", bus, BME280Address, MetricTemperature)
+	if err == nil {
+		t.Errorf("NewBME280Reader() without a device didn't fail")
+	}
+
+	bus.Set(BME280Address, bme280ID, 0x58) // BMP280
+	_, err = NewBME280Reader("air", bus, BME280Address, MetricTemperature)
+	if err == nil {
+		t.Errorf("NewBME280Reader() of another chip didn't fail")
+	}
+
+	fakeBME280(bus)
+	_, err = NewBME280Reader("air", bus, BME280Address, MetricLight)
+	if err == nil {
+		t.Errorf("NewBME280Reader() of light didn't fail")
+	}
+
+	// before the first measurement
+	bus.Set(BME280Address, bme280Data, 0x80, 0x00, 0x00, 0x80, 0x00, 0x00, 0x80, 0x00)
+	r, err := NewBME280Reader("air", bus, BME280Address, MetricTemperature)
+	if err != nil {
+		t.Fatalf("NewBME280Reader() error = %v", err)
+	}
+	r.ready = time.Time{}
+	_, err = r.Read()
+	if err == nil {
+		t.Errorf("Read() without measurements didn't fail")
+	}
+}
+
+func TestBME280ReaderConcurrent(t *testing.T) {
+	bus := &pointerBus{registers: *fakeBME280Registers()}
+
+	// the readers of the three metrics of the chip, reading together
+	want := map[Metric]float64{MetricTemperature: 25.08, MetricPressure: 100653.27, MetricHumidity: 55}
+	readers := map[*BME280Reader]float64{}
+	for metric, value := range want {
+		r, err := NewBME280Reader(string(metric), bus, BME280Address, metric)
+		if err != nil {
+			t.Fatalf("NewBME280Reader() error = %v", err)
+		}
+		r.ready = time.Time{}
+		readers[r] = value
+	}
+
+	wg := sync.WaitGroup{}
+	for r, value := range readers {
+		r, value := r, value
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				got, err := r.Read()
+				if err != nil {
+					t.Errorf("Read() error = %v", err)
+					return
+				}
+				if math.Abs(got-value) > 1 {
+					t.Errorf("%s Read() = %f, want %f", r.Metric(), got, value)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
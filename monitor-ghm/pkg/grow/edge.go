@@ -0,0 +1,118 @@
+package grow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/warthog618/gpiod"
+)
+
+const DefaultChip = "gpiochip0"
+
+// EdgeHandler is called for every rising edge, with the time it happened.
+type EdgeHandler func(time.Time)
+
+// EdgeSource delivers the rising edges of a sensor line.
+type EdgeSource interface {
+	Start(handler EdgeHandler) error
+	Close() error
+}
+
+// GPIOEdgeSource watches the rising edges of a GPIO line.
+type GPIOEdgeSource struct {
+	chipName string
+	offset   int
+
+	chip *gpiod.Chip
+	line *gpiod.Line
+
+	// maps the kernel event timestamps, which are only meant to measure
+	// intervals, to wall clock time
+	start      time.Time
+	startEvent time.Duration
+}
+
+func NewGPIOEdgeSource(chipName string, offset int) *GPIOEdgeSource {
+	return &GPIOEdgeSource{
+		chipName: chipName,
+		offset:   offset,
+	}
+}
+
+func (s *GPIOEdgeSource) Start(handler EdgeHandler) error {
+	c, err := gpiod.NewChip(s.chipName)
+	if err != nil {
+		return fmt.Errorf("could not initialize chip %s: %w", s.chipName, err)
+	}
+
+	l, err := c.RequestLine(s.offset,
+		gpiod.WithRisingEdge,
+		gpiod.WithEventHandler(func(evt gpiod.LineEvent) {
+			if s.start.IsZero() {
+				s.start = time.Now()
+				s.startEvent = evt.Timestamp
+			}
+			handler(s.start.Add(evt.Timestamp - s.startEvent))
+		}))
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("could not request line to %d: %w", s.offset, err)
+	}
+
+	s.chip = c
+	s.line = l
+	return nil
+}
+
+func (s *GPIOEdgeSource) Close() error {
+	if s.line == nil {
+		return nil
+	}
+	err := s.line.Close()
+	if err != nil {
+		return fmt.Errorf("could not close line %d: %w", s.offset, err)
+	}
+	return s.chip.Close()
+}
+
+// FakeEdgeSource is an EdgeSource that emits the edges it is told to, for
+// testing without GPIO hardware.
+type FakeEdgeSource struct {
+	mu      sync.Mutex
+	handler EdgeHandler
+	closed  bool
+}
+
+func (s *FakeEdgeSource) Start(handler EdgeHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+	return nil
+}
+
+// Emit delivers rising edges at the given times. Edges emitted before Start
+// or after Close are dropped, like on a real line.
+func (s *FakeEdgeSource) Emit(times ...time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handler == nil || s.closed {
+		return
+	}
+	for _, t := range times {
+		s.handler(t)
+	}
+}
+
+func (s *FakeEdgeSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *FakeEdgeSource) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,76 @@
+package grow
+
+import (
+	"math"
+	"time"
+)
+
+// Health is the state of a sensor, decided from its pulses and calibration.
+type Health string
+
+const (
+	HealthOK           Health = "ok"
+	HealthStale        Health = "stale"        // no pulses seen recently
+	HealthOutOfRange   Health = "out-of-range" // frequency out of the calibration bounds
+	HealthDisconnected Health = "disconnected" // no pulses for a long time, or ever
+)
+
+var Healths = []Health{HealthOK, HealthStale, HealthOutOfRange, HealthDisconnected}
+
+// HealthConfig controls how the sensor health is decided.
+type HealthConfig struct {
+	DisconnectAfter time.Duration // time without pulses after which a sensor is disconnected
+	RangeTolerance  float64       // fraction of the calibration range accepted beyond its bounds
+}
+
+var DefaultHealthConfig = HealthConfig{
+	DisconnectAfter: time.Minute,
+	RangeTolerance:  0.1,
+}
+
+// Diagnose returns the health of a sensor given its last measurement and the
+// frequencies read with wet (minMoisture) and dry (maxMoisture) soil.
+func Diagnose(m Measurement, minMoisture, maxMoisture float64, now time.Time, config HealthConfig) Health {
+	if m.LastPulse.IsZero() || now.Sub(m.LastPulse) > config.DisconnectAfter {
+		return HealthDisconnected
+	}
+	if m.Stale {
+		return HealthStale
+	}
+
+	low, high := math.Min(minMoisture, maxMoisture), math.Max(minMoisture, maxMoisture)
+	tolerance := (high - low) * config.RangeTolerance
+	if m.Frequency < low-tolerance || m.Frequency > high+tolerance {
+		return HealthOutOfRange
+	}
+	return HealthOK
+}
+
+// DiagnoseValue returns the health of a sensor reporting values directly,
+// given its last value and the error reading it.
+func DiagnoseValue(metric Metric, value float64, err error) Health {
+	if err != nil {
+		return HealthDisconnected
+	}
+	bounds, found := valueRanges[metric]
+	if math.IsNaN(value) || found && (value < bounds[0] || value > bounds[1]) {
+		return HealthOutOfRange
+	}
+	return HealthOK
+}
+
+// DiagnoseVoltage returns the health of an analog sensor given its last
+// voltage and the error reading it, and the voltages read with dry and wet
+// soil.
+func DiagnoseVoltage(voltage float64, err error, dryVoltage, wetVoltage float64, config HealthConfig) Health {
+	if err != nil || voltage < minSensorVoltage {
+		return HealthDisconnected
+	}
+
+	low, high := math.Min(dryVoltage, wetVoltage), math.Max(dryVoltage, wetVoltage)
+	tolerance := (high - low) * config.RangeTolerance
+	if voltage < low-tolerance || voltage > high+tolerance {
+		return HealthOutOfRange
+	}
+	return HealthOK
+}
@@ -0,0 +1,112 @@
+package grow
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDiagnose(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name string
+		m    Measurement
+		want Health
+	}{
+		{
+			name: "ok",
+			m:    Measurement{Frequency: 15, LastPulse: now},
+			want: HealthOK,
+		},
+		{
+			name: "within tolerance",
+			m:    Measurement{Frequency: 26, LastPulse: now},
+			want: HealthOK,
+		},
+		{
+			name: "above range",
+			m:    Measurement{Frequency: 30, LastPulse: now},
+			want: HealthOutOfRange,
+		},
+		{
+			name: "below range",
+			m:    Measurement{Frequency: 2, LastPulse: now},
+			want: HealthOutOfRange,
+		},
+		{
+			name: "stale",
+			m:    Measurement{Frequency: 15, LastPulse: now.Add(-30 * time.Second), Stale: true},
+			want: HealthStale,
+		},
+		{
+			name: "no pulses for too long",
+			m:    Measurement{Frequency: 15, LastPulse: now.Add(-2 * time.Minute), Stale: true},
+			want: HealthDisconnected,
+		},
+		{
+			name: "no pulses ever",
+			m:    Measurement{Stale: true},
+			want: HealthDisconnected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diagnose(tt.m, 6, 25, now, DefaultHealthConfig)
+			if got != tt.want {
+				t.Errorf("Diagnose() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnoseValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		value  float64
+		err    error
+		want   Health
+	}{
+		{name: "ok", metric: MetricTemperature, value: 21.5, want: HealthOK},
+		{name: "read error", metric: MetricTemperature, err: errors.New("no device at 0x76"), want: HealthDisconnected},
+		{name: "too hot", metric: MetricTemperature, value: 120, want: HealthOutOfRange},
+		{name: "negative light", metric: MetricLight, value: -1, want: HealthOutOfRange},
+		{name: "NaN", metric: MetricHumidity, value: math.NaN(), want: HealthOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiagnoseValue(tt.metric, tt.value, tt.err)
+			if got != tt.want {
+				t.Errorf("DiagnoseValue() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnoseVoltage(t *testing.T) {
+	tests := []struct {
+		name    string
+		voltage float64
+		err     error
+		want    Health
+	}{
+		{name: "ok", voltage: 2, want: HealthOK},
+		{name: "within tolerance", voltage: 2.9, want: HealthOK},
+		{name: "too dry", voltage: 3.2, want: HealthOutOfRange},
+		{name: "too wet", voltage: 1, want: HealthOutOfRange},
+		{name: "no signal", voltage: 0.01, want: HealthDisconnected},
+		{name: "read error", err: errors.New("no device at 0x48"), want: HealthDisconnected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiagnoseVoltage(tt.voltage, tt.err, 2.8, 1.3, DefaultHealthConfig)
+			if got != tt.want {
+				t.Errorf("DiagnoseVoltage() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package grow
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// ioctl selecting the device of the following reads and writes
+	i2cSlave = 0x0703
+)
+
+// I2CBus reads and writes the registers of the devices on an I2C bus.
+type I2CBus interface {
+	ReadRegisters(addr uint16, reg byte, data []byte) error
+	WriteRegisters(addr uint16, reg byte, data ...byte) error
+	Close() error
+}
+
+// LinuxI2CBus is an I2C bus of the Linux i2c-dev driver, like /dev/i2c-1.
+type LinuxI2CBus struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	addr uint16
+}
+
+func OpenI2CBus(path string) (*LinuxI2CBus, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open I2C bus %s: %w", path, err)
+	}
+	return &LinuxI2CBus{path: path, file: f}, nil
+}
+
+// ReadRegisters reads len(data) registers starting at reg, the devices
+// increment the register address on each byte read.
+func (b *LinuxI2CBus) ReadRegisters(addr uint16, reg byte, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.setAddress(addr)
+	if err != nil {
+		return err
+	}
+	_, err = b.file.Write([]byte{reg})
+	if err != nil {
+		return fmt.Errorf("could not select register 0x%02x of 0x%02x: %w", reg, addr, err)
+	}
+	_, err = io.ReadFull(b.file, data)
+	if err != nil {
+		return fmt.Errorf("could not read register 0x%02x of 0x%02x: %w", reg, addr, err)
+	}
+	return nil
+}
+
+// WriteRegisters writes data starting at reg, in a single transfer.
+func (b *LinuxI2CBus) WriteRegisters(addr uint16, reg byte, data ...byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.setAddress(addr)
+	if err != nil {
+		return err
+	}
+	_, err = b.file.Write(append([]byte{reg}, data...))
+	if err != nil {
+		return fmt.Errorf("could not write register 0x%02x of 0x%02x: %w", reg, addr, err)
+	}
+	return nil
+}
+
+func (b *LinuxI2CBus) Close() error {
+	return b.file.Close()
+}
+
+func (b *LinuxI2CBus) setAddress(addr uint16) error {
+	if b.addr == addr {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, b.file.Fd(), i2cSlave, uintptr(addr))
+	if errno != 0 {
+		return fmt.Errorf("could not select device 0x%02x on %s: %w", addr, b.path, errno)
+	}
+	b.addr = addr
+	return nil
+}
+
+// device locks serialize the transactions of several transfers with a
+// device, like selecting a register and reading it, or starting a conversion
+// and reading its result, between the readers of the device. Each reader
+// opens its own bus handle, so they are shared by bus path.
+var (
+	deviceLocksMu sync.Mutex
+	deviceLocks   = map[deviceKey]*sync.Mutex{}
+)
+
+type deviceKey struct {
+	bus  string
+	addr uint16
+}
+
+// deviceLock returns the lock of the device at addr on bus.
+func deviceLock(bus I2CBus, addr uint16) *sync.Mutex {
+	key := deviceKey{bus: fmt.Sprintf("%p", bus), addr: addr}
+	if b, ok := bus.(*LinuxI2CBus); ok {
+		key.bus = b.path
+	}
+	deviceLocksMu.Lock()
+	defer deviceLocksMu.Unlock()
+	lock, found := deviceLocks[key]
+	if !found {
+		lock = &sync.Mutex{}
+		deviceLocks[key] = lock
+	}
+	return lock
+}
+
+// FakeI2CDevice is a device of a FakeI2CBus.
+type FakeI2CDevice interface {
+	ReadRegisters(reg byte, data []byte) error
+	WriteRegisters(reg byte, data []byte) error
+}
+
+// fakeRegisters is a FakeI2CDevice of 256 byte registers, incrementing the
+// register address on each byte read or written.
+type fakeRegisters [256]byte
+
+func (f *fakeRegisters) ReadRegisters(reg byte, data []byte) error {
+	if int(reg)+len(data) > len(f) {
+		return fmt.Errorf("read of %d registers from 0x%02x out of bounds", len(data), reg)
+	}
+	copy(data, f[reg:])
+	return nil
+}
+
+func (f *fakeRegisters) WriteRegisters(reg byte, data []byte) error {
+	if int(reg)+len(data) > len(f) {
+		return fmt.Errorf("write of %d registers to 0x%02x out of bounds", len(data), reg)
+	}
+	copy(f[reg:], data)
+	return nil
+}
+
+// FakeI2CBus is an I2CBus for testing without I2C hardware. Accessing a
+// device that wasn't added fails, like a missing device on a real bus.
+type FakeI2CBus struct {
+	mu      sync.Mutex
+	devices map[uint16]FakeI2CDevice
+	closed  bool
+}
+
+func NewFakeI2CBus() *FakeI2CBus {
+	return &FakeI2CBus{devices: map[uint16]FakeI2CDevice{}}
+}
+
+// Attach adds a device at addr, replacing the one there.
+func (b *FakeI2CBus) Attach(addr uint16, device FakeI2CDevice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.devices[addr] = device
+}
+
+// Set adds a device of byte registers at addr, when missing, and sets its
+// registers starting at reg.
+func (b *FakeI2CBus) Set(addr uint16, reg byte, values ...byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	registers, ok := b.devices[addr].(*fakeRegisters)
+	if !ok {
+		registers = &fakeRegisters{}
+		b.devices[addr] = registers
+	}
+	copy(registers[reg:], values)
+}
+
+// Register returns the value of a register of a device added with Set,
+// zero for other devices.
+func (b *FakeI2CBus) Register(addr uint16, reg byte) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	registers, ok := b.devices[addr].(*fakeRegisters)
+	if !ok {
+		return 0
+	}
+	return registers[reg]
+}
+
+func (b *FakeI2CBus) ReadRegisters(addr uint16, reg byte, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	device, err := b.device(addr)
+	if err != nil {
+		return err
+	}
+	return device.ReadRegisters(reg, data)
+}
+
+func (b *FakeI2CBus) WriteRegisters(addr uint16, reg byte, data ...byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	device, err := b.device(addr)
+	if err != nil {
+		return err
+	}
+	return device.WriteRegisters(reg, data)
+}
+
+func (b *FakeI2CBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *FakeI2CBus) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *FakeI2CBus) device(addr uint16) (FakeI2CDevice, error) {
+	if b.closed {
+		return nil, fmt.Errorf("bus closed")
+	}
+	device, found := b.devices[addr]
+	if !found {
+		return nil, fmt.Errorf("no device at 0x%02x", addr)
+	}
+	return device, nil
+}
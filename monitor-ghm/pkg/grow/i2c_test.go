@@ -0,0 +1,42 @@
+package grow
+
+import (
+	"runtime"
+	"sync"
+)
+
+// pointerBus is an I2CBus of one device of byte registers, reading like the
+// i2c-dev driver: a transfer selects the register, then another one reads
+// from it, incrementing the register pointer of the device. Readers of the
+// device interleaving these transfers read the wrong registers.
+type pointerBus struct {
+	mu        sync.Mutex
+	registers fakeRegisters
+	pointer   byte
+}
+
+func (b *pointerBus) ReadRegisters(addr uint16, reg byte, data []byte) error {
+	b.mu.Lock()
+	b.pointer = reg
+	b.mu.Unlock()
+
+	// lets the other readers run between the transfers
+	runtime.Gosched()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.registers.ReadRegisters(b.pointer, data)
+	b.pointer += byte(len(data))
+	return err
+}
+
+func (b *pointerBus) WriteRegisters(addr uint16, reg byte, data ...byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pointer = reg + byte(len(data))
+	return b.registers.WriteRegisters(reg, data)
+}
+
+func (b *pointerBus) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package grow
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LTR559Address is the I2C address of the Grow HAT Mini light sensor
+const LTR559Address = 0x23
+
+// LTR-559 registers
+const (
+	ltr559ALSControl  = 0x80
+	ltr559PSControl   = 0x81
+	ltr559ALSMeasRate = 0x85
+	ltr559PartID      = 0x86
+	ltr559ALSData     = 0x88 // channel 1 and channel 0, little endian
+	ltr559PSData      = 0x8D // 11 bits, little endian
+)
+
+const (
+	ltr559ID = 0x92 // part number 0x9, revision 0x2
+
+	ltr559ALSActive   = 0x01 // active mode, gain 1x
+	ltr559PSActive    = 0x03 // active mode
+	ltr559ALSRate     = 0x03 // 100ms integration, every 500ms
+	ltr559Integration = 100 * time.Millisecond
+
+	// the first light value is ready after the wakeup and a full integration
+	ltr559StartupDelay = 200 * time.Millisecond
+)
+
+// coefficients converting the two channels to lux, by channel ratio, from
+// the LTR-559 appendix A
+var (
+	ltr559Ch0Coefficients = []float64{17743, 42785, 5926, 0}
+	ltr559Ch1Coefficients = []float64{-11059, 19548, -1185, 0}
+)
+
+// LTR559Reader reads the light or the proximity of the LTR-559 sensor of the
+// Grow HAT Mini.
+type LTR559Reader struct {
+	name   string
+	metric Metric
+	bus    I2CBus
+	addr   uint16
+	device *sync.Mutex // shared with the reader of the other metric
+	ready  time.Time
+}
+
+// NewLTR559Reader checks the sensor is an LTR-559 and enables the light and
+// proximity measurements. The metric is MetricLight or MetricProximity.
+func NewLTR559Reader(name string, bus I2CBus, addr uint16, metric Metric) (*LTR559Reader, error) {
+	slog.Debug("initializing LTR-559 reader", "name", name, "address", addr, "metric", metric)
+	if metric != MetricLight && metric != MetricProximity {
+		return nil, fmt.Errorf("LTR-559 can't measure %s", metric)
+	}
+	device := deviceLock(bus, addr)
+	device.Lock()
+	defer device.Unlock()
+
+	id := []byte{0}
+	err := bus.ReadRegisters(addr, ltr559PartID, id)
+	if err != nil {
+		return nil, err
+	}
+	if id[0] != ltr559ID {
+		return nil, fmt.Errorf("unexpected LTR-559 part ID 0x%02x at 0x%02x", id[0], addr)
+	}
+	for _, w := range []struct{ reg, value byte }{
+		{ltr559ALSMeasRate, ltr559ALSRate},
+		{ltr559ALSControl, ltr559ALSActive},
+		{ltr559PSControl, ltr559PSActive},
+	} {
+		err := bus.WriteRegisters(addr, w.reg, w.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &LTR559Reader{
+		name:   name,
+		metric: metric,
+		bus:    bus,
+		addr:   addr,
+		device: device,
+		ready:  time.Now().Add(ltr559StartupDelay),
+	}, nil
+}
+
+func (r *LTR559Reader) Read() (float64, error) {
+	time.Sleep(time.Until(r.ready))
+	r.device.Lock()
+	defer r.device.Unlock()
+	if r.metric == MetricProximity {
+		return r.proximity()
+	}
+	return r.lux()
+}
+
+func (r *LTR559Reader) lux() (float64, error) {
+	data := make([]byte, 4)
+	err := r.bus.ReadRegisters(r.addr, ltr559ALSData, data)
+	if err != nil {
+		return 0, err
+	}
+	ch1 := float64(uint16(data[0]) | uint16(data[1])<<8)
+	ch0 := float64(uint16(data[2]) | uint16(data[3])<<8)
+	return ltr559Lux(ch0, ch1), nil
+}
+
+// ltr559Lux converts the visible and infrared (ch0) and infrared (ch1)
+// counts to lux.
+func ltr559Lux(ch0, ch1 float64) float64 {
+	if ch0+ch1 == 0 {
+		return 0
+	}
+	ratio := ch1 * 100 / (ch0 + ch1)
+	i := 3
+	switch {
+	case ratio < 45:
+		i = 0
+	case ratio < 64:
+		i = 1
+	case ratio < 85:
+		i = 2
+	}
+	lux := ch0*ltr559Ch0Coefficients[i] - ch1*ltr559Ch1Coefficients[i]
+	lux /= float64(ltr559Integration / (100 * time.Millisecond))
+	return max(lux/10000, 0)
+}
+
+func (r *LTR559Reader) proximity() (float64, error) {
+	data := make([]byte, 2)
+	err := r.bus.ReadRegisters(r.addr, ltr559PSData, data)
+	if err != nil {
+		return 0, err
+	}
+	return float64(uint16(data[0]) | uint16(data[1]&0x07)<<8), nil
+}
+
+func (r *LTR559Reader) Metric() Metric {
+	return r.metric
+}
+
+func (r *LTR559Reader) Close() error {
+	return r.bus.Close()
+}
+
+func (r *LTR559Reader) Name() string {
+	return r.name
+}
@@ -0,0 +1,135 @@
+package grow
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLTR559Reader(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		data   map[byte][]byte
+		want   float64
+	}{
+		{
+			name:   "daylight",
+			metric: MetricLight,
+			// channel 1 200, channel 0 1000
+			data: map[byte][]byte{ltr559ALSData: {0xC8, 0x00, 0xE8, 0x03}},
+			want: 1995.48,
+		},
+		{
+			name:   "infrared",
+			metric: MetricLight,
+			// channel 1 600, channel 0 400, ratio 60
+			data: map[byte][]byte{ltr559ALSData: {0x58, 0x02, 0x90, 0x01}},
+			want: 538.52,
+		},
+		{
+			name:   "dark",
+			metric: MetricLight,
+			data:   map[byte][]byte{ltr559ALSData: {0, 0, 0, 0}},
+			want:   0,
+		},
+		{
+			name:   "proximity",
+			metric: MetricProximity,
+			// saturation bit set
+			data: map[byte][]byte{ltr559PSData: {0x34, 0x82}},
+			want: 564,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewFakeI2CBus()
+			bus.Set(LTR559Address, ltr559PartID, ltr559ID)
+			for reg, values := range tt.data {
+				bus.Set(LTR559Address, reg, values...)
+			}
+
+			r, err := NewLTR559Reader("light", bus, LTR559Address, tt.metric)
+			if err != nil {
+				t.Fatalf("NewLTR559Reader() error = %v", err)
+			}
+			r.ready = time.Time{}
+
+			got, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Read() = %f, want %f", got, tt.want)
+			}
+			if bus.Register(LTR559Address, ltr559ALSControl) != ltr559ALSActive {
+				t.Errorf("light measurement not enabled")
+			}
+		})
+	}
+}
+
+func TestLTR559ReaderClose(t *testing.T) {
+	bus := NewFakeI2CBus()
+	_, err := NewLTR559Reader("light", bus, LTR559Address, MetricLight)
+	if err == nil {
+		t.Errorf("NewLTR559Reader() without a device didn't fail")
+	}
+
+	bus.Set(LTR559Address, ltr559PartID, ltr559ID)
+	r, err := NewLTR559Reader("light", bus, LTR559Address, MetricLight)
+	if err != nil {
+		t.Fatalf("NewLTR559Reader() error = %v", err)
+	}
+	r.ready = time.Time{}
+	r.Close()
+	if !bus.Closed() {
+		t.Errorf("bus not closed")
+	}
+	_, err = r.Read()
+	if err == nil {
+		t.Errorf("Read() after Close() didn't fail")
+	}
+}
+
+func TestLTR559ReaderConcurrent(t *testing.T) {
+	bus := &pointerBus{}
+	bus.registers[ltr559PartID] = ltr559ID
+	copy(bus.registers[ltr559ALSData:], []byte{0, 0, 0xE8, 0x03})
+	copy(bus.registers[ltr559PSData:], []byte{0x34, 0x02})
+
+	// the light and the proximity readers of the chip, reading together
+	want := map[Metric]float64{MetricLight: 1774.3, MetricProximity: 564}
+	readers := map[*LTR559Reader]float64{}
+	for metric, value := range want {
+		r, err := NewLTR559Reader(string(metric), bus, LTR559Address, metric)
+		if err != nil {
+			t.Fatalf("NewLTR559Reader() error = %v", err)
+		}
+		r.ready = time.Time{}
+		readers[r] = value
+	}
+
+	wg := sync.WaitGroup{}
+	for r, value := range readers {
+		r, value := r, value
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				got, err := r.Read()
+				if err != nil {
+					t.Errorf("Read() error = %v", err)
+					return
+				}
+				if math.Abs(got-value) > 0.01 {
+					t.Errorf("%s Read() = %f, want %f", r.Metric(), got, value)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
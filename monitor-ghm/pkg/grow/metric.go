@@ -0,0 +1,42 @@
+package grow
+
+// Metric is what a sensor measures.
+type Metric string
+
+const (
+	MetricMoisture    Metric = "moisture"    // soil moisture in percent
+	MetricLight       Metric = "light"       // illuminance in lux
+	MetricProximity   Metric = "proximity"   // raw proximity counts, higher is closer
+	MetricTemperature Metric = "temperature" // air temperature in Celsius
+	MetricHumidity    Metric = "humidity"    // relative humidity in percent
+	MetricPressure    Metric = "pressure"    // air pressure in Pascals
+)
+
+// Units of the metrics, in the names used by Prometheus
+var Units = map[Metric]string{
+	MetricMoisture:    "percent",
+	MetricLight:       "lux",
+	MetricProximity:   "",
+	MetricTemperature: "celsius",
+	MetricHumidity:    "percent",
+	MetricPressure:    "pascals",
+}
+
+// valueRanges are the values the sensors can measure, the ones beyond them
+// are out of range
+var valueRanges = map[Metric][2]float64{
+	MetricLight:       {0, 100000},
+	MetricProximity:   {0, 2047},
+	MetricTemperature: {-40, 85},
+	MetricHumidity:    {0, 100},
+	MetricPressure:    {30000, 110000},
+}
+
+// ValueReader reads a sensor that reports values in the unit of its metric,
+// instead of pulses, like the I2C ones.
+type ValueReader interface {
+	Read() (float64, error)
+	Metric() Metric
+	Close() error
+	Name() string
+}
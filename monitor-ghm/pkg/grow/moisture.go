@@ -1,11 +1,10 @@
 package grow
 
 import (
-	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/warthog618/gpiod"
 	"github.com/warthog618/gpiod/device/rpi"
 )
 
@@ -15,68 +14,131 @@ const (
 	Moisture3 = rpi.J8p22
 )
 
+// Moisture converts a pulse frequency to a moisture percentage, given the
+// frequencies read with wet (minMoisture) and dry (maxMoisture) soil.
+func Moisture(frequency, minMoisture, maxMoisture float64) float64 {
+	return (maxMoisture - frequency) * 100 / (maxMoisture - minMoisture)
+}
+
+// MeasurementConfig controls how the pulse frequency is measured.
+type MeasurementConfig struct {
+	Window     time.Duration // minimum length of the sampling window
+	StaleAfter time.Duration // time without pulses after which a reading is stale
+}
+
+var DefaultMeasurementConfig = MeasurementConfig{
+	Window:     time.Second,
+	StaleAfter: 10 * time.Second,
+}
+
+// Measurement is the pulse frequency measured over a sampling window.
+type Measurement struct {
+	Frequency float64       // pulses per second
+	Pulses    int64         // pulses counted in the window
+	Window    time.Duration // length of the window
+	Time      time.Time     // when the window ended
+	LastPulse time.Time     // when the last pulse was seen
+	Stale     bool          // no pulses seen recently
+}
+
+// GrowHatMoistureReader counts the pulses of a Grow HAT Mini soil sensor. The
+// edge handler runs on the gpiod goroutine, so the state is protected by mu.
 type GrowHatMoistureReader struct {
-	count           int64
-	name            string
-	offset          int
-	reading         float64
-	timeLastReading time.Time
+	name   string
+	config MeasurementConfig
+	source EdgeSource
+	now    func() time.Time
 
+	mu          sync.Mutex
+	count       int64
+	windowStart time.Time
+	lastEdge    time.Time
+	measurement Measurement
 	minMoisture float64
 	maxMoisture float64
+}
 
-	chip *gpiod.Chip
-	line *gpiod.Line
+func NewGrowHatMoistureReader(name string, chipName string, offset int, minMoisture, maxMoisture float64, config MeasurementConfig) (*GrowHatMoistureReader, error) {
+	slog.Debug("initializing reader", "name", name, "chip", chipName, "offset", offset)
+	return NewGrowHatMoistureReaderWithSource(name, NewGPIOEdgeSource(chipName, offset), minMoisture, maxMoisture, config)
 }
 
-func NewGrowHatMoistureReader(name string, offset int, minMoisture, maxMoisture float64) (*GrowHatMoistureReader, error) {
-	slog.Debug("initializing reader", "name", name, "offset", offset)
+// NewGrowHatMoistureReaderWithSource creates a reader counting the pulses
+// delivered by source.
+func NewGrowHatMoistureReaderWithSource(name string, source EdgeSource, minMoisture, maxMoisture float64, config MeasurementConfig) (*GrowHatMoistureReader, error) {
 	r := &GrowHatMoistureReader{
-		name:            name,
-		offset:          offset,
-		timeLastReading: time.Now(),
-		minMoisture:     minMoisture,
-		maxMoisture:     maxMoisture,
+		name:        name,
+		config:      config,
+		source:      source,
+		now:         time.Now,
+		minMoisture: minMoisture,
+		maxMoisture: maxMoisture,
 	}
 
-	chipName := "gpiochip0"
-	c, err := gpiod.NewChip(chipName)
+	err := source.Start(r.handler)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialize chip %s: %w", chipName, err)
+		return nil, err
 	}
 
-	l, err := c.RequestLine(r.offset,
-		gpiod.WithRisingEdge,
-		gpiod.WithEventHandler(r.handler))
-	if err != nil {
-		return nil, fmt.Errorf("could not request line to %d: %w", r.offset, err)
-	}
-
-	r.chip = c
-	r.line = l
-
 	return r, nil
 }
 
 func (r *GrowHatMoistureReader) Read() float64 {
-	return (r.maxMoisture - r.reading) * 100 / (r.maxMoisture - r.minMoisture)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Moisture(r.measurement.Frequency, r.minMoisture, r.maxMoisture)
+}
+
+// Measure returns the last complete measurement. It is stale when no pulses
+// were seen for longer than the configured timeout.
+func (r *GrowHatMoistureReader) Measure() Measurement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.measurement
+	m.LastPulse = r.lastEdge
+	m.Stale = r.lastEdge.IsZero() || r.now().Sub(r.lastEdge) > r.config.StaleAfter
+	return m
+}
+
+// Calibrate changes the moisture bounds without reopening the GPIO line.
+func (r *GrowHatMoistureReader) Calibrate(minMoisture, maxMoisture float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.minMoisture = minMoisture
+	r.maxMoisture = maxMoisture
 }
 
 func (r *GrowHatMoistureReader) Close() error {
-	return r.line.Close()
+	return r.source.Close()
 }
 
 func (r *GrowHatMoistureReader) Name() string {
 	return r.name
 }
 
-func (r *GrowHatMoistureReader) handler(evt gpiod.LineEvent) {
-	slog.Debug("handling value", "offset", evt.Offset)
+func (r *GrowHatMoistureReader) handler(ts time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the first edge, or the first after a gap, starts a new window
+	gap := !r.lastEdge.IsZero() && ts.Sub(r.lastEdge) > r.config.StaleAfter
+	r.lastEdge = ts
+	if r.windowStart.IsZero() || gap {
+		r.windowStart = ts
+		r.count = 0
+		return
+	}
+
 	r.count += 1
-	timeElapsed := float64(time.Now().UnixNano()-r.timeLastReading.UnixNano()) / 1000000000
-	if timeElapsed >= 1.0 {
-		r.reading = float64(r.count) / timeElapsed
+	window := ts.Sub(r.windowStart)
+	if window >= r.config.Window {
+		r.measurement = Measurement{
+			Frequency: float64(r.count) / window.Seconds(),
+			Pulses:    r.count,
+			Window:    window,
+			Time:      ts,
+		}
 		r.count = 0
-		r.timeLastReading = time.Now()
+		r.windowStart = ts
 	}
 }
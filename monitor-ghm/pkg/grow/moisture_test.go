@@ -0,0 +1,227 @@
+package grow
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// pulses returns n edges evenly spaced by period, starting at start.
+func pulses(start time.Time, n int, period time.Duration) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * period)
+	}
+	return times
+}
+
+func TestGrowHatMoistureReaderRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency float64
+		want      float64
+	}{
+		{name: "dry", frequency: 25, want: 0},
+		{name: "wet", frequency: 5, want: 100},
+		{name: "quarter", frequency: 20, want: 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &FakeEdgeSource{}
+			r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, DefaultMeasurementConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			period := time.Duration(float64(time.Second) / tt.frequency)
+			source.Emit(pulses(time.Now(), int(tt.frequency)+1, period)...)
+
+			got := r.Read()
+			if math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Read() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrowHatMoistureReaderWindow(t *testing.T) {
+	source := &FakeEdgeSource{}
+	r, err := NewGrowHatMoistureReaderWithSource("test", source, 0, 100, DefaultMeasurementConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	// a window shorter than one second doesn't produce a reading
+	source.Emit(pulses(start, 10, 50*time.Millisecond)...)
+	if got := r.Read(); got != 100 {
+		t.Errorf("Read() = %f before the first window, want 100", got)
+	}
+
+	// 20 pulses in the first second
+	source.Emit(pulses(start.Add(500*time.Millisecond), 11, 50*time.Millisecond)...)
+	if got := r.Read(); math.Abs(got-80) > 0.01 {
+		t.Errorf("Read() = %f after the first window, want 80", got)
+	}
+
+	// 10 pulses in the next second
+	source.Emit(pulses(start.Add(1100*time.Millisecond), 10, 100*time.Millisecond)...)
+	if got := r.Read(); math.Abs(got-90) > 0.01 {
+		t.Errorf("Read() = %f after the second window, want 90", got)
+	}
+}
+
+func TestGrowHatMoistureReaderCalibrate(t *testing.T) {
+	source := &FakeEdgeSource{}
+	r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, DefaultMeasurementConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Emit(pulses(time.Now(), 16, 100*time.Millisecond)...)
+
+	r.Calibrate(0, 20)
+	if got := r.Read(); math.Abs(got-50) > 0.01 {
+		t.Errorf("Read() = %f after calibrating, want 50", got)
+	}
+}
+
+func TestGrowHatMoistureReaderClose(t *testing.T) {
+	source := &FakeEdgeSource{}
+	r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, DefaultMeasurementConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	source.Emit(pulses(start, 16, 100*time.Millisecond)...)
+	before := r.Read()
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !source.Closed() {
+		t.Error("source not closed")
+	}
+
+	// edges after closing are ignored
+	source.Emit(pulses(start.Add(2*time.Second), 30, 10*time.Millisecond)...)
+	if got := r.Read(); got != before {
+		t.Errorf("Read() = %f after closing, want %f", got, before)
+	}
+}
+
+func TestGrowHatMoistureReaderMeasure(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		edges  []time.Time
+		want   Measurement
+	}{
+		{
+			name:   "one second window",
+			window: time.Second,
+			edges:  pulses(time.Unix(0, 0), 21, 50*time.Millisecond),
+			want:   Measurement{Frequency: 20, Pulses: 20, Window: time.Second, Time: time.Unix(1, 0)},
+		},
+		{
+			name:   "longer window",
+			window: 2 * time.Second,
+			edges:  pulses(time.Unix(0, 0), 21, 100*time.Millisecond),
+			want:   Measurement{Frequency: 10, Pulses: 20, Window: 2 * time.Second, Time: time.Unix(2, 0)},
+		},
+		{
+			name:   "window ends on the first edge after the minimum length",
+			window: time.Second,
+			edges:  pulses(time.Unix(0, 0), 4, 400*time.Millisecond),
+			want:   Measurement{Frequency: 2.5, Pulses: 3, Window: 1200 * time.Millisecond, Time: time.Unix(1, 200000000)},
+		},
+		{
+			name:   "gap restarts the window",
+			window: time.Second,
+			edges: append(pulses(time.Unix(0, 0), 5, 100*time.Millisecond),
+				pulses(time.Unix(30, 0), 11, 100*time.Millisecond)...),
+			want: Measurement{Frequency: 10, Pulses: 10, Window: time.Second, Time: time.Unix(31, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &FakeEdgeSource{}
+			config := MeasurementConfig{Window: tt.window, StaleAfter: 10 * time.Second}
+			r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.now = func() time.Time { return tt.want.Time }
+
+			source.Emit(tt.edges...)
+
+			got := r.Measure()
+			if math.Abs(got.Frequency-tt.want.Frequency) > 0.001 {
+				t.Errorf("Frequency = %f, want %f", got.Frequency, tt.want.Frequency)
+			}
+			if got.Pulses != tt.want.Pulses {
+				t.Errorf("Pulses = %d, want %d", got.Pulses, tt.want.Pulses)
+			}
+			if got.Window != tt.want.Window {
+				t.Errorf("Window = %s, want %s", got.Window, tt.want.Window)
+			}
+			if !got.Time.Equal(tt.want.Time) {
+				t.Errorf("Time = %s, want %s", got.Time, tt.want.Time)
+			}
+			if got.Stale {
+				t.Error("Stale = true, want false")
+			}
+		})
+	}
+}
+
+func TestGrowHatMoistureReaderStale(t *testing.T) {
+	source := &FakeEdgeSource{}
+	config := MeasurementConfig{Window: time.Second, StaleAfter: 5 * time.Second}
+	r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	if !r.Measure().Stale {
+		t.Error("Stale = false before any pulse, want true")
+	}
+
+	source.Emit(pulses(now, 11, 100*time.Millisecond)...)
+	now = now.Add(5 * time.Second)
+	if r.Measure().Stale {
+		t.Error("Stale = true right after the pulses, want false")
+	}
+
+	now = now.Add(2 * time.Second)
+	if !r.Measure().Stale {
+		t.Error("Stale = false without pulses, want true")
+	}
+}
+
+func TestGrowHatMoistureReaderConcurrentRead(t *testing.T) {
+	source := &FakeEdgeSource{}
+	r, err := NewGrowHatMoistureReaderWithSource("test", source, 5, 25, DefaultMeasurementConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		source.Emit(pulses(time.Now(), 1000, 10*time.Millisecond)...)
+	}()
+	for i := 0; i < 1000; i++ {
+		r.Read()
+		r.Measure()
+	}
+	<-done
+
+	if got := r.Measure().Frequency; math.Abs(got-100) > 0.001 {
+		t.Errorf("Frequency = %f, want 100", got)
+	}
+}
@@ -0,0 +1,179 @@
+package grow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/warthog618/gpiod"
+	"github.com/warthog618/gpiod/device/rpi"
+)
+
+// GPIO lines of the Grow HAT Mini pumps, by pump number
+var Pumps = map[int]int{
+	1: rpi.GPIO17,
+	2: rpi.GPIO27,
+	3: rpi.GPIO22,
+}
+
+// pwmPeriod is the period of the software PWM driving the pumps below full
+// speed.
+const pwmPeriod = 10 * time.Millisecond
+
+// Pump drives a water pump.
+type Pump interface {
+	// Run runs the pump for duration, or until ctx is done, and returns how
+	// long it ran. The pump is always stopped when Run returns.
+	Run(ctx context.Context, duration time.Duration) (time.Duration, error)
+	Close() error
+}
+
+// GPIOPump drives a pump output of the Grow HAT Mini. Speeds below 1 are the
+// duty cycle of a software PWM, as the pumps are too strong for the small
+// pots at full speed.
+type GPIOPump struct {
+	mu     sync.Mutex
+	offset int
+	speed  float64
+	chip   *gpiod.Chip
+	line   *gpiod.Line
+}
+
+func NewGPIOPump(chipName string, offset int, speed float64) (*GPIOPump, error) {
+	if speed <= 0 || speed > 1 {
+		return nil, fmt.Errorf("invalid pump speed %g, must be between 0 and 1", speed)
+	}
+	slog.Debug("initializing pump", "chip", chipName, "offset", offset, "speed", speed)
+	c, err := gpiod.NewChip(chipName)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize chip %s: %w", chipName, err)
+	}
+	l, err := c.RequestLine(offset, gpiod.AsOutput(0))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("could not request line to %d: %w", offset, err)
+	}
+	return &GPIOPump{offset: offset, speed: speed, chip: c, line: l}, nil
+}
+
+func (p *GPIOPump) Run(ctx context.Context, duration time.Duration) (time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line == nil {
+		return 0, fmt.Errorf("pump on line %d closed", p.offset)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	err := p.drive(ctx)
+	stopErr := p.line.SetValue(0)
+	if err == nil && stopErr != nil {
+		err = fmt.Errorf("could not stop pump on line %d: %w", p.offset, stopErr)
+	}
+	return time.Since(start), err
+}
+
+// drive keeps the pump on until ctx is done, toggling the line when running
+// below full speed.
+func (p *GPIOPump) drive(ctx context.Context) error {
+	if p.speed == 1 {
+		err := p.line.SetValue(1)
+		if err != nil {
+			return fmt.Errorf("could not start pump on line %d: %w", p.offset, err)
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	on := time.Duration(float64(pwmPeriod) * p.speed)
+	ticker := time.NewTicker(pwmPeriod)
+	defer ticker.Stop()
+	for {
+		err := p.line.SetValue(1)
+		if err != nil {
+			return fmt.Errorf("could not start pump on line %d: %w", p.offset, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(on):
+		}
+		err = p.line.SetValue(0)
+		if err != nil {
+			return fmt.Errorf("could not stop pump on line %d: %w", p.offset, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *GPIOPump) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line == nil {
+		return nil
+	}
+	p.line.SetValue(0)
+	err := p.line.Close()
+	p.line = nil
+	if err != nil {
+		return fmt.Errorf("could not close line %d: %w", p.offset, err)
+	}
+	return p.chip.Close()
+}
+
+// FakePump is a Pump that only records how long it ran, for testing and
+// running without a Grow HAT Mini. Runs return right away unless Wait is
+// set.
+type FakePump struct {
+	Wait bool  // waits for the run duration like a real pump
+	Err  error // returned by Run when set
+
+	mu     sync.Mutex
+	runs   []time.Duration
+	closed bool
+}
+
+func (p *FakePump) Run(ctx context.Context, duration time.Duration) (time.Duration, error) {
+	if p.Err != nil {
+		return 0, p.Err
+	}
+	if p.Wait {
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+			duration = time.Since(start)
+		case <-time.After(duration):
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs = append(p.runs, duration)
+	return duration, nil
+}
+
+// Runs returns how long each run lasted.
+func (p *FakePump) Runs() []time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Duration{}, p.runs...)
+}
+
+func (p *FakePump) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *FakePump) Closed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
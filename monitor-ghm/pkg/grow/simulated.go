@@ -0,0 +1,146 @@
+package grow
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SimulationConfig controls the behaviour of the simulated soil.
+type SimulationConfig struct {
+	DryingRate            float64       // fraction of the water lost per hour
+	WaterBelow            float64       // moisture fraction triggering a watering
+	WaterTo               float64       // moisture fraction after a watering
+	Noise                 float64       // standard deviation of the frequency in Hz
+	DisconnectProbability float64       // probability of a disconnect fault per read
+	DisconnectDuration    time.Duration // how long a disconnect fault lasts
+	Speed                 float64       // how much faster than real time the soil dries
+}
+
+var DefaultSimulationConfig = SimulationConfig{
+	DryingRate:            0.02,
+	WaterBelow:            0.25,
+	WaterTo:               0.9,
+	Noise:                 0.3,
+	DisconnectProbability: 0.005,
+	DisconnectDuration:    2 * time.Minute,
+	Speed:                 1,
+}
+
+// SimulatedMoistureReader simulates a soil moisture sensor, so the monitor
+// can run without the Grow HAT Mini. The soil dries exponentially and is
+// watered when it gets too dry. The frequency read has noise and the sensor
+// disconnects from time to time.
+type SimulatedMoistureReader struct {
+	mu     sync.Mutex
+	name   string
+	config SimulationConfig
+	rand   *rand.Rand
+
+	moisture          float64
+	lastUpdate        time.Time
+	disconnectedUntil time.Time
+	measurement       Measurement
+
+	minMoisture float64
+	maxMoisture float64
+}
+
+func NewSimulatedMoistureReader(name string, minMoisture, maxMoisture float64, config SimulationConfig) *SimulatedMoistureReader {
+	slog.Debug("initializing simulated reader", "name", name)
+
+	// each sensor starts at a different point of the drying curve
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(h.Sum64())))
+
+	return &SimulatedMoistureReader{
+		name:        name,
+		config:      config,
+		rand:        rnd,
+		moisture:    config.WaterBelow + rnd.Float64()*(config.WaterTo-config.WaterBelow),
+		lastUpdate:  time.Now(),
+		minMoisture: minMoisture,
+		maxMoisture: maxMoisture,
+	}
+}
+
+func (r *SimulatedMoistureReader) Read() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.measure(time.Now())
+	return Moisture(m.Frequency, r.minMoisture, r.maxMoisture)
+}
+
+func (r *SimulatedMoistureReader) Measure() Measurement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.measure(time.Now())
+}
+
+// measure simulates one second sampling windows, returning the same
+// measurement until the next window ends.
+func (r *SimulatedMoistureReader) measure(now time.Time) Measurement {
+	if now.Sub(r.measurement.Time) < time.Second {
+		return r.measurement
+	}
+	frequency := r.frequency(now)
+	lastPulse := r.measurement.LastPulse
+	if frequency > 0 {
+		lastPulse = now
+	}
+	r.measurement = Measurement{
+		Frequency: frequency,
+		Pulses:    int64(math.Round(frequency)),
+		Window:    time.Second,
+		Time:      now,
+		LastPulse: lastPulse,
+		Stale:     frequency == 0,
+	}
+	return r.measurement
+}
+
+func (r *SimulatedMoistureReader) Calibrate(minMoisture, maxMoisture float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.minMoisture = minMoisture
+	r.maxMoisture = maxMoisture
+}
+
+func (r *SimulatedMoistureReader) Close() error {
+	return nil
+}
+
+func (r *SimulatedMoistureReader) Name() string {
+	return r.name
+}
+
+// frequency advances the simulation to now and returns the pulse frequency
+// the sensor would produce.
+func (r *SimulatedMoistureReader) frequency(now time.Time) float64 {
+	hours := now.Sub(r.lastUpdate).Hours() * r.config.Speed
+	r.lastUpdate = now
+
+	r.moisture *= math.Exp(-r.config.DryingRate * hours)
+	if r.moisture < r.config.WaterBelow {
+		slog.Debug("watering simulated plant", "name", r.name, "moisture", r.moisture)
+		r.moisture = r.config.WaterTo
+	}
+
+	if now.Before(r.disconnectedUntil) {
+		return 0
+	}
+	if r.rand.Float64() < r.config.DisconnectProbability {
+		slog.Debug("disconnecting simulated sensor", "name", r.name, "duration", r.config.DisconnectDuration)
+		r.disconnectedUntil = now.Add(r.config.DisconnectDuration)
+		return 0
+	}
+
+	// the sensor frequency drops as the soil gets wetter
+	frequency := r.maxMoisture - r.moisture*(r.maxMoisture-r.minMoisture)
+	frequency += r.rand.NormFloat64() * r.config.Noise
+	return math.Max(frequency, 0)
+}
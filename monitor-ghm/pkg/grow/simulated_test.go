@@ -0,0 +1,129 @@
+package grow
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSimulatedMoistureReaderRange(t *testing.T) {
+	config := DefaultSimulationConfig
+	config.Noise = 0
+	config.DisconnectProbability = 0
+	config.Speed = 100
+	r := NewSimulatedMoistureReader("test", 5, 25, config)
+
+	// a week of readings, watered several times
+	now := time.Now()
+	watered := 0
+	last := r.moisture
+	for i := 0; i < 7*24*60; i++ {
+		now = now.Add(time.Minute)
+		m := r.measure(now)
+		if m.Frequency < 5 || m.Frequency > 25 || m.Stale {
+			t.Fatalf("measure() = %+v, want a frequency between 5 and 25", m)
+		}
+		if r.moisture < config.WaterBelow || r.moisture > config.WaterTo {
+			t.Fatalf("moisture = %f, want between %f and %f", r.moisture, config.WaterBelow, config.WaterTo)
+		}
+		if r.moisture > last {
+			watered++
+		}
+		last = r.moisture
+	}
+	if watered == 0 {
+		t.Error("simulated plant never watered")
+	}
+}
+
+func TestSimulatedMoistureReaderDrift(t *testing.T) {
+	tests := []struct {
+		name  string
+		speed float64
+		hours float64
+		want  float64 // moisture percentage
+	}{
+		{name: "real time", speed: 1, hours: 10, want: 90 * math.Exp(-0.02*10)},
+		{name: "faster", speed: 6, hours: 10, want: 90 * math.Exp(-0.02*60)},
+		{name: "watered", speed: 1, hours: 100, want: 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultSimulationConfig
+			config.Noise = 0
+			config.DisconnectProbability = 0
+			config.Speed = tt.speed
+			r := NewSimulatedMoistureReader("test", 5, 25, config)
+			start := time.Now()
+			r.moisture = config.WaterTo
+			r.lastUpdate = start
+
+			m := r.measure(start.Add(time.Duration(tt.hours * float64(time.Hour))))
+			got := Moisture(m.Frequency, 5, 25)
+			if math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("moisture = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatedMoistureReaderNoise(t *testing.T) {
+	config := DefaultSimulationConfig
+	config.DryingRate = 0
+	config.DisconnectProbability = 0
+	r := NewSimulatedMoistureReader("test", 5, 25, config)
+	want := 25 - r.moisture*20
+
+	// one reading per sampling window
+	now := time.Now()
+	n := 5000
+	sum, sumSquares := 0.0, 0.0
+	for i := 0; i < n; i++ {
+		now = now.Add(time.Second)
+		f := r.measure(now).Frequency
+		sum += f
+		sumSquares += f * f
+	}
+	mean := sum / float64(n)
+	stddev := math.Sqrt(sumSquares/float64(n) - mean*mean)
+	if math.Abs(mean-want) > 0.05 {
+		t.Errorf("mean frequency = %f, want %f", mean, want)
+	}
+	if math.Abs(stddev-config.Noise) > 0.03 {
+		t.Errorf("frequency standard deviation = %f, want %f", stddev, config.Noise)
+	}
+}
+
+func TestSimulatedMoistureReaderDisconnect(t *testing.T) {
+	config := DefaultSimulationConfig
+	config.DisconnectProbability = 1
+	r := NewSimulatedMoistureReader("test", 5, 25, config)
+
+	start := time.Now()
+	m := r.measure(start)
+	if m.Frequency != 0 || !m.Stale {
+		t.Errorf("measure() = %+v, want a stale measurement", m)
+	}
+
+	// reconnects once the fault is over
+	r.config.DisconnectProbability = 0
+	m = r.measure(start.Add(config.DisconnectDuration - time.Second))
+	if m.Frequency != 0 {
+		t.Errorf("frequency during the disconnect = %f, want 0", m.Frequency)
+	}
+	m = r.measure(start.Add(config.DisconnectDuration))
+	if m.Frequency == 0 || m.Stale {
+		t.Errorf("measure() after the disconnect = %+v, want a frequency", m)
+	}
+}
+
+func TestSimulatedMoistureReaderClose(t *testing.T) {
+	r := NewSimulatedMoistureReader("test", 5, 25, DefaultSimulationConfig)
+	err := r.Close()
+	if err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if r.Name() != "test" {
+		t.Errorf("Name() = %s, want test", r.Name())
+	}
+}
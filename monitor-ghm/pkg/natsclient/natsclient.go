@@ -0,0 +1,99 @@
+// Package natsclient holds the NATS client settings of the monitor, also used
+// by the ingestion service, so both connect and name subjects the same way.
+package natsclient
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Security is the TLS and the authentication of a NATS connection, only one
+// of the credentials file, the NKey seed, the token and the user can be set.
+type Security struct {
+	CAFile    string
+	CertFile  string
+	KeyFile   string
+	CredsFile string
+	NKeyFile  string
+	Token     string
+	User      string
+	Password  string
+}
+
+// Validate checks the NATS TLS and authentication settings, so they fail on
+// startup instead of when connecting.
+func (config Security) Validate() error {
+	methods := []string{}
+	for _, m := range []struct{ name, value string }{
+		{"creds", config.CredsFile},
+		{"nkey", config.NKeyFile},
+		{"token", config.Token},
+		{"user", config.User},
+	} {
+		if m.value != "" {
+			methods = append(methods, m.name)
+		}
+	}
+	if len(methods) > 1 {
+		return fmt.Errorf("only one NATS authentication method can be set, got %s", strings.Join(methods, ", "))
+	}
+	if config.Password != "" && config.User == "" {
+		return fmt.Errorf("NATS password set without a user")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return fmt.Errorf("NATS client certificate and key must be set together")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"CA", config.CAFile},
+		{"certificate", config.CertFile},
+		{"key", config.KeyFile},
+		{"credentials", config.CredsFile},
+		{"NKey seed", config.NKeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		_, err := os.Stat(f.path)
+		if err != nil {
+			return fmt.Errorf("invalid NATS %s file: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// Options returns the TLS and authentication options, TLS is enabled by the
+// CA and the client certificate, or a tls:// URL.
+func (config Security) Options() ([]nats.Option, error) {
+	opts := []nats.Option{}
+	if config.CAFile != "" {
+		opts = append(opts, nats.RootCAs(config.CAFile))
+	}
+	if config.CertFile != "" {
+		opts = append(opts, nats.ClientCert(config.CertFile, config.KeyFile))
+	}
+
+	switch {
+	case config.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	case config.NKeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(config.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats nkey seed %s: %w", config.NKeyFile, err)
+		}
+		opts = append(opts, opt)
+	case config.Token != "":
+		opts = append(opts, nats.Token(config.Token))
+	case config.User != "":
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	}
+	return opts, nil
+}
+
+// SubjectToken removes the characters with a special meaning in NATS
+// subjects, so names like device IDs can be used as a subject token.
+func SubjectToken(s string) string {
+	return strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_").Replace(s)
+}
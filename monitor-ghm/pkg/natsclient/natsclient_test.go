@@ -0,0 +1,226 @@
+package natsclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// writeFile writes data to name in a temporary directory, returning its path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// caFile writes a self-signed CA certificate.
+func caFile(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+}
+
+func TestValidate(t *testing.T) {
+	file := writeFile(t, "file", "")
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name    string
+		config  Security
+		wantErr string
+	}{
+		{
+			name:   "no security",
+			config: Security{},
+		},
+		{
+			name:   "user and password",
+			config: Security{User: "grow", Password: "secret"},
+		},
+		{
+			name:   "token",
+			config: Security{Token: "secret"},
+		},
+		{
+			name:   "creds",
+			config: Security{CredsFile: file},
+		},
+		{
+			name:    "creds and user",
+			config:  Security{CredsFile: file, User: "grow", Password: "secret"},
+			wantErr: "only one NATS authentication method can be set, got creds, user",
+		},
+		{
+			name:    "token and user",
+			config:  Security{Token: "secret", User: "grow"},
+			wantErr: "only one NATS authentication method can be set, got token, user",
+		},
+		{
+			name:    "creds, nkey and token",
+			config:  Security{CredsFile: file, NKeyFile: file, Token: "secret"},
+			wantErr: "only one NATS authentication method can be set, got creds, nkey, token",
+		},
+		{
+			name:    "password without user",
+			config:  Security{Password: "secret"},
+			wantErr: "NATS password set without a user",
+		},
+		{
+			name:   "client certificate",
+			config: Security{CAFile: file, CertFile: file, KeyFile: file},
+		},
+		{
+			name:    "certificate without key",
+			config:  Security{CertFile: file},
+			wantErr: "NATS client certificate and key must be set together",
+		},
+		{
+			name:    "key without certificate",
+			config:  Security{KeyFile: file},
+			wantErr: "NATS client certificate and key must be set together",
+		},
+		{
+			name:    "missing CA",
+			config:  Security{CAFile: missing},
+			wantErr: "invalid NATS CA file",
+		},
+		{
+			name:    "missing key",
+			config:  Security{CertFile: file, KeyFile: missing},
+			wantErr: "invalid NATS key file",
+		},
+		{
+			name:    "missing creds",
+			config:  Security{CredsFile: missing},
+			wantErr: "invalid NATS credentials file",
+		},
+		{
+			name:    "missing nkey",
+			config:  Security{NKeyFile: missing},
+			wantErr: "invalid NATS NKey seed file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	ca := caFile(t)
+	tests := []struct {
+		name    string
+		config  Security
+		check   func(nats.Options) bool
+		wantErr bool
+	}{
+		{
+			name:   "no security",
+			config: Security{},
+			check:  func(o nats.Options) bool { return !o.Secure && o.User == "" && o.Token == "" },
+		},
+		{
+			name:   "user and password",
+			config: Security{User: "grow", Password: "secret"},
+			check:  func(o nats.Options) bool { return o.User == "grow" && o.Password == "secret" },
+		},
+		{
+			name:   "token",
+			config: Security{Token: "secret"},
+			check:  func(o nats.Options) bool { return o.Token == "secret" && o.User == "" },
+		},
+		{
+			name:   "creds",
+			config: Security{CredsFile: writeFile(t, "grow.creds", "")},
+			check:  func(o nats.Options) bool { return o.UserJWT != nil && o.SignatureCB != nil },
+		},
+		{
+			name:   "CA",
+			config: Security{CAFile: ca},
+			check:  func(o nats.Options) bool { return o.Secure && o.RootCAsCB != nil },
+		},
+		{
+			name:    "invalid nkey seed",
+			config:  Security{NKeyFile: writeFile(t, "seed.nk", "not a seed")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.config.Options()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Options() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			o := nats.GetDefaultOptions()
+			for _, opt := range opts {
+				err := opt(&o)
+				if err != nil {
+					t.Fatalf("invalid option: %v", err)
+				}
+			}
+			if !tt.check(o) {
+				t.Errorf("Options() = %+v", o)
+			}
+		})
+	}
+}
+
+func TestSubjectToken(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{s: "pilea", want: "pilea"},
+		{s: "pi.kitchen", want: "pi_kitchen"},
+		{s: "living room", want: "living_room"},
+		{s: "pi.*.>", want: "pi____"},
+		{s: "pi-one_2", want: "pi-one_2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := SubjectToken(tt.s); got != tt.want {
+				t.Errorf("SubjectToken(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package options
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const Calibrate = "calibrate" // Calibration command
+
+type CalibrateOptions struct {
+	ConfigFile     string
+	Connector      int
+	Duration       time.Duration
+	GPIOChip       string
+	ReaderBackend  string
+	SamplingWindow time.Duration
+	Sensor         string
+}
+
+// GetCalibrate parses the arguments of the calibrate command. The sensor
+// connector comes from the --connector flag, the config file or the default
+// sensors, in this order.
+func GetCalibrate(args []string) (CalibrateOptions, error) {
+	opt := CalibrateOptions{}
+
+	flags := pflag.NewFlagSet(Calibrate, pflag.ContinueOnError)
+	flags.StringVar(&opt.Sensor, "sensor", "", "Name of the sensor to calibrate")
+	flags.IntVar(&opt.Connector, "connector", -1, "Sensor connector, when not in the config file")
+	flags.StringVar(&opt.ConfigFile, "config", "", "Path to the configuration file to update with the calibration")
+	flags.DurationVar(&opt.Duration, "duration", 10*time.Second, "How long to sample the sensor on each step")
+	flags.StringVar(&opt.GPIOChip, "gpio-chip", grow.DefaultChip, "GPIO chip with the sensor lines")
+	flags.StringVar(&opt.ReaderBackend, "reader-backend", GrowHAT, "Reader backend like growhat and simulated")
+	flags.DurationVar(&opt.SamplingWindow, "sampling-window", grow.DefaultMeasurementConfig.Window, "Minimum length of the window used to measure the pulse frequency")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opt, err
+	}
+
+	if opt.Sensor == "" {
+		return opt, fmt.Errorf("missing sensor name")
+	}
+	// the other backends aren't frequency sensors, ads1115 sensors are
+	// calibrated by setting their voltages in the config file
+	if opt.ReaderBackend != GrowHAT && opt.ReaderBackend != Simulated {
+		return opt, fmt.Errorf("invalid reader backend: %s, only %s and %s sensors can be calibrated", opt.ReaderBackend, GrowHAT, Simulated)
+	}
+	if opt.Duration < opt.SamplingWindow {
+		return opt, fmt.Errorf("duration %s shorter than the sampling window %s", opt.Duration, opt.SamplingWindow)
+	}
+
+	if opt.Connector >= 0 {
+		return opt, nil
+	}
+	if opt.ConfigFile != "" {
+		fc, err := ReadConfig(opt.ConfigFile)
+		if err != nil {
+			return opt, err
+		}
+		for _, s := range fc.Sensors {
+			if s.Name == opt.Sensor {
+				opt.Connector = s.Connector
+				return opt, nil
+			}
+		}
+	}
+	for _, s := range DefaultSensors {
+		sensorCfg := strings.Split(s, SensorSeparator)
+		if sensorCfg[0] == opt.Sensor {
+			opt.Connector, _ = strconv.Atoi(sensorCfg[1])
+			return opt, nil
+		}
+	}
+	return opt, fmt.Errorf("unknown connector for sensor %s", opt.Sensor)
+}
+
+// SensorFlag returns the --sensor flag value for a calibrated sensor.
+func SensorFlag(name string, connector int, minMoisture, maxMoisture float64) string {
+	return strings.Join([]string{
+		name,
+		strconv.Itoa(connector),
+		strconv.FormatFloat(minMoisture, 'f', 2, 64),
+		strconv.FormatFloat(maxMoisture, 'f', 2, 64),
+	}, SensorSeparator)
+}
+
+// SaveCalibration writes the moisture bounds of a sensor to the config file,
+// adding the sensor when it isn't there. Comments and other settings in the
+// file are kept.
+func SaveCalibration(path string, name string, connector int, minMoisture, maxMoisture float64) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+
+	doc := yaml.Node{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid config: not a map")
+	}
+
+	sensors := mappingValue(root, "sensors", yaml.SequenceNode)
+	if sensors.Kind != yaml.SequenceNode {
+		return fmt.Errorf("invalid config: sensors not a list")
+	}
+	var sensor *yaml.Node
+	for _, s := range sensors.Content {
+		n := lookup(s, "name")
+		if n != nil && n.Value == name {
+			sensor = s
+			break
+		}
+	}
+	if sensor == nil {
+		sensor = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		mappingValue(sensor, "name", yaml.ScalarNode).SetString(name)
+		setNumber(mappingValue(sensor, "connector", yaml.ScalarNode), strconv.Itoa(connector))
+		sensors.Content = append(sensors.Content, sensor)
+	}
+	setNumber(mappingValue(sensor, "minMoisture", yaml.ScalarNode), strconv.FormatFloat(minMoisture, 'f', 2, 64))
+	setNumber(mappingValue(sensor, "maxMoisture", yaml.ScalarNode), strconv.FormatFloat(maxMoisture, 'f', 2, 64))
+
+	out, err := encodeConfig(path, &doc)
+	if err != nil {
+		return fmt.Errorf("could not encode config: %w", err)
+	}
+
+	err = os.WriteFile(path, out, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// encodeConfig keeps JSON files in JSON, although without their comments
+// and key order.
+func encodeConfig(path string, doc *yaml.Node) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var v any
+		err := doc.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(v, "", "  ")
+	}
+
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	err := enc.Encode(doc)
+	return out.Bytes(), err
+}
+
+// mappingValue returns the value of key in a mapping node, adding it with
+// the given kind when missing or null, like "sensors:" without a value.
+func mappingValue(m *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	v := lookup(m, key)
+	if v == nil {
+		v = &yaml.Node{}
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	} else if v.Kind != yaml.ScalarNode || v.ShortTag() != "!!null" {
+		return v
+	}
+	*v = yaml.Node{Kind: kind, HeadComment: v.HeadComment, LineComment: v.LineComment, FootComment: v.FootComment}
+	if kind == yaml.SequenceNode {
+		v.Tag = "!!seq"
+	}
+	return v
+}
+
+// lookup returns the value of key in a mapping node, nil when missing.
+func lookup(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setNumber(n *yaml.Node, value string) {
+	n.Kind = yaml.ScalarNode
+	n.Style = 0
+	n.Tag = "!!float"
+	n.Value = value
+	if !strings.Contains(value, ".") {
+		n.Tag = "!!int"
+	}
+}
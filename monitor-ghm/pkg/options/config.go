@@ -0,0 +1,568 @@
+package options
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/filter"
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultConfigWatchInterval = 10 * time.Second
+
+// FileConfig is the configuration file format. JSON is a subset of YAML, so
+// both formats are accepted.
+type FileConfig struct {
+	Device          DeviceFileConfig `yaml:"device"`
+	DisconnectAfter time.Duration    `yaml:"disconnectTimeout"`
+	Frequency       time.Duration    `yaml:"frequency"`
+	GPIOChip        string           `yaml:"gpioChip"`
+	I2CBus          string           `yaml:"i2cBus"`
+	HTTP            HTTPFileConfig   `yaml:"http"`
+	Influx          InfluxFileConfig `yaml:"influx"`
+	Jitter          time.Duration    `yaml:"jitter"`
+	MetricsAddress  string           `yaml:"metricsAddress"`
+	MQTT            MQTTFileConfig   `yaml:"mqtt"`
+	LogLevel        string           `yaml:"logLevel"`
+	NATS            NATSFileConfig   `yaml:"nats"`
+	Publishers      []string         `yaml:"publishers"`
+	PumpDriver      string           `yaml:"pumpDriver"`
+	RangeTolerance  float64          `yaml:"rangeTolerance"`
+	ReaderBackend   string           `yaml:"readerBackend"`
+	SamplingWindow  time.Duration    `yaml:"samplingWindow"`
+	Sensors         []SensorConfig   `yaml:"sensors"`
+	ShutdownTimeout time.Duration    `yaml:"shutdownTimeout"`
+	SimulationSpeed float64          `yaml:"simulationSpeed"`
+	StaleTimeout    time.Duration    `yaml:"staleTimeout"`
+	StatusAddress   string           `yaml:"statusAddress"`
+	WateringDryRun  *bool            `yaml:"wateringDryRun"`
+	Workers         int              `yaml:"workers"`
+}
+
+type DeviceFileConfig struct {
+	ID       string            `yaml:"id"`
+	Location string            `yaml:"location"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+type NATSFileConfig struct {
+	URL               string         `yaml:"url"`
+	CAFile            string         `yaml:"ca"`
+	CertFile          string         `yaml:"cert"`
+	KeyFile           string         `yaml:"key"`
+	CredsFile         string         `yaml:"creds"`
+	NKeyFile          string         `yaml:"nkey"`
+	Token             string         `yaml:"token"`
+	User              string         `yaml:"user"`
+	Password          string         `yaml:"password"`
+	KVBucket          string         `yaml:"kvBucket"`
+	StreamName        string         `yaml:"stream"`
+	StreamSubject     string         `yaml:"subject"`
+	StreamReplicas    int            `yaml:"replicas"`
+	HeartbeatInterval *time.Duration `yaml:"heartbeatInterval"`
+	HeartbeatSubject  string         `yaml:"heartbeatSubject"`
+	WateringSubject   string         `yaml:"wateringSubject"`
+	WateringStream    string         `yaml:"wateringStream"`
+	CommandSubject    string         `yaml:"commandSubject"`
+	CommandSecret     string         `yaml:"commandSecret"`
+	OutboxPath        string         `yaml:"outbox"`
+	OutboxSize        int            `yaml:"outboxSize"`
+	OutboxEviction    string         `yaml:"outboxEviction"`
+}
+
+type MQTTFileConfig struct {
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"clientID"`
+	Topic    string `yaml:"topic"`
+	QoS      *byte  `yaml:"qos"`
+	Retain   *bool  `yaml:"retain"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	CAFile   string `yaml:"ca"`
+	CertFile string `yaml:"cert"`
+	KeyFile  string `yaml:"key"`
+}
+
+type HTTPFileConfig struct {
+	URL           string            `yaml:"url"`
+	Method        string            `yaml:"method"`
+	Headers       map[string]string `yaml:"headers"`
+	Template      string            `yaml:"template"`
+	HMACSecret    string            `yaml:"hmacSecret"`
+	BatchInterval time.Duration     `yaml:"batchInterval"`
+	BatchSize     int               `yaml:"batchSize"`
+	Retries       *int              `yaml:"retries"`
+}
+
+type InfluxFileConfig struct {
+	URL           string        `yaml:"url"`
+	Org           string        `yaml:"org"`
+	Bucket        string        `yaml:"bucket"`
+	Token         string        `yaml:"token"`
+	Precision     string        `yaml:"precision"`
+	BatchInterval time.Duration `yaml:"batchInterval"`
+	BatchSize     int           `yaml:"batchSize"`
+	Retries       *int          `yaml:"retries"`
+}
+
+// SensorConfig uses the same semantics as the --sensor flag: minMoisture and
+// maxMoisture are the frequencies read with dry and wet soil.
+type SensorConfig struct {
+	Name        string             `yaml:"name"`
+	Backend     string             `yaml:"backend"`
+	Metric      string             `yaml:"metric"`
+	Connector   int                `yaml:"connector"`
+	Address     uint16             `yaml:"address"`
+	ADC         ADCFileConfig      `yaml:"adc"`
+	Watering    WateringFileConfig `yaml:"watering"`
+	Filters     []filter.Config    `yaml:"filters"`
+	Interval    time.Duration      `yaml:"interval"`
+	Jitter      time.Duration      `yaml:"jitter"`
+	MinMoisture *float64           `yaml:"minMoisture"`
+	MaxMoisture *float64           `yaml:"maxMoisture"`
+}
+
+// ADCFileConfig is the input and the calibration of a sensor of the ads1115
+// backend, gain and sampleRate default to 4.096V and 128 samples per second.
+type ADCFileConfig struct {
+	Channel    int      `yaml:"channel"`
+	Gain       float64  `yaml:"gain"`
+	SampleRate int      `yaml:"sampleRate"`
+	DryVoltage *float64 `yaml:"dryVoltage"`
+	WetVoltage *float64 `yaml:"wetVoltage"`
+}
+
+// WateringFileConfig is the pump watering the plant of a sensor. Without a
+// target, the target is as far above the threshold as the default target is
+// above the default threshold, up to 100. The other values default to the
+// ones in the README.
+type WateringFileConfig struct {
+	Pump      int            `yaml:"pump"`
+	Speed     float64        `yaml:"speed"`
+	Threshold float64        `yaml:"threshold"`
+	Target    float64        `yaml:"target"`
+	Dose      time.Duration  `yaml:"dose"`
+	Soak      *time.Duration `yaml:"soak"`
+	MaxDoses  int            `yaml:"maxDoses"`
+	Cooldown  *time.Duration `yaml:"cooldown"`
+	DailyMax  *int           `yaml:"dailyMax"`
+}
+
+func ParseConfig(data []byte) (FileConfig, error) {
+	fc := FileConfig{}
+	err := yaml.Unmarshal(data, &fc)
+	if err != nil {
+		return fc, fmt.Errorf("invalid config: %w", err)
+	}
+	return fc, nil
+}
+
+func ReadConfig(path string) (FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileConfig{}, fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+	return ParseConfig(data)
+}
+
+// Merge applies the file configuration on top of opt. Values set explicitly
+// on the command line take precedence over the ones in the file. The log
+// level of opt isn't changed, a level in the file replaces it once the
+// options are valid.
+func (fc FileConfig) Merge(opt Options) (Options, error) {
+	var logLevel *slog.Level
+	if fc.Device.ID != "" && !flagChanged("device-id") {
+		opt.Device.ID = fc.Device.ID
+	}
+	if fc.Device.Location != "" && !flagChanged("device-location") {
+		opt.Device.Location = fc.Device.Location
+	}
+	if len(fc.Device.Labels) > 0 && !flagChanged("device-label") {
+		opt.Device.Labels = fc.Device.Labels
+	}
+	if fc.Frequency != 0 && !flagChanged("readings-frequency") {
+		opt.Frequency = fc.Frequency
+	}
+	if fc.Jitter != 0 && !flagChanged("readings-jitter") {
+		opt.Jitter = fc.Jitter
+	}
+	if fc.Workers != 0 && !flagChanged("workers") {
+		opt.Workers = fc.Workers
+	}
+	if fc.LogLevel != "" && !flagChanged("log-level") {
+		level := slog.LevelInfo
+		err := level.UnmarshalText([]byte(fc.LogLevel))
+		if err != nil {
+			return opt, fmt.Errorf("invalid log level value: %s", fc.LogLevel)
+		}
+		logLevel = &level
+	}
+	if len(fc.Publishers) > 0 && !flagChanged("publisher") {
+		opt.Publishers = fc.Publishers
+	}
+	if fc.DisconnectAfter != 0 && !flagChanged("disconnect-timeout") {
+		opt.DisconnectAfter = fc.DisconnectAfter
+	}
+	if fc.RangeTolerance != 0 && !flagChanged("range-tolerance") {
+		opt.RangeTolerance = fc.RangeTolerance
+	}
+	if fc.GPIOChip != "" && !flagChanged("gpio-chip") {
+		opt.GPIOChip = fc.GPIOChip
+	}
+	if fc.I2CBus != "" && !flagChanged("i2c-bus") {
+		opt.I2CBus = fc.I2CBus
+	}
+	if fc.PumpDriver != "" && !flagChanged("pump-driver") {
+		opt.PumpDriver = fc.PumpDriver
+	}
+	if fc.WateringDryRun != nil && !flagChanged("watering-dry-run") {
+		opt.WateringDryRun = *fc.WateringDryRun
+	}
+	if fc.MetricsAddress != "" && !flagChanged("metrics-address") {
+		opt.MetricsAddress = fc.MetricsAddress
+	}
+	if fc.StatusAddress != "" && !flagChanged("status-address") {
+		opt.StatusAddress = fc.StatusAddress
+	}
+	if fc.ReaderBackend != "" && !flagChanged("reader-backend") {
+		opt.ReaderBackend = fc.ReaderBackend
+	}
+	if fc.SamplingWindow != 0 && !flagChanged("sampling-window") {
+		opt.SamplingWindow = fc.SamplingWindow
+	}
+	if fc.StaleTimeout != 0 && !flagChanged("stale-timeout") {
+		opt.StaleTimeout = fc.StaleTimeout
+	}
+	if fc.ShutdownTimeout != 0 && !flagChanged("shutdown-timeout") {
+		opt.ShutdownTimeout = fc.ShutdownTimeout
+	}
+	if fc.SimulationSpeed != 0 && !flagChanged("simulation-speed") {
+		opt.SimulationSpeed = fc.SimulationSpeed
+	}
+	if fc.NATS.URL != "" && !flagChanged("nats-url") {
+		opt.NATS.URL = fc.NATS.URL
+	}
+	if fc.NATS.CAFile != "" && !flagChanged("nats-ca") {
+		opt.NATS.CAFile = fc.NATS.CAFile
+	}
+	if fc.NATS.CertFile != "" && !flagChanged("nats-cert") {
+		opt.NATS.CertFile = fc.NATS.CertFile
+	}
+	if fc.NATS.KeyFile != "" && !flagChanged("nats-key") {
+		opt.NATS.KeyFile = fc.NATS.KeyFile
+	}
+	if fc.NATS.CredsFile != "" && !flagChanged("nats-creds") {
+		opt.NATS.CredsFile = fc.NATS.CredsFile
+	}
+	if fc.NATS.NKeyFile != "" && !flagChanged("nats-nkey") {
+		opt.NATS.NKeyFile = fc.NATS.NKeyFile
+	}
+	if fc.NATS.Token != "" && !flagChanged("nats-token") {
+		opt.NATS.Token = fc.NATS.Token
+	}
+	if fc.NATS.User != "" && !flagChanged("nats-user") {
+		opt.NATS.User = fc.NATS.User
+	}
+	if fc.NATS.Password != "" && !flagChanged("nats-password") {
+		opt.NATS.Password = fc.NATS.Password
+	}
+	if fc.NATS.KVBucket != "" && !flagChanged("nats-kv-bucket") {
+		opt.NATS.KVBucket = fc.NATS.KVBucket
+	}
+	if fc.NATS.StreamName != "" && !flagChanged("nats-stream") {
+		opt.NATS.StreamName = fc.NATS.StreamName
+	}
+	if fc.NATS.StreamSubject != "" && !flagChanged("nats-stream-sub") {
+		opt.NATS.StreamSubject = fc.NATS.StreamSubject
+	}
+	if fc.NATS.StreamReplicas != 0 && !flagChanged("nats-stream-replicas") {
+		opt.NATS.StreamReplicas = fc.NATS.StreamReplicas
+	}
+	if fc.NATS.HeartbeatInterval != nil && !flagChanged("nats-heartbeat-interval") {
+		opt.NATS.HeartbeatInterval = *fc.NATS.HeartbeatInterval
+	}
+	if fc.NATS.HeartbeatSubject != "" && !flagChanged("nats-heartbeat-subject") {
+		opt.NATS.HeartbeatSubject = fc.NATS.HeartbeatSubject
+	}
+	if fc.NATS.WateringSubject != "" && !flagChanged("nats-watering-subject") {
+		opt.NATS.WateringSubject = fc.NATS.WateringSubject
+	}
+	if fc.NATS.CommandSubject != "" && !flagChanged("nats-command-subject") {
+		opt.NATS.CommandSubject = fc.NATS.CommandSubject
+	}
+	if fc.NATS.CommandSecret != "" && !flagChanged("nats-command-secret") {
+		opt.NATS.CommandSecret = fc.NATS.CommandSecret
+	}
+	if fc.NATS.WateringStream != "" && !flagChanged("nats-watering-stream") {
+		opt.NATS.WateringStream = fc.NATS.WateringStream
+	}
+	if fc.NATS.OutboxPath != "" && !flagChanged("nats-outbox") {
+		opt.NATS.OutboxPath = fc.NATS.OutboxPath
+	}
+	if fc.NATS.OutboxSize != 0 && !flagChanged("nats-outbox-size") {
+		opt.NATS.OutboxSize = fc.NATS.OutboxSize
+	}
+	if fc.NATS.OutboxEviction != "" && !flagChanged("nats-outbox-eviction") {
+		opt.NATS.OutboxEviction = fc.NATS.OutboxEviction
+	}
+	opt.MQTT = fc.MQTT.merge(opt.MQTT)
+	opt.HTTP = fc.HTTP.merge(opt.HTTP)
+	opt.Influx = fc.Influx.merge(opt.Influx)
+	if len(fc.Sensors) > 0 && !flagChanged("sensor") {
+		sensors, err := fc.SensorsOptions()
+		if err != nil {
+			return opt, err
+		}
+		opt.Sensors = sensors
+	}
+	err := opt.Validate()
+	if err != nil {
+		return opt, err
+	}
+	if logLevel != nil {
+		opt.LogLevel = &slog.LevelVar{}
+		opt.LogLevel.Set(*logLevel)
+	}
+	return opt, nil
+}
+
+func (fc ADCFileConfig) merge(config ADCConfig) ADCConfig {
+	config.Channel = fc.Channel
+	if fc.Gain != 0 {
+		config.Gain = fc.Gain
+	}
+	if fc.SampleRate != 0 {
+		config.SampleRate = fc.SampleRate
+	}
+	if fc.DryVoltage != nil {
+		config.DryVoltage = *fc.DryVoltage
+	}
+	if fc.WetVoltage != nil {
+		config.WetVoltage = *fc.WetVoltage
+	}
+	return config
+}
+
+func (fc WateringFileConfig) merge(config WateringConfig) WateringConfig {
+	config.Pump = fc.Pump
+	if fc.Speed != 0 {
+		config.Speed = fc.Speed
+	}
+	if fc.Threshold != 0 {
+		config.Threshold = fc.Threshold
+		// keeps the top-up of the default target over the threshold
+		config.Target = min(fc.Threshold+DefaultWateringTarget-DefaultWateringThreshold, 100)
+	}
+	if fc.Target != 0 {
+		config.Target = fc.Target
+	}
+	if fc.Dose != 0 {
+		config.Dose = fc.Dose
+	}
+	if fc.Soak != nil {
+		config.Soak = *fc.Soak
+	}
+	if fc.MaxDoses != 0 {
+		config.MaxDoses = fc.MaxDoses
+	}
+	if fc.Cooldown != nil {
+		config.Cooldown = *fc.Cooldown
+	}
+	if fc.DailyMax != nil {
+		config.DailyMax = *fc.DailyMax
+	}
+	return config
+}
+
+func (fc MQTTFileConfig) merge(config MQTTConfig) MQTTConfig {
+	if fc.Broker != "" && !flagChanged("mqtt-broker") {
+		config.Broker = fc.Broker
+	}
+	if fc.ClientID != "" && !flagChanged("mqtt-client-id") {
+		config.ClientID = fc.ClientID
+	}
+	if fc.Topic != "" && !flagChanged("mqtt-topic") {
+		config.Topic = fc.Topic
+	}
+	if fc.QoS != nil && !flagChanged("mqtt-qos") {
+		config.QoS = *fc.QoS
+	}
+	if fc.Retain != nil && !flagChanged("mqtt-retain") {
+		config.Retain = *fc.Retain
+	}
+	if fc.Username != "" && !flagChanged("mqtt-username") {
+		config.Username = fc.Username
+	}
+	if fc.Password != "" && !flagChanged("mqtt-password") {
+		config.Password = fc.Password
+	}
+	if fc.CAFile != "" && !flagChanged("mqtt-ca") {
+		config.CAFile = fc.CAFile
+	}
+	if fc.CertFile != "" && !flagChanged("mqtt-cert") {
+		config.CertFile = fc.CertFile
+	}
+	if fc.KeyFile != "" && !flagChanged("mqtt-key") {
+		config.KeyFile = fc.KeyFile
+	}
+	return config
+}
+
+func (fc HTTPFileConfig) merge(config HTTPConfig) HTTPConfig {
+	if fc.URL != "" && !flagChanged("http-url") {
+		config.URL = fc.URL
+	}
+	if fc.Method != "" && !flagChanged("http-method") {
+		config.Method = fc.Method
+	}
+	if len(fc.Headers) > 0 && !flagChanged("http-header") {
+		config.Headers = fc.Headers
+	}
+	if fc.Template != "" && !flagChanged("http-template") {
+		config.Template = fc.Template
+	}
+	if fc.HMACSecret != "" && !flagChanged("http-hmac-secret") {
+		config.HMACSecret = fc.HMACSecret
+	}
+	if fc.BatchInterval != 0 && !flagChanged("http-batch-interval") {
+		config.BatchInterval = fc.BatchInterval
+	}
+	if fc.BatchSize != 0 && !flagChanged("http-batch-size") {
+		config.BatchSize = fc.BatchSize
+	}
+	if fc.Retries != nil && !flagChanged("http-retries") {
+		config.Retries = *fc.Retries
+	}
+	return config
+}
+
+func (fc InfluxFileConfig) merge(config InfluxConfig) InfluxConfig {
+	if fc.URL != "" && !flagChanged("influx-url") {
+		config.URL = fc.URL
+	}
+	if fc.Org != "" && !flagChanged("influx-org") {
+		config.Org = fc.Org
+	}
+	if fc.Bucket != "" && !flagChanged("influx-bucket") {
+		config.Bucket = fc.Bucket
+	}
+	if fc.Token != "" && !flagChanged("influx-token") {
+		config.Token = fc.Token
+	}
+	if fc.Precision != "" && !flagChanged("influx-precision") {
+		config.Precision = fc.Precision
+	}
+	if fc.BatchInterval != 0 && !flagChanged("influx-batch-interval") {
+		config.BatchInterval = fc.BatchInterval
+	}
+	if fc.BatchSize != 0 && !flagChanged("influx-batch-size") {
+		config.BatchSize = fc.BatchSize
+	}
+	if fc.Retries != nil && !flagChanged("influx-retries") {
+		config.Retries = *fc.Retries
+	}
+	return config
+}
+
+// SensorsOptions converts the sensors in the file to the format used by the
+// readers, filling in the default moisture bounds.
+func (fc FileConfig) SensorsOptions() ([]Sensors, error) {
+	sensors := []Sensors{}
+	for _, s := range fc.Sensors {
+		if s.Name == "" {
+			return nil, fmt.Errorf("sensor without name on connector %d", s.Connector)
+		}
+		minMoisture := MinMoisture
+		maxMoisture := MaxMoisture
+		if s.MinMoisture != nil {
+			minMoisture = *s.MinMoisture
+		}
+		if s.MaxMoisture != nil {
+			maxMoisture = *s.MaxMoisture
+		}
+		sensor := newSensor(s.Name, s.Backend, s.Connector, minMoisture, maxMoisture)
+		sensor.Metric = grow.Metric(s.Metric)
+		sensor.Address = s.Address
+		sensor.ADC = s.ADC.merge(sensor.ADC)
+		sensor.Watering = s.Watering.merge(sensor.Watering)
+		sensor.Filters = s.Filters
+		sensor.Interval = s.Interval
+		sensor.Jitter = s.Jitter
+		sensors = append(sensors, sensor)
+	}
+	return sensors, nil
+}
+
+// WatchConfig polls the configuration file and calls onChange with the
+// options of the command line merged with the file every time the file is
+// modified. The log level of opt is changed in place, so the loggers using it
+// follow the file. Invalid files are logged and ignored, keeping the previous
+// configuration. The returned function stops the watcher, waiting for a
+// running onChange to return.
+func WatchConfig(path string, interval time.Duration, opt Options, onChange func(Options)) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	base := opt
+	if opt.flags != nil {
+		base = *opt.flags
+	}
+	lastMod, lastSize := fileVersion(path)
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			mod, size := fileVersion(path)
+			if mod.Equal(lastMod) && size == lastSize {
+				continue
+			}
+			lastMod, lastSize = mod, size
+
+			fc, err := ReadConfig(path)
+			if err != nil {
+				slog.Error("could not reload config", "path", path, "error", err)
+				continue
+			}
+			merged, err := fc.Merge(base)
+			if err != nil {
+				slog.Error("could not reload config", "path", path, "error", err)
+				continue
+			}
+			if opt.LogLevel != nil && merged.LogLevel != nil {
+				opt.LogLevel.Set(merged.LogLevel.Level())
+				merged.LogLevel = opt.LogLevel
+			}
+			slog.Info("config reloaded", "path", path)
+			onChange(merged)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func fileVersion(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+func flagChanged(name string) bool {
+	f := pflag.Lookup(name)
+	return f != nil && f.Changed
+}
@@ -3,23 +3,84 @@ package options
 import (
 	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/grow/monitor-ghm/pkg/filter"
 	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/natsclient"
+	"github.com/grow/monitor-ghm/pkg/outbox"
+	"github.com/grow/monitor-ghm/pkg/water"
 	"github.com/spf13/pflag"
 )
 
 const (
-	Console         = "console" // Console publisher
-	NATS            = "nats"    // NATS publisher
+	Console         = "console"    // Console publisher
+	HTTP            = "http"       // HTTP publisher
+	Influx          = "influx"     // InfluxDB publisher
+	MQTT            = "mqtt"       // MQTT publisher
+	NATS            = "nats"       // NATS publisher
+	Prometheus      = "prometheus" // Prometheus metrics publisher
+	GrowHAT         = "growhat"    // Grow HAT Mini reader backend
+	LTR559          = "ltr559"     // Grow HAT Mini light sensor reader backend
+	BME280          = "bme280"     // BME280 breakout reader backend
+	ADS1115         = "ads1115"    // ADS1115 analog sensors reader backend
+	Simulated       = "simulated"  // Simulated reader backend
+	GPIOPump        = "gpio"       // Grow HAT Mini pump driver
+	FakePump        = "fake"       // Fake pump driver, for testing without pumps
 	DefaultNATSURL  = "nats://192.168.1.2:4222"
 	SensorSeparator = "|"
 	MaxMoisture     = 6.5
 	MinMoisture     = 25.5
+	DryVoltage      = 2.8
+	WetVoltage      = 1.3
 )
 
+const (
+	DefaultHeartbeatInterval = time.Minute
+	DefaultHeartbeatSubject  = "GrowHeartbeats.{device}"
+	DefaultWateringSubject   = "GrowWatering.{device}"
+	DefaultWateringStream    = "GrowWatering"
+
+	DefaultMQTTBroker = "tcp://192.168.1.2:1883"
+	DefaultMQTTTopic  = "grow/{device}/{sensor}"
+
+	DefaultMetricsAddress = ":2112"
+
+	DefaultShutdownTimeout = 10 * time.Second
+	DefaultWorkers         = 4
+
+	DefaultHTTPMethod  = "POST"
+	DefaultHTTPRetries = 3
+
+	DefaultInfluxURL           = "http://192.168.1.2:8086"
+	DefaultInfluxPrecision     = "s"
+	DefaultInfluxBatchSize     = 100
+	DefaultInfluxBatchInterval = time.Minute
+
+	DefaultPumpSpeed         = 0.7
+	DefaultWateringThreshold = 30
+	DefaultWateringTarget    = 40
+	DefaultWateringDose      = time.Second
+	DefaultWateringSoak      = 5 * time.Minute
+	DefaultWateringMaxDoses  = 3
+	DefaultWateringCooldown  = time.Hour
+	DefaultWateringDailyMax  = 10
+)
+
+// backendMetrics are the metrics each reader backend measures, the first
+// one is the default
+var backendMetrics = map[string][]grow.Metric{
+	GrowHAT:   {grow.MetricMoisture},
+	Simulated: {grow.MetricMoisture},
+	LTR559:    {grow.MetricLight, grow.MetricProximity},
+	BME280:    {grow.MetricTemperature, grow.MetricHumidity, grow.MetricPressure},
+	ADS1115:   {grow.MetricMoisture},
+}
+
 var DefaultSensors = []string{
 	fmt.Sprintf("%s%s%d", "espadas", SensorSeparator, grow.Moisture1),
 	fmt.Sprintf("%s%s%d", "abacateiro", SensorSeparator, grow.Moisture2),
@@ -28,24 +89,141 @@ var DefaultSensors = []string{
 
 type Sensors struct {
 	Name        string
+	Backend     string
+	Metric      grow.Metric // defaults to the first metric of the backend
 	Connector   int
+	Address     uint16 // I2C address, defaults to the one of the backend
+	ADC         ADCConfig
+	Watering    WateringConfig
+	Filters     []filter.Config
+	Interval    time.Duration // defaults to the readings frequency
+	Jitter      time.Duration // defaults to the readings jitter
 	MaxMoisture float64
 	MinMoisture float64
 }
 
+// ADCConfig is the input and the calibration of an analog sensor.
+type ADCConfig struct {
+	Channel    int
+	Gain       float64 // full scale voltage
+	SampleRate int     // samples per second
+	DryVoltage float64 // voltage read with dry soil, 0%
+	WetVoltage float64 // voltage read with wet soil, 100%
+}
+
+// WateringConfig is the pump watering the plant of a moisture sensor, the
+// watering is disabled when Pump is 0.
+type WateringConfig struct {
+	Pump  int     // pump output of the Grow HAT Mini, from 1 to 3
+	Speed float64 // pump duty cycle, from 0 to 1
+	water.Config
+}
+
+// Device identifies the device the readings come from, so sensors with the
+// same name on different devices don't collide.
+type Device struct {
+	ID       string
+	Hostname string
+	Location string
+	Labels   map[string]string
+}
+
 type NATSConfig struct {
 	URL string
 
-	StreamName    string
-	StreamSubject string
+	// TLS and authentication, shared with the ingestion service
+	natsclient.Security
+
+	HeartbeatInterval time.Duration
+	HeartbeatSubject  string
+	WateringSubject   string
+	WateringStream    string
+
+	// commands are authorized by the secret, when set, and the NATS
+	// permissions
+	CommandSubject string
+	CommandSecret  string
+
+	KVBucket       string
+	StreamName     string
+	StreamReplicas int
+	StreamSubject  string
+
+	OutboxEviction string
+	OutboxPath     string
+	OutboxSize     int
+}
+
+type MQTTConfig struct {
+	Broker   string
+	ClientID string
+	Topic    string
+	QoS      byte
+	Retain   bool
+
+	Username string
+	Password string
+
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+type HTTPConfig struct {
+	URL        string
+	Method     string
+	Headers    map[string]string
+	Template   string
+	HMACSecret string
+
+	BatchInterval time.Duration
+	BatchSize     int
+	Retries       int
+}
+
+type InfluxConfig struct {
+	URL       string
+	Org       string
+	Bucket    string
+	Token     string
+	Precision string
+
+	BatchInterval time.Duration
+	BatchSize     int
+	Retries       int
 }
 
 type Options struct {
-	Frequency  time.Duration
-	NATS       NATSConfig
-	Publishers []string
-	Sensors    []Sensors
-	LogLevel   *slog.LevelVar
+	ConfigFile      string
+	Device          Device
+	DisconnectAfter time.Duration
+	Frequency       time.Duration
+	Jitter          time.Duration
+	GPIOChip        string
+	I2CBus          string
+	HTTP            HTTPConfig
+	Influx          InfluxConfig
+	MetricsAddress  string
+	MQTT            MQTTConfig
+	NATS            NATSConfig
+	Once            bool
+	PumpDriver      string
+	Publishers      []string
+	RangeTolerance  float64
+	Samples         int
+	ReaderBackend   string
+	SamplingWindow  time.Duration
+	Sensors         []Sensors
+	SimulationSpeed float64
+	ShutdownTimeout time.Duration
+	StaleTimeout    time.Duration
+	StatusAddress   string
+	WateringDryRun  bool
+	Workers         int
+	LogLevel        *slog.LevelVar
+
+	// command line options, the config file reloads are merged onto them
+	flags *Options
 }
 
 func Get() (Options, error) {
@@ -53,14 +231,82 @@ func Get() (Options, error) {
 	opt := Options{}
 	var sensors []string
 	var logLevelValue string
+	var httpHeaders []string
+	var deviceLabels []string
 
 	pflag.DurationVar(&opt.Frequency, "readings-frequency", 5*time.Minute, "How frequently data is read from the sensors")
-	pflag.StringArrayVar(&opt.Publishers, "publisher", []string{NATS}, "Which data publishers to use like console and nats")
+	pflag.DurationVar(&opt.Jitter, "readings-jitter", 0, "Maximum random delay added to each reading, to spread them over time")
+	pflag.IntVar(&opt.Workers, "workers", DefaultWorkers, "Maximum number of sensors read and published at the same time")
+	pflag.BoolVar(&opt.Once, "once", false, "Reads the sensors once, publishes the readings and exits, with a non-zero status when they couldn't be published")
+	pflag.IntVar(&opt.Samples, "samples", 1, "Number of readings taken from each sensor with --once")
+	pflag.DurationVar(&opt.ShutdownTimeout, "shutdown-timeout", DefaultShutdownTimeout, "How long to wait for the readings in flight to be published when stopping")
+	pflag.StringArrayVar(&opt.Publishers, "publisher", []string{NATS}, "Which data publishers to use like console, nats, mqtt, prometheus, http and influx")
 	pflag.StringVar(&opt.NATS.URL, "nats-url", DefaultNATSURL, "NATS URL to publish the messages")
 	pflag.StringVar(&opt.NATS.StreamName, "nats-stream", "PlantReadings", "NATS stream name to publish messages")
 	pflag.StringVar(&opt.NATS.StreamSubject, "nats-stream-sub", "PlantReadings.home", "NATS stream subject name to publish messages")
-	pflag.StringArrayVar(&sensors, "sensor", DefaultSensors, `List of sensors in the "<name>,<sensor-pin>" format`)
+	pflag.StringVar(&opt.NATS.CAFile, "nats-ca", "", "CA certificate file to verify the NATS server")
+	pflag.StringVar(&opt.NATS.CertFile, "nats-cert", "", "Client certificate file for the NATS server")
+	pflag.StringVar(&opt.NATS.KeyFile, "nats-key", "", "Client key file for the NATS server")
+	pflag.StringVar(&opt.NATS.CredsFile, "nats-creds", "", "NATS credentials file with the user JWT and NKey seed")
+	pflag.StringVar(&opt.NATS.NKeyFile, "nats-nkey", "", "NATS NKey seed file")
+	pflag.StringVar(&opt.NATS.Token, "nats-token", "", "NATS authentication token")
+	pflag.StringVar(&opt.NATS.User, "nats-user", "", "NATS user")
+	pflag.StringVar(&opt.NATS.Password, "nats-password", "", "NATS password")
+	pflag.IntVar(&opt.NATS.StreamReplicas, "nats-stream-replicas", 3, "Number of replicas of the NATS stream, 1 for a single server")
+	pflag.StringVar(&opt.NATS.KVBucket, "nats-kv-bucket", "", "NATS KeyValue bucket with the sensors configuration, keyed by device ID")
+	pflag.DurationVar(&opt.NATS.HeartbeatInterval, "nats-heartbeat-interval", DefaultHeartbeatInterval, "How frequently the device status is published to NATS, 0 to disable it")
+	pflag.StringVar(&opt.NATS.HeartbeatSubject, "nats-heartbeat-subject", DefaultHeartbeatSubject, "NATS subject of the device status, {device} is replaced by the device ID")
+	pflag.StringVar(&opt.NATS.CommandSubject, "nats-command-subject", "", "NATS subject prefix of the commands like grow.cmd.{device}, {device} is replaced by the device ID, empty to disable them")
+	pflag.StringVar(&opt.NATS.CommandSecret, "nats-command-secret", "", "Shared secret the command requests must include")
+	pflag.StringVar(&opt.NATS.WateringSubject, "nats-watering-subject", DefaultWateringSubject, "NATS subject of the watering events, {device} is replaced by the device ID")
+	pflag.StringVar(&opt.NATS.WateringStream, "nats-watering-stream", DefaultWateringStream, "NATS stream name of the watering events")
+	pflag.StringVar(&opt.NATS.OutboxPath, "nats-outbox", "", "File to save the readings that can't be published to NATS, replayed once NATS is reachable")
+	pflag.IntVar(&opt.NATS.OutboxSize, "nats-outbox-size", 10000, "Maximum number of readings in the NATS outbox")
+	pflag.StringVar(&opt.NATS.OutboxEviction, "nats-outbox-eviction", outbox.DropOldest, "What to do when the NATS outbox is full like drop-oldest and drop-newest")
+	pflag.StringVar(&opt.MQTT.Broker, "mqtt-broker", DefaultMQTTBroker, "MQTT broker URL like tcp://host:1883 and ssl://host:8883")
+	pflag.StringVar(&opt.MQTT.ClientID, "mqtt-client-id", "", "MQTT client ID, defaults to monitorghm-<device-id>")
+	pflag.StringVar(&opt.MQTT.Topic, "mqtt-topic", DefaultMQTTTopic, "MQTT topic template, {device}, {sensor} and {connector} are replaced")
+	pflag.Uint8Var(&opt.MQTT.QoS, "mqtt-qos", 1, "MQTT quality of service like 0, 1 and 2")
+	pflag.BoolVar(&opt.MQTT.Retain, "mqtt-retain", false, "Whether the MQTT broker retains the last reading")
+	pflag.StringVar(&opt.MQTT.Username, "mqtt-username", "", "MQTT username")
+	pflag.StringVar(&opt.MQTT.Password, "mqtt-password", "", "MQTT password")
+	pflag.StringVar(&opt.MQTT.CAFile, "mqtt-ca", "", "CA certificate file to verify the MQTT broker")
+	pflag.StringVar(&opt.MQTT.CertFile, "mqtt-cert", "", "Client certificate file for the MQTT broker")
+	pflag.StringVar(&opt.MQTT.KeyFile, "mqtt-key", "", "Client key file for the MQTT broker")
+	pflag.StringVar(&opt.HTTP.URL, "http-url", "", "URL the HTTP publisher sends the readings to")
+	pflag.StringVar(&opt.HTTP.Method, "http-method", DefaultHTTPMethod, "HTTP method used by the HTTP publisher")
+	pflag.StringArrayVar(&httpHeaders, "http-header", nil, `Header sent by the HTTP publisher in the "<name>: <value>" format`)
+	pflag.StringVar(&opt.HTTP.Template, "http-template", "", "Go template of the JSON body sent by the HTTP publisher, with .Device and .Readings")
+	pflag.StringVar(&opt.HTTP.HMACSecret, "http-hmac-secret", "", "Secret used to sign the HTTP publisher body with HMAC-SHA256")
+	pflag.IntVar(&opt.HTTP.BatchSize, "http-batch-size", 1, "Number of readings sent together by the HTTP publisher")
+	pflag.DurationVar(&opt.HTTP.BatchInterval, "http-batch-interval", 0, "How frequently the HTTP publisher sends incomplete batches, 0 to wait until they are full")
+	pflag.IntVar(&opt.HTTP.Retries, "http-retries", DefaultHTTPRetries, "Number of retries of the HTTP publisher on server errors")
+	pflag.StringVar(&opt.Influx.URL, "influx-url", DefaultInfluxURL, "InfluxDB URL the influx publisher writes the readings to")
+	pflag.StringVar(&opt.Influx.Org, "influx-org", "", "InfluxDB organization")
+	pflag.StringVar(&opt.Influx.Bucket, "influx-bucket", "", "InfluxDB bucket")
+	pflag.StringVar(&opt.Influx.Token, "influx-token", "", "InfluxDB API token")
+	pflag.StringVar(&opt.Influx.Precision, "influx-precision", DefaultInfluxPrecision, "Precision of the InfluxDB timestamps like s, ms, us and ns")
+	pflag.IntVar(&opt.Influx.BatchSize, "influx-batch-size", DefaultInfluxBatchSize, "Number of readings written together to InfluxDB")
+	pflag.DurationVar(&opt.Influx.BatchInterval, "influx-batch-interval", DefaultInfluxBatchInterval, "How frequently incomplete batches are written to InfluxDB, 0 to wait until they are full")
+	pflag.IntVar(&opt.Influx.Retries, "influx-retries", DefaultHTTPRetries, "Number of retries of the InfluxDB writes on server errors")
+	pflag.StringVar(&opt.MetricsAddress, "metrics-address", DefaultMetricsAddress, "Address serving the /metrics endpoint of the prometheus publisher")
+	pflag.StringVar(&opt.StatusAddress, "status-address", "", "Address serving the status page and its JSON API like :8080, empty to disable it")
+	pflag.StringVar(&opt.Device.ID, "device-id", defaultDeviceID(), "Identifier of this device, defaults to the hostname")
+	pflag.StringVar(&opt.Device.Location, "device-location", "", "Location or room of this device")
+	pflag.StringArrayVar(&deviceLabels, "device-label", nil, `Label of this device in the "<name>=<value>" format`)
+	pflag.StringArrayVar(&sensors, "sensor", DefaultSensors, `List of sensors in the "<name>|<connector>[|<min-moisture>[|<max-moisture>]]" format`)
+	pflag.StringVar(&opt.ReaderBackend, "reader-backend", GrowHAT, "Default reader backend for the sensors like growhat and simulated")
+	pflag.DurationVar(&opt.SamplingWindow, "sampling-window", grow.DefaultMeasurementConfig.Window, "Minimum length of the window used to measure the sensors pulse frequency")
+	pflag.DurationVar(&opt.StaleTimeout, "stale-timeout", grow.DefaultMeasurementConfig.StaleAfter, "Time without sensor pulses after which a reading is stale")
+	pflag.DurationVar(&opt.DisconnectAfter, "disconnect-timeout", grow.DefaultHealthConfig.DisconnectAfter, "Time without sensor pulses after which a sensor is disconnected")
+	pflag.Float64Var(&opt.RangeTolerance, "range-tolerance", grow.DefaultHealthConfig.RangeTolerance, "Fraction of the calibration range accepted beyond its bounds before a sensor is out of range")
+	pflag.StringVar(&opt.GPIOChip, "gpio-chip", grow.DefaultChip, "GPIO chip with the sensor lines")
+	pflag.StringVar(&opt.I2CBus, "i2c-bus", grow.DefaultI2CBus, "I2C bus with the light and environment sensors")
+	pflag.StringVar(&opt.PumpDriver, "pump-driver", GPIOPump, "Driver of the watering pumps like gpio and fake")
+	pflag.BoolVar(&opt.WateringDryRun, "watering-dry-run", false, "Publishes the watering events without running the pumps")
+	pflag.Float64Var(&opt.SimulationSpeed, "simulation-speed", 1, "How much faster than real time the simulated soil dries")
 	pflag.StringVar(&logLevelValue, "log-level", "info", "Changes the log level like info, warn, error, and debug")
+	pflag.StringVar(&opt.ConfigFile, "config", "", "Path to a YAML or JSON configuration file, reloaded when changed")
 
 	pflag.Parse()
 
@@ -71,6 +317,16 @@ func Get() (Options, error) {
 	}
 	opt.LogLevel = levelVar
 
+	opt.HTTP.Headers, err = parseHeaders(httpHeaders)
+	if err != nil {
+		return opt, err
+	}
+	opt.Device.Hostname = defaultDeviceID()
+	opt.Device.Labels, err = parseLabels(deviceLabels)
+	if err != nil {
+		return opt, err
+	}
+
 	for _, s := range sensors {
 		sensorCfg := strings.Split(s, SensorSeparator)
 		if len(sensorCfg) < 2 || len(sensorCfg) > 4 {
@@ -86,22 +342,242 @@ func Get() (Options, error) {
 		if len(sensorCfg) >= 3 {
 			minMoisture, err = strconv.ParseFloat(sensorCfg[2], 64)
 			if err != nil {
-				return opt, fmt.Errorf("invalid mininum moisture value: %s", sensorCfg[2])
+				return opt, fmt.Errorf("invalid minimum moisture value: %s", sensorCfg[2])
 			}
 		}
 		if len(sensorCfg) == 4 {
 			maxMoisture, err = strconv.ParseFloat(sensorCfg[3], 64)
 			if err != nil {
-				return opt, fmt.Errorf("invalid mininum moisture value: %s", sensorCfg[2])
+				return opt, fmt.Errorf("invalid maximum moisture value: %s", sensorCfg[3])
 			}
 		}
-		opt.Sensors = append(opt.Sensors, Sensors{
-			Name:        sensorCfg[0],
-			Connector:   connector,
-			MaxMoisture: minMoisture,
-			MinMoisture: maxMoisture,
-		})
+		opt.Sensors = append(opt.Sensors, newSensor(sensorCfg[0], "", connector, minMoisture, maxMoisture))
+	}
+
+	if opt.ConfigFile != "" {
+		// the reloads start again from the command line, so the values
+		// removed from the file get back their flag value
+		flags := opt
+		flags.LogLevel = &slog.LevelVar{}
+		flags.LogLevel.Set(levelVar.Level())
+		opt.flags = &flags
+
+		fc, err := ReadConfig(opt.ConfigFile)
+		if err != nil {
+			return opt, err
+		}
+		opt, err = fc.Merge(opt)
+		if err != nil {
+			return opt, err
+		}
+	}
+
+	err = opt.Validate()
+	if err != nil {
+		return opt, err
 	}
 
 	return opt, nil
 }
+
+// Validate checks the values that can't be checked while parsing.
+func (opt Options) Validate() error {
+	err := validateBackend(opt.ReaderBackend)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, s := range opt.Sensors {
+		if names[s.Name] {
+			return fmt.Errorf("duplicate sensor name: %s", s.Name)
+		}
+		names[s.Name] = true
+		if s.Backend == "" {
+			continue
+		}
+		err := validateBackend(s.Backend)
+		if err != nil {
+			return fmt.Errorf("sensor %s: %w", s.Name, err)
+		}
+	}
+	for _, s := range opt.Sensors {
+		metric := opt.SensorMetric(s)
+		if !slices.Contains(backendMetrics[opt.SensorBackend(s)], metric) {
+			return fmt.Errorf("sensor %s: backend %s can't measure %s", s.Name, opt.SensorBackend(s), metric)
+		}
+		_, err := filter.NewChain(s.Filters)
+		if err != nil {
+			return fmt.Errorf("sensor %s: %w", s.Name, err)
+		}
+		if opt.SensorBackend(s) == ADS1115 && s.ADC.DryVoltage == s.ADC.WetVoltage {
+			return fmt.Errorf("sensor %s: same dry and wet voltage %g", s.Name, s.ADC.DryVoltage)
+		}
+		if opt.SensorInterval(s) <= 0 || opt.SensorJitter(s) < 0 {
+			return fmt.Errorf("sensor %s: invalid interval %s or jitter %s", s.Name, opt.SensorInterval(s), opt.SensorJitter(s))
+		}
+		if s.Watering.Pump != 0 && metric != grow.MetricMoisture {
+			return fmt.Errorf("sensor %s: only moisture sensors can water, not %s", s.Name, metric)
+		}
+		err = s.Watering.Validate()
+		if err != nil {
+			return fmt.Errorf("sensor %s: %w", s.Name, err)
+		}
+	}
+	pumps := map[int]string{}
+	for _, s := range opt.Sensors {
+		if s.Watering.Pump == 0 {
+			continue
+		}
+		if other, found := pumps[s.Watering.Pump]; found {
+			return fmt.Errorf("sensors %s and %s use the same pump %d", other, s.Name, s.Watering.Pump)
+		}
+		pumps[s.Watering.Pump] = s.Name
+	}
+	if opt.PumpDriver != GPIOPump && opt.PumpDriver != FakePump {
+		return fmt.Errorf("invalid pump driver: %s", opt.PumpDriver)
+	}
+	if opt.Samples < 1 {
+		return fmt.Errorf("invalid number of samples: %d", opt.Samples)
+	}
+	if opt.Workers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", opt.Workers)
+	}
+	if opt.StatusAddress != "" && opt.StatusAddress == opt.MetricsAddress && slices.Contains(opt.Publishers, Prometheus) {
+		return fmt.Errorf("status and metrics served on the same address %s", opt.StatusAddress)
+	}
+	return opt.NATS.Validate()
+}
+
+// Validate checks the pump and the watering thresholds, unless the watering
+// is disabled.
+func (config WateringConfig) Validate() error {
+	if config.Pump == 0 {
+		return nil
+	}
+	if _, found := grow.Pumps[config.Pump]; !found {
+		return fmt.Errorf("invalid pump %d, must be between 1 and %d", config.Pump, len(grow.Pumps))
+	}
+	if config.Speed <= 0 || config.Speed > 1 {
+		return fmt.Errorf("invalid pump speed %g, must be between 0 and 1", config.Speed)
+	}
+	if config.Threshold <= 0 || config.Threshold > 100 {
+		return fmt.Errorf("invalid watering threshold %g, must be between 0 and 100", config.Threshold)
+	}
+	if config.Target < config.Threshold || config.Target > 100 {
+		return fmt.Errorf("invalid watering target %g, must be between the threshold and 100", config.Target)
+	}
+	if config.Dose <= 0 || config.Soak < 0 || config.Cooldown < 0 {
+		return fmt.Errorf("invalid watering dose %s, soak %s or cooldown %s", config.Dose, config.Soak, config.Cooldown)
+	}
+	if config.MaxDoses < 1 || config.DailyMax < 0 {
+		return fmt.Errorf("invalid watering max doses %d or daily max %d", config.MaxDoses, config.DailyMax)
+	}
+	return nil
+}
+
+// SensorBackend returns the reader backend used by the sensor.
+func (opt Options) SensorBackend(s Sensors) string {
+	if s.Backend != "" {
+		return s.Backend
+	}
+	return opt.ReaderBackend
+}
+
+// SensorMetric returns what the sensor measures.
+func (opt Options) SensorMetric(s Sensors) grow.Metric {
+	if s.Metric != "" {
+		return s.Metric
+	}
+	metrics := backendMetrics[opt.SensorBackend(s)]
+	if len(metrics) == 0 {
+		return grow.MetricMoisture
+	}
+	return metrics[0]
+}
+
+// SensorInterval returns how frequently the sensor is read.
+func (opt Options) SensorInterval(s Sensors) time.Duration {
+	if s.Interval != 0 {
+		return s.Interval
+	}
+	return opt.Frequency
+}
+
+// SensorJitter returns the maximum random delay of the sensor readings.
+func (opt Options) SensorJitter(s Sensors) time.Duration {
+	if s.Jitter != 0 {
+		return s.Jitter
+	}
+	return opt.Jitter
+}
+
+func validateBackend(backend string) error {
+	switch backend {
+	case GrowHAT, Simulated, LTR559, BME280, ADS1115:
+		return nil
+	}
+	return fmt.Errorf("invalid reader backend: %s", backend)
+}
+
+func parseHeaders(headers []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, h := range headers {
+		name, value, found := strings.Cut(h, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header value: %s", h)
+		}
+		parsed[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return parsed, nil
+}
+
+func parseLabels(labels []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, l := range labels {
+		name, value, found := strings.Cut(l, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid label value: %s", l)
+		}
+		parsed[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return parsed, nil
+}
+
+func defaultDeviceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// newSensor converts the moisture values used on the command line and in the
+// config file, the frequencies read at the minimum and maximum moisture, to
+// the bounds used by the readers.
+func newSensor(name string, backend string, connector int, minMoisture, maxMoisture float64) Sensors {
+	return Sensors{
+		Name:        name,
+		Backend:     backend,
+		Connector:   connector,
+		MaxMoisture: minMoisture,
+		MinMoisture: maxMoisture,
+		ADC: ADCConfig{
+			Gain:       grow.DefaultADS1115Config.Gain,
+			SampleRate: grow.DefaultADS1115Config.SampleRate,
+			DryVoltage: DryVoltage,
+			WetVoltage: WetVoltage,
+		},
+		Watering: WateringConfig{
+			Speed: DefaultPumpSpeed,
+			Config: water.Config{
+				Threshold: DefaultWateringThreshold,
+				Target:    DefaultWateringTarget,
+				Dose:      DefaultWateringDose,
+				Soak:      DefaultWateringSoak,
+				MaxDoses:  DefaultWateringMaxDoses,
+				Cooldown:  DefaultWateringCooldown,
+				DailyMax:  DefaultWateringDailyMax,
+			},
+		},
+	}
+}
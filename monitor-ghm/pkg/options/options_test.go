@@ -0,0 +1,480 @@
+package options
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// flagOptions returns valid options like the command line defaults.
+func flagOptions() Options {
+	opt := Options{
+		Frequency:     5 * time.Minute,
+		Workers:       DefaultWorkers,
+		Samples:       1,
+		Publishers:    []string{NATS},
+		ReaderBackend: GrowHAT,
+		PumpDriver:    GPIOPump,
+		Sensors:       []Sensors{newSensor("pilea", "", 1, MinMoisture, MaxMoisture)},
+		LogLevel:      &slog.LevelVar{},
+	}
+	flags := opt
+	flags.LogLevel = &slog.LevelVar{}
+	opt.flags = &flags
+	return opt
+}
+
+func sensorNames(opt Options) []string {
+	names := []string{}
+	for _, s := range opt.Sensors {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		wantErr    bool
+		frequency  time.Duration
+		publishers []string
+		sensors    []string
+		level      slog.Level
+	}{
+		{
+			name:       "empty file",
+			file:       ``,
+			frequency:  5 * time.Minute,
+			publishers: []string{NATS},
+			sensors:    []string{"pilea"},
+			level:      slog.LevelInfo,
+		},
+		{
+			name: "file values",
+			file: `
+frequency: 1m
+publishers: [console, http]
+logLevel: debug
+sensors:
+  - name: basil
+    connector: 2
+  - name: mint
+    connector: 3
+`,
+			frequency:  time.Minute,
+			publishers: []string{Console, HTTP},
+			sensors:    []string{"basil", "mint"},
+			level:      slog.LevelDebug,
+		},
+		{
+			name: "invalid log level",
+			file: `
+frequency: 1m
+logLevel: loud
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid options keep the log level",
+			file: `
+logLevel: debug
+workers: -1
+`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate sensor names",
+			file: `
+sensors:
+  - name: basil
+    connector: 2
+  - name: basil
+    connector: 3
+`,
+			wantErr: true,
+		},
+		{
+			name: "sensor without name",
+			file: `
+sensors:
+  - connector: 2
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, err := ParseConfig([]byte(tt.file))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			opt := flagOptions()
+			level := opt.LogLevel
+
+			got, err := fc.Merge(opt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// the level of the options merged onto is never changed
+			if level.Level() != slog.LevelInfo {
+				t.Errorf("log level of the base = %s, want %s", level.Level(), slog.LevelInfo)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Frequency != tt.frequency {
+				t.Errorf("frequency = %s, want %s", got.Frequency, tt.frequency)
+			}
+			if !slices.Equal(got.Publishers, tt.publishers) {
+				t.Errorf("publishers = %q, want %q", got.Publishers, tt.publishers)
+			}
+			if names := sensorNames(got); !slices.Equal(names, tt.sensors) {
+				t.Errorf("sensors = %q, want %q", names, tt.sensors)
+			}
+			if got.LogLevel.Level() != tt.level {
+				t.Errorf("log level = %s, want %s", got.LogLevel.Level(), tt.level)
+			}
+		})
+	}
+}
+
+func TestWateringDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		watering  string
+		threshold float64
+		target    float64
+	}{
+		{
+			name:      "pump only",
+			watering:  `{pump: 1}`,
+			threshold: DefaultWateringThreshold,
+			target:    DefaultWateringTarget,
+		},
+		{
+			name:      "threshold",
+			watering:  `{pump: 1, threshold: 50}`,
+			threshold: 50,
+			target:    60,
+		},
+		{
+			name:      "threshold near 100",
+			watering:  `{pump: 1, threshold: 95}`,
+			threshold: 95,
+			target:    100,
+		},
+		{
+			name:      "target",
+			watering:  `{pump: 1, threshold: 50, target: 70}`,
+			threshold: 50,
+			target:    70,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, err := ParseConfig([]byte("sensors: [{name: pilea, connector: 1, watering: " + tt.watering + "}]"))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			opt, err := fc.Merge(flagOptions())
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			config := opt.Sensors[0].Watering
+			if config.Threshold != tt.threshold || config.Target != tt.target {
+				t.Errorf("threshold %g and target %g, want %g and %g", config.Threshold, config.Target, tt.threshold, tt.target)
+			}
+		})
+	}
+}
+
+// writeConfig replaces the config file at once, so the watchers don't read
+// it half written.
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) { writeConfig(t, path, data) }
+	write(`
+frequency: 1m
+logLevel: debug
+sensors:
+  - name: basil
+    connector: 2
+`)
+	fc, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, err := fc.Merge(flagOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan Options, 10)
+	stop := WatchConfig(path, 5*time.Millisecond, opt, func(o Options) { changes <- o })
+	defer stop()
+
+	tests := []struct {
+		name      string
+		file      string
+		frequency time.Duration
+		sensors   []string
+		level     slog.Level
+	}{
+		{
+			name:      "changed values",
+			file:      "frequency: 2m\nlogLevel: warn\nsensors:\n  - name: mint\n    connector: 3\n",
+			frequency: 2 * time.Minute,
+			sensors:   []string{"mint"},
+			level:     slog.LevelWarn,
+		},
+		{
+			name:      "removed values back to the flags",
+			file:      "workers: 2\n",
+			frequency: 5 * time.Minute,
+			sensors:   []string{"pilea"},
+			level:     slog.LevelInfo,
+		},
+		{
+			name:      "invalid file ignored",
+			file:      "logLevel: error\nworkers: -1\n",
+			frequency: 5 * time.Minute,
+			sensors:   []string{"pilea"},
+			level:     slog.LevelInfo,
+		},
+		{
+			name:      "values added again",
+			file:      "frequency: 3m\nlogLevel: error\n",
+			frequency: 3 * time.Minute,
+			sensors:   []string{"pilea"},
+			level:     slog.LevelError,
+		},
+	}
+	var got Options
+	for _, tt := range tests {
+		write(tt.file)
+		select {
+		case got = <-changes:
+		case <-time.After(200 * time.Millisecond):
+			if tt.name != "invalid file ignored" {
+				t.Fatalf("%s: no reload", tt.name)
+			}
+		}
+		if got.Frequency != tt.frequency {
+			t.Errorf("%s: frequency = %s, want %s", tt.name, got.Frequency, tt.frequency)
+		}
+		if names := sensorNames(got); !slices.Equal(names, tt.sensors) {
+			t.Errorf("%s: sensors = %q, want %q", tt.name, names, tt.sensors)
+		}
+		// the loggers keep using the level of the startup options
+		if got.LogLevel != opt.LogLevel || opt.LogLevel.Level() != tt.level {
+			t.Errorf("%s: log level = %s, want %s", tt.name, opt.LogLevel.Level(), tt.level)
+		}
+	}
+}
+
+func TestWatchConfigStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "frequency: 1m\n")
+	opt := flagOptions()
+
+	reloading := make(chan struct{})
+	release := make(chan struct{})
+	stop := WatchConfig(path, time.Millisecond, opt, func(Options) {
+		close(reloading)
+		<-release
+	})
+	writeConfig(t, path, "frequency: 2m\nworkers: 2\n")
+	<-reloading
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("stop returned while reloading")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("stop didn't return after the reload")
+	}
+
+	// no reload after stop
+	writeConfig(t, path, "frequency: 3m\nworkers: 3\n")
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestGetCalibrateBackend(t *testing.T) {
+	tests := []struct {
+		backend string
+		wantErr bool
+	}{
+		{backend: GrowHAT},
+		{backend: Simulated},
+		{backend: ADS1115, wantErr: true},
+		{backend: LTR559, wantErr: true},
+		{backend: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			_, err := GetCalibrate([]string{"--sensor", "pilea", "--connector", "1", "--reader-backend", tt.backend})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetCalibrate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSaveCalibration(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		ext     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "missing file",
+			want: `sensors:
+  - name: pilea
+    connector: 1
+    minMoisture: 25.00
+    maxMoisture: 6.00
+`,
+		},
+		{
+			name: "sensor updated",
+			file: `# plants of the living room
+frequency: 1m
+sensors:
+  - name: basil
+    connector: 2
+  - name: pilea # by the window
+    connector: 1
+    minMoisture: 20
+`,
+			want: `# plants of the living room
+frequency: 1m
+sensors:
+  - name: basil
+    connector: 2
+  - name: pilea # by the window
+    connector: 1
+    minMoisture: 25.00
+    maxMoisture: 6.00
+`,
+		},
+		{
+			name: "sensors without name kept",
+			file: `sensors:
+  - connector: 2
+`,
+			want: `sensors:
+  - connector: 2
+  - name: pilea
+    connector: 1
+    minMoisture: 25.00
+    maxMoisture: 6.00
+`,
+		},
+		{
+			name: "null sensors",
+			file: `frequency: 1m
+sensors:
+`,
+			want: `frequency: 1m
+sensors:
+  - name: pilea
+    connector: 1
+    minMoisture: 25.00
+    maxMoisture: 6.00
+`,
+		},
+		{
+			name: "null bounds",
+			file: `sensors:
+  - name: pilea
+    connector: 1
+    minMoisture:
+`,
+			want: `sensors:
+  - name: pilea
+    connector: 1
+    minMoisture: 25.00
+    maxMoisture: 6.00
+`,
+		},
+		{
+			name: "json",
+			ext:  ".json",
+			file: `{"frequency": "1m", "sensors": [{"name": "pilea", "connector": 1}]}`,
+			want: `{
+  "frequency": "1m",
+  "sensors": [
+    {
+      "connector": 1,
+      "maxMoisture": 6,
+      "minMoisture": 25,
+      "name": "pilea"
+    }
+  ]
+}`,
+		},
+		{
+			name:    "sensors not a list",
+			file:    "sensors: pilea\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ext := tt.ext
+			if ext == "" {
+				ext = ".yaml"
+			}
+			path := filepath.Join(t.TempDir(), "config"+ext)
+			if tt.file != "" {
+				err := os.WriteFile(path, []byte(tt.file), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := SaveCalibration(path, "pilea", 1, 25, 6)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveCalibration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("config =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
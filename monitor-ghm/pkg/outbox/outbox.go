@@ -0,0 +1,295 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	DropOldest = "drop-oldest" // Evicts the oldest message when full
+	DropNewest = "drop-newest" // Discards new messages when full
+)
+
+var (
+	depthGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grow_monitor_outbox_depth",
+		Help: "Number of messages waiting in the outbox to be published.",
+	}, []string{"path"})
+	evictedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grow_monitor_outbox_evicted_total",
+		Help: "Number of messages evicted from the outbox because it was full.",
+	}, []string{"path"})
+)
+
+var errClosed = errors.New("outbox closed")
+
+// Message is a message that could not be published.
+type Message struct {
+	Subject string            `json:"subject"`
+	Header  map[string]string `json:"header,omitempty"`
+	Data    []byte            `json:"data"`
+}
+
+// Outbox is a bounded queue of messages persisted in an append-only file,
+// one JSON message per line, so they survive restarts.
+//
+// Messages evicted with DropOldest stay at the start of the file, which is
+// compacted once they are as many as maxSize, instead of rewriting the whole
+// file on every push. They are left out when loading, as only the last
+// maxSize lines of a full outbox are kept.
+type Outbox struct {
+	mu        sync.Mutex
+	path      string
+	maxSize   int
+	eviction  string
+	messages  []Message
+	file      *os.File
+	depth     prometheus.Gauge
+	evicted   prometheus.Counter
+	stale     int  // evicted messages still in the file
+	removed   int  // messages removed from the front, by replays or evictions
+	replaying bool // a replay is sending messages
+	closed    bool
+}
+
+func Open(path string, maxSize int, eviction string) (*Outbox, error) {
+	if maxSize < 1 {
+		return nil, fmt.Errorf("invalid outbox size: %d", maxSize)
+	}
+	if eviction != DropOldest && eviction != DropNewest {
+		return nil, fmt.Errorf("invalid outbox eviction policy: %s", eviction)
+	}
+
+	o := &Outbox{
+		path:     path,
+		maxSize:  maxSize,
+		eviction: eviction,
+		depth:    depthGauge.WithLabelValues(path),
+		evicted:  evictedCounter.WithLabelValues(path),
+	}
+
+	err := o.load()
+	if err != nil {
+		return nil, err
+	}
+	err = o.rewrite()
+	if err != nil {
+		return nil, err
+	}
+
+	o.depth.Set(float64(len(o.messages)))
+	if len(o.messages) > 0 {
+		slog.Info("outbox loaded", "path", path, "depth", len(o.messages))
+	}
+	return o, nil
+}
+
+// Push appends a message, evicting one according to the policy when the
+// outbox is full.
+func (o *Outbox) Push(m Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return errClosed
+	}
+
+	full := len(o.messages) >= o.maxSize
+	if full && o.eviction == DropNewest {
+		o.evicted.Inc()
+		slog.Warn("outbox full, dropping message", "depth", len(o.messages), "subject", m.Subject)
+		return nil
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("could not marshal outbox message: %w", err)
+	}
+	_, err = o.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write to outbox %s: %w", o.path, err)
+	}
+	o.messages = append(o.messages, m)
+
+	if full {
+		o.evicted.Inc()
+		slog.Warn("outbox full, dropping oldest message", "depth", o.maxSize)
+		o.messages = o.messages[1:]
+		o.removed++
+		o.stale++
+		o.depth.Set(float64(len(o.messages)))
+		if o.stale >= o.maxSize {
+			return o.rewrite()
+		}
+		return nil
+	}
+	o.depth.Set(float64(len(o.messages)))
+	slog.Info("message saved to outbox", "depth", len(o.messages))
+	return nil
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.messages)
+}
+
+// Replay sends the messages in order, removing the ones sent. It stops on
+// the first error, keeping the remaining messages for the next replay. The
+// messages are sent without holding the lock, so pushes aren't blocked by a
+// slow connection, and a replay started while another is running returns
+// right away.
+func (o *Outbox) Replay(send func(Message) error) (int, error) {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return 0, errClosed
+	}
+	if o.replaying {
+		o.mu.Unlock()
+		return 0, nil
+	}
+	o.replaying = true
+	batch := slices.Clone(o.messages)
+	removed := o.removed
+	o.mu.Unlock()
+
+	sent := 0
+	var sendErr error
+	for _, m := range batch {
+		sendErr = send(m)
+		if sendErr != nil {
+			break
+		}
+		sent++
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replaying = false
+	if sent == 0 || o.closed {
+		return sent, sendErr
+	}
+
+	// the messages evicted while sending were removed already
+	trim := sent - (o.removed - removed)
+	if trim > 0 {
+		o.messages = o.messages[trim:]
+		o.removed += trim
+	}
+	err := o.rewrite()
+	if err != nil {
+		return sent, err
+	}
+	slog.Info("outbox replayed", "sent", sent, "depth", len(o.messages))
+	return sent, sendErr
+}
+
+// Close compacts the file and closes it, the following pushes fail.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	if o.stale > 0 {
+		err := o.rewrite()
+		if err != nil {
+			return err
+		}
+	}
+	return o.file.Close()
+}
+
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open outbox %s: %w", o.path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := Message{}
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			// a line can be incomplete if the monitor stopped while writing
+			slog.Warn("ignoring invalid outbox message", "path", o.path, "error", err)
+			continue
+		}
+		o.messages = append(o.messages, m)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("could not read outbox %s: %w", o.path, err)
+	}
+
+	if len(o.messages) > o.maxSize {
+		evicted := len(o.messages) - o.maxSize
+		o.evicted.Add(float64(evicted))
+		slog.Warn("outbox bigger than its size, evicting messages", "evicted", evicted)
+		if o.eviction == DropNewest {
+			o.messages = o.messages[:o.maxSize]
+		} else {
+			o.messages = o.messages[evicted:]
+		}
+	}
+	return nil
+}
+
+// rewrite replaces the file with the messages in memory and reopens it for
+// appending.
+func (o *Outbox) rewrite() error {
+	if o.file != nil {
+		o.file.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not create outbox %s: %w", o.path, err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, m := range o.messages {
+		err = enc.Encode(m)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("could not write outbox %s: %w", o.path, err)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not write outbox %s: %w", o.path, err)
+	}
+
+	o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open outbox %s: %w", o.path, err)
+	}
+	o.stale = 0
+	o.depth.Set(float64(len(o.messages)))
+	return nil
+}
@@ -0,0 +1,240 @@
+package outbox
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func message(subject string) Message {
+	return Message{Subject: subject, Data: []byte(`{}`)}
+}
+
+func subjects(o *Outbox) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := []string{}
+	for _, m := range o.messages {
+		s = append(s, m.Subject)
+	}
+	return s
+}
+
+func lines(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func open(t *testing.T, path string, maxSize int, eviction string) *Outbox {
+	o, err := Open(path, maxSize, eviction)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return o
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  int
+		eviction string
+		pushed   []string
+		want     []string
+		lines    int // in the file before reopening
+	}{
+		{
+			name:     "not full",
+			maxSize:  3,
+			eviction: DropOldest,
+			pushed:   []string{"a", "b"},
+			want:     []string{"a", "b"},
+			lines:    2,
+		},
+		{
+			name:     "drop newest",
+			maxSize:  3,
+			eviction: DropNewest,
+			pushed:   []string{"a", "b", "c", "d", "e"},
+			want:     []string{"a", "b", "c"},
+			lines:    3,
+		},
+		{
+			name:     "drop oldest appends",
+			maxSize:  3,
+			eviction: DropOldest,
+			pushed:   []string{"a", "b", "c", "d", "e"},
+			want:     []string{"c", "d", "e"},
+			lines:    5,
+		},
+		{
+			name:     "drop oldest compacts",
+			maxSize:  3,
+			eviction: DropOldest,
+			pushed:   []string{"a", "b", "c", "d", "e", "f", "g"},
+			want:     []string{"e", "f", "g"},
+			lines:    4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "outbox.jsonl")
+			o := open(t, path, tt.maxSize, tt.eviction)
+			for _, s := range tt.pushed {
+				err := o.Push(message(s))
+				if err != nil {
+					t.Fatalf("Push() error = %v", err)
+				}
+			}
+			if got := subjects(o); !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if got := lines(t, path); got != tt.lines {
+				t.Errorf("file lines = %d, want %d", got, tt.lines)
+			}
+			if got := testutil.ToFloat64(o.depth); got != float64(len(tt.want)) {
+				t.Errorf("depth gauge = %g, want %d", got, len(tt.want))
+			}
+			if got, want := testutil.ToFloat64(o.evicted), float64(len(tt.pushed)-len(tt.want)); got != want {
+				t.Errorf("evicted counter = %g, want %g", got, want)
+			}
+
+			// reopens without closing, like after a crash
+			reopened := open(t, path, tt.maxSize, tt.eviction)
+			defer reopened.Close()
+			if got := subjects(reopened); !slices.Equal(got, tt.want) {
+				t.Errorf("reopened messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		pushed  []string
+		failAt  string
+		pushing []string // while replaying
+		sent    []string
+		want    []string
+	}{
+		{
+			name:   "all sent",
+			pushed: []string{"a", "b", "c"},
+			sent:   []string{"a", "b", "c"},
+			want:   []string{},
+		},
+		{
+			name:   "stops on the first error",
+			pushed: []string{"a", "b", "c"},
+			failAt: "b",
+			sent:   []string{"a"},
+			want:   []string{"b", "c"},
+		},
+		{
+			name:    "pushes while replaying",
+			pushed:  []string{"a", "b"},
+			pushing: []string{"c"},
+			sent:    []string{"a", "b"},
+			want:    []string{"c"},
+		},
+		{
+			name:    "evictions while replaying",
+			pushed:  []string{"a", "b", "c"},
+			pushing: []string{"d", "e"},
+			sent:    []string{"a", "b", "c"},
+			want:    []string{"d", "e"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "outbox.jsonl")
+			o := open(t, path, 3, DropOldest)
+			defer o.Close()
+			for _, s := range tt.pushed {
+				o.Push(message(s))
+			}
+
+			pushing := tt.pushing
+			sent := []string{}
+			n, err := o.Replay(func(m Message) error {
+				if m.Subject == tt.failAt {
+					return errors.New("no responders")
+				}
+				sent = append(sent, m.Subject)
+				// the lock isn't held while sending
+				for _, s := range pushing {
+					err := o.Push(message(s))
+					if err != nil {
+						t.Errorf("Push() error = %v", err)
+					}
+				}
+				pushing = nil
+				return nil
+			})
+			if (err != nil) != (tt.failAt != "") {
+				t.Errorf("Replay() error = %v", err)
+			}
+			if n != len(tt.sent) || !slices.Equal(sent, tt.sent) {
+				t.Errorf("sent %d %q, want %q", n, sent, tt.sent)
+			}
+			if got := subjects(o); !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if got := lines(t, path); got != len(tt.want) {
+				t.Errorf("file lines = %d, want %d", got, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestReplayRunning(t *testing.T) {
+	o := open(t, filepath.Join(t.TempDir(), "outbox.jsonl"), 3, DropOldest)
+	defer o.Close()
+	o.Push(message("a"))
+
+	n, err := o.Replay(func(Message) error {
+		n, err := o.Replay(func(Message) error {
+			t.Error("message sent by a second replay")
+			return nil
+		})
+		if n != 0 || err != nil {
+			t.Errorf("second Replay() = %d, %v", n, err)
+		}
+		return nil
+	})
+	if n != 1 || err != nil {
+		t.Errorf("Replay() = %d, %v", n, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o := open(t, path, 2, DropOldest)
+	for _, s := range []string{"a", "b", "c"} {
+		o.Push(message(s))
+	}
+	err := o.Close()
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := lines(t, path); got != 2 {
+		t.Errorf("file lines after Close() = %d, want 2", got)
+	}
+	if err := o.Push(message("d")); !errors.Is(err, errClosed) {
+		t.Errorf("Push() after Close() error = %v, want %v", err, errClosed)
+	}
+	if _, err := o.Replay(func(Message) error { return nil }); !errors.Is(err, errClosed) {
+		t.Errorf("Replay() after Close() error = %v, want %v", err, errClosed)
+	}
+	if err := o.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+}
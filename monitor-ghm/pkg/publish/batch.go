@@ -0,0 +1,146 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxQueuedBatches is how many batches of readings a batcher keeps while they
+// can't be sent, the oldest readings beyond them are dropped.
+const maxQueuedBatches = 10
+
+var batchDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grow_monitor_batch_dropped_total",
+	Help: "Number of readings dropped by the batching publishers because they could not be sent.",
+}, []string{"publisher"})
+
+// batcher groups readings and sends them together when there are size of
+// them, or every interval when it isn't zero. Batches that can't be sent are
+// queued again and sent with the next ones. Batches are sent one at a time,
+// so they arrive in order.
+type batcher struct {
+	mu        sync.Mutex
+	flushMu   sync.Mutex // held while sending a batch
+	name      string
+	readings  []Reading
+	size      int
+	limit     int // of queued readings
+	send      func(context.Context, []Reading) error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBatcher(name string, size int, interval time.Duration, send func(context.Context, []Reading) error) *batcher {
+	b := &batcher{
+		name:  name,
+		size:  max(size, 1),
+		limit: max(size, 1) * maxQueuedBatches,
+		send:  send,
+		done:  make(chan struct{}),
+	}
+	if interval > 0 {
+		go b.tick(interval)
+	}
+	return b
+}
+
+// tick sends the incomplete batches every interval, until the batcher is
+// closed.
+func (b *batcher) tick(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+		err := b.flush(context.Background())
+		if err != nil {
+			slog.Error("could not publish batch", "publisher", b.name, "error", err)
+		}
+	}
+}
+
+// Add queues a reading, sending the batch when it is full. Only the errors
+// of that send are returned, the ones of the periodic sends are logged. The
+// reading stays queued when the send fails.
+func (b *batcher) Add(ctx context.Context, r Reading) error {
+	b.mu.Lock()
+	b.readings = append(b.readings, r)
+	full := len(b.readings) >= b.size
+	b.mu.Unlock()
+
+	if !full {
+		return nil
+	}
+	return b.flush(ctx)
+}
+
+// Close stops the periodic sends and sends the queued readings, the ones
+// that can't be sent are dropped. It can be called more than once.
+func (b *batcher) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.done) })
+	err := b.flush(ctx)
+	if err != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.dropped(len(b.readings), err)
+		b.readings = nil
+	}
+	return err
+}
+
+// flush sends the queued readings, after the batch being sent, if any. A
+// batch that can't be sent is queued again, before the readings added
+// meanwhile.
+func (b *batcher) flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	readings := b.readings
+	b.readings = nil
+	b.mu.Unlock()
+
+	if len(readings) == 0 {
+		return nil
+	}
+	err := b.send(ctx, readings)
+	if err != nil {
+		b.requeue(readings, err)
+	}
+	return err
+}
+
+// requeue puts back the readings of a batch that failed. Batches failing with
+// a permanent error aren't retried, and the oldest readings beyond the limit
+// are dropped.
+func (b *batcher) requeue(readings []Reading, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.As(err, new(permanentError)) {
+		b.dropped(len(readings), err)
+		return
+	}
+	b.readings = append(readings, b.readings...)
+	if excess := len(b.readings) - b.limit; excess > 0 {
+		b.readings = b.readings[excess:]
+		b.dropped(excess, err)
+	}
+}
+
+// dropped counts and logs n readings dropped.
+func (b *batcher) dropped(n int, err error) {
+	if n == 0 {
+		return
+	}
+	batchDropped.WithLabelValues(b.name).Add(float64(n))
+	slog.Error("dropping readings that could not be published", "publisher", b.name, "readings", n, "error", err)
+}
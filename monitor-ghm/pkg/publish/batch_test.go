@@ -0,0 +1,226 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender records the batches sent, failing with the errors queued.
+type fakeSender struct {
+	mu      sync.Mutex
+	errs    []error
+	batches [][]string
+}
+
+func (s *fakeSender) send(_ context.Context, readings []Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	names := []string{}
+	for _, r := range readings {
+		names = append(names, r.Name)
+	}
+	s.batches = append(s.batches, names)
+	return nil
+}
+
+func (s *fakeSender) sent() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.batches)
+}
+
+func queued(b *batcher) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := []string{}
+	for _, r := range b.readings {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestBatcher(t *testing.T) {
+	unavailable := errors.New("503 service unavailable")
+	tests := []struct {
+		name   string
+		size   int
+		errs   []error // of each send
+		added  []string
+		failed []string // readings whose Add failed
+		sent   [][]string
+		queued []string
+	}{
+		{
+			name:  "full batches",
+			size:  2,
+			added: []string{"a", "b", "c", "d", "e"},
+			sent:  [][]string{{"a", "b"}, {"c", "d"}},
+			// sent on the next tick or on Close
+			queued: []string{"e"},
+		},
+		{
+			name:   "failed batch sent with the next reading",
+			size:   1,
+			errs:   []error{unavailable},
+			added:  []string{"a", "b"},
+			failed: []string{"a"},
+			sent:   [][]string{{"a", "b"}},
+			queued: []string{},
+		},
+		{
+			name:   "failed batch kept before the next ones",
+			size:   2,
+			errs:   []error{unavailable, unavailable},
+			added:  []string{"a", "b", "c", "d", "e", "f"},
+			failed: []string{"b", "c"},
+			sent:   [][]string{{"a", "b", "c", "d"}, {"e", "f"}},
+			queued: []string{},
+		},
+		{
+			name:   "oldest readings dropped beyond the limit",
+			size:   1,
+			errs:   []error{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable, unavailable},
+			added:  []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			failed: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			queued: []string{"c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+		},
+		{
+			name:   "permanent errors dropped",
+			size:   1,
+			errs:   []error{permanentError{errors.New("400 bad request")}},
+			added:  []string{"a", "b"},
+			failed: []string{"a"},
+			sent:   [][]string{{"b"}},
+			queued: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeSender{errs: tt.errs}
+			b := newBatcher("test", tt.size, 0, s.send)
+
+			failed := []string{}
+			for _, name := range tt.added {
+				err := b.Add(context.Background(), Reading{Name: name})
+				if err != nil {
+					failed = append(failed, name)
+				}
+			}
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("failed adds = %q, want %q", failed, tt.failed)
+			}
+			if got := s.sent(); !slices.EqualFunc(got, tt.sent, slices.Equal) {
+				t.Errorf("sent = %q, want %q", got, tt.sent)
+			}
+			if got := queued(b); !slices.Equal(got, tt.queued) {
+				t.Errorf("queued = %q, want %q", got, tt.queued)
+			}
+		})
+	}
+}
+
+func TestBatcherTick(t *testing.T) {
+	s := &fakeSender{errs: []error{errors.New("503 service unavailable")}}
+	b := newBatcher("test", 10, 10*time.Millisecond, s.send)
+	defer b.Close(context.Background())
+
+	b.Add(context.Background(), Reading{Name: "a"})
+	b.Add(context.Background(), Reading{Name: "b"})
+
+	// the failed tick is sent again on the next one
+	deadline := time.Now().Add(time.Second)
+	for len(s.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := [][]string{{"a", "b"}}
+	if got := s.sent(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("sent = %q, want %q", got, want)
+	}
+}
+
+func TestBatcherClose(t *testing.T) {
+	s := &fakeSender{errs: []error{errors.New("503 service unavailable")}}
+	b := newBatcher("test", 10, 0, s.send)
+	b.Add(context.Background(), Reading{Name: "a"})
+
+	err := b.Close(context.Background())
+	if err == nil {
+		t.Error("Close() didn't fail")
+	}
+	if got := queued(b); len(got) != 0 {
+		t.Errorf("queued after Close() = %q, want none", got)
+	}
+}
+
+func TestBatcherCloseTwice(t *testing.T) {
+	s := &fakeSender{}
+	b := newBatcher("test", 10, time.Hour, s.send)
+	b.Add(context.Background(), Reading{Name: "a"})
+
+	// closed again when the publishers are restarted
+	for i := 0; i < 2; i++ {
+		err := b.Close(context.Background())
+		if err != nil {
+			t.Errorf("Close() #%d error = %v", i+1, err)
+		}
+	}
+	want := [][]string{{"a"}}
+	if got := s.sent(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("sent = %q, want %q", got, want)
+	}
+}
+
+func TestBatcherFlushInOrder(t *testing.T) {
+	s := &fakeSender{errs: []error{errors.New("503 service unavailable")}}
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	send := func(ctx context.Context, readings []Reading) error {
+		// the first send, of the tick, is slow and fails
+		if first {
+			first = false
+			close(sending)
+			<-release
+		}
+		return s.send(ctx, readings)
+	}
+	b := newBatcher("test", 2, 0, send)
+	b.Add(context.Background(), Reading{Name: "a"})
+
+	ticked := make(chan error)
+	go func() { ticked <- b.flush(context.Background()) }()
+	<-sending
+
+	// fills the next batch while the tick is sending
+	added := make(chan error)
+	b.Add(context.Background(), Reading{Name: "b"})
+	go func() { added <- b.Add(context.Background(), Reading{Name: "c"}) }()
+	select {
+	case <-added:
+		t.Fatalf("batch sent while the tick was sending")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-ticked; err == nil {
+		t.Errorf("tick didn't fail")
+	}
+	if err := <-added; err != nil {
+		t.Errorf("Add() error = %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}}
+	if got := s.sent(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("sent = %q, want %q", got, want)
+	}
+}
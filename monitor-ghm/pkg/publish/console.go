@@ -1,13 +1,22 @@
 package publish
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 )
 
-func NewConsolePublisher() func(Reading) error {
-	return func(r Reading) error {
-		slog.Info("reading", "plant", r.Name, "value", fmt.Sprintf("%.15f", r.Value))
+func NewConsolePublisher() Publisher {
+	return func(_ context.Context, r Reading) error {
+		if !r.Moisture() {
+			slog.Info("reading", "sensor", r.Name, "metric", r.Metric, "value", fmt.Sprintf("%.3f", r.Value), "unit", r.Unit, "raw", fmt.Sprintf("%.3f", r.Raw), "health", r.Health)
+			return nil
+		}
+		if r.Analog() {
+			slog.Info("reading", "plant", r.Name, "value", fmt.Sprintf("%.15f", r.Value), "raw", fmt.Sprintf("%.15f", r.Raw), "voltage", fmt.Sprintf("%.4f", r.Voltage), "health", r.Health)
+			return nil
+		}
+		slog.Info("reading", "plant", r.Name, "value", fmt.Sprintf("%.15f", r.Value), "raw", fmt.Sprintf("%.15f", r.Raw), "frequency", fmt.Sprintf("%.3f", r.Frequency), "health", r.Health)
 		return nil
 	}
 }
@@ -0,0 +1,30 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grow/monitor-ghm/pkg/natsclient"
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/telemetry"
+	"github.com/nats-io/nats.go"
+)
+
+// NewHeartbeatPublisher publishes heartbeats to NATS, out of the readings
+// stream. The subject is a template where {device} is replaced by the device
+// ID.
+func NewHeartbeatPublisher(nc *nats.Conn, subject string, device options.Device) func(telemetry.Heartbeat) error {
+	subject = strings.ReplaceAll(subject, "{device}", natsclient.SubjectToken(device.ID))
+	return func(hb telemetry.Heartbeat) error {
+		data, err := json.Marshal(hb)
+		if err != nil {
+			return fmt.Errorf("could not marshal heartbeat: %w", err)
+		}
+		err = nc.Publish(subject, data)
+		if err != nil {
+			return fmt.Errorf("could not publish heartbeat to %s: %w", subject, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,168 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+const (
+	SignatureHeader = "X-Signature-256"
+
+	httpTimeout = 10 * time.Second
+)
+
+// retryBackoff is the wait before the first retry, doubled on each retry.
+var retryBackoff = time.Second
+
+// DefaultHTTPTemplate sends the device ID and the readings in the format
+// used for NATS and MQTT.
+const DefaultHTTPTemplate = `{"device": {{json .Device}}, "readings": {{json .Readings}}}`
+
+type HTTPPublisher struct {
+	client   *http.Client
+	config   options.HTTPConfig
+	device   options.Device
+	template *template.Template
+}
+
+// httpTemplateData is the data available to the body template.
+type httpTemplateData struct {
+	Device   string
+	Location string
+	Labels   map[string]string
+	Readings []map[string]any
+}
+
+// NewHTTPPublisher sends the readings to an HTTP endpoint, with the body
+// rendered from a template. Readings are sent in batches of
+// config.BatchSize, or every config.BatchInterval when it isn't zero.
+func NewHTTPPublisher(config options.HTTPConfig, device options.Device) (Publisher, Closer, error) {
+	if config.URL == "" {
+		return nil, nil, fmt.Errorf("missing HTTP publisher URL")
+	}
+	text := config.Template
+	if text == "" {
+		text = DefaultHTTPTemplate
+	}
+	tmpl, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HTTP body template: %w", err)
+	}
+
+	hp := &HTTPPublisher{
+		client:   &http.Client{Timeout: httpTimeout},
+		config:   config,
+		device:   device,
+		template: tmpl,
+	}
+	b := newBatcher(options.HTTP, config.BatchSize, config.BatchInterval, hp.send)
+	return b.Add, b.Close, nil
+}
+
+func (hp *HTTPPublisher) send(ctx context.Context, readings []Reading) error {
+	data := httpTemplateData{
+		Device:   hp.device.ID,
+		Location: hp.device.Location,
+		Labels:   hp.device.Labels,
+	}
+	for _, r := range readings {
+		data.Readings = append(data.Readings, readingData(r, hp.device))
+	}
+	body := &bytes.Buffer{}
+	err := hp.template.Execute(body, data)
+	if err != nil {
+		return fmt.Errorf("could not render HTTP body: %w", err)
+	}
+
+	slog.Info("publishing to HTTP", "url", hp.config.URL, "readings", len(readings))
+	return sendWithRetry(ctx, hp.client, hp.config.Retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, hp.config.Method, hp.config.URL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range hp.config.Headers {
+			req.Header.Set(name, value)
+		}
+		if hp.config.HMACSecret != "" {
+			req.Header.Set(SignatureHeader, "sha256="+Sign(body.Bytes(), hp.config.HMACSecret))
+		}
+		return req, nil
+	})
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWithRetry sends the request built by newRequest, retrying with an
+// exponential backoff on connection errors and 5xx responses, until ctx is
+// done.
+func sendWithRetry(ctx context.Context, client *http.Client, retries int, newRequest func() (*http.Request, error)) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return fmt.Errorf("could not create HTTP request: %w", err)
+		}
+
+		err = doRequest(client, req)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= retries {
+			return err
+		}
+		slog.Warn("HTTP request failed, retrying", "url", req.URL.Redacted(), "error", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is a failed request that is not worth retrying.
+type permanentError struct {
+	error
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send HTTP request to %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("HTTP request to %s failed with %s: %s", req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode < 500 {
+		return permanentError{err}
+	}
+	return err
+}
+
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,132 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+type recordedRequest struct {
+	url    *url.URL
+	header http.Header
+	body   []byte
+}
+
+// recordingServer answers with the given status codes in order, then 200.
+func recordingServer(t *testing.T, statuses ...int) (*httptest.Server, func() []recordedRequest) {
+	var mu sync.Mutex
+	requests := []recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, recordedRequest{url: r.URL, header: r.Header, body: body})
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestHTTPPublisherBatch(t *testing.T) {
+	server, requests := recordingServer(t)
+	publish, closePublisher, err := NewHTTPPublisher(options.HTTPConfig{
+		URL:        server.URL,
+		Method:     http.MethodPost,
+		Headers:    map[string]string{"Authorization": "Bearer token"},
+		HMACSecret: "secret",
+		BatchSize:  2,
+	}, options.Device{ID: "pi1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"espadas", "pilea", "abacateiro"} {
+		err := publish(context.Background(), Reading{Timestamp: time.Now(), Name: name, Value: 50, Health: "ok"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	var body struct {
+		Device   string
+		Readings []map[string]string
+	}
+	err = json.Unmarshal(got[0].body, &body)
+	if err != nil {
+		t.Fatalf("invalid body %s: %s", got[0].body, err)
+	}
+	if body.Device != "pi1" || len(body.Readings) != 2 || body.Readings[1]["name"] != "pilea" {
+		t.Errorf("unexpected body %s", got[0].body)
+	}
+	if h := got[0].header.Get("Authorization"); h != "Bearer token" {
+		t.Errorf("Authorization = %q, want %q", h, "Bearer token")
+	}
+	if h, want := got[0].header.Get(SignatureHeader), "sha256="+Sign(got[0].body, "secret"); h != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, h, want)
+	}
+
+	// closing sends the incomplete batch
+	err = closePublisher(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requests(); len(got) != 2 {
+		t.Errorf("got %d requests after closing, want 2", len(got))
+	}
+}
+
+func TestHTTPPublisherRetry(t *testing.T) {
+	retryBackoff = time.Millisecond
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		fails    bool
+	}{
+		{name: "server error retried", statuses: []int{503, 500}, retries: 3, requests: 3},
+		{name: "retries exhausted", statuses: []int{500, 500, 500}, retries: 2, requests: 3, fails: true},
+		{name: "client error not retried", statuses: []int{400}, retries: 3, requests: 1, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := recordingServer(t, tt.statuses...)
+			publish, _, err := NewHTTPPublisher(options.HTTPConfig{
+				URL:     server.URL,
+				Method:  http.MethodPost,
+				Retries: tt.retries,
+			}, options.Device{ID: "pi1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = publish(context.Background(), Reading{Timestamp: time.Now(), Name: "pilea", Value: 50, Health: "ok"})
+			if (err != nil) != tt.fails {
+				t.Errorf("publish() error = %v, want failure %t", err, tt.fails)
+			}
+			if got := len(requests()); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
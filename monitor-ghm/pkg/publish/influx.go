@@ -0,0 +1,160 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+// influxTags are the tags set by the publisher, labels with the same name
+// are left out
+var influxTags = []string{"connector", "device", "health", "location", "sensor"}
+
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+type influxField struct {
+	name  string
+	value float64
+}
+
+type InfluxPublisher struct {
+	client    *http.Client
+	writeURL  string
+	token     string
+	precision time.Duration
+	device    options.Device
+	retries   int
+}
+
+// NewInfluxPublisher writes the readings to the InfluxDB v2 write API in
+// line protocol, in batches of config.BatchSize, or every
+// config.BatchInterval when it isn't zero.
+func NewInfluxPublisher(config options.InfluxConfig, device options.Device) (Publisher, Closer, error) {
+	precision, found := influxPrecisions[config.Precision]
+	if !found {
+		return nil, nil, fmt.Errorf("invalid InfluxDB precision: %s", config.Precision)
+	}
+	if config.Bucket == "" {
+		return nil, nil, fmt.Errorf("missing InfluxDB bucket")
+	}
+	writeURL, err := url.JoinPath(config.URL, "/api/v2/write")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid InfluxDB URL %s: %w", config.URL, err)
+	}
+	query := url.Values{}
+	query.Set("org", config.Org)
+	query.Set("bucket", config.Bucket)
+	query.Set("precision", config.Precision)
+
+	ip := &InfluxPublisher{
+		client:    &http.Client{Timeout: httpTimeout},
+		writeURL:  writeURL + "?" + query.Encode(),
+		token:     config.Token,
+		precision: precision,
+		device:    device,
+		retries:   config.Retries,
+	}
+	b := newBatcher(options.Influx, config.BatchSize, config.BatchInterval, ip.send)
+	return b.Add, b.Close, nil
+}
+
+func (ip *InfluxPublisher) send(ctx context.Context, readings []Reading) error {
+	body := &bytes.Buffer{}
+	for _, r := range readings {
+		line := ip.line(r)
+		if line == "" {
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	return sendWithRetry(ctx, ip.client, ip.retries, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ip.writeURL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if ip.token != "" {
+			req.Header.Set("Authorization", "Token "+ip.token)
+		}
+		return req, nil
+	})
+}
+
+// line encodes the reading in line protocol, in a measurement named after
+// its metric. NaN values, of unhealthy sensors, can't be written and are
+// left out, readings without values are empty.
+func (ip *InfluxPublisher) line(r Reading) string {
+	tags := []string{
+		"device=" + escapeTag(ip.device.ID),
+		"health=" + escapeTag(r.Health),
+		"sensor=" + escapeTag(r.Name),
+	}
+	if r.Moisture() && !r.Analog() {
+		tags = append(tags, "connector="+strconv.Itoa(r.Connector))
+	}
+	if ip.device.Location != "" {
+		tags = append(tags, "location="+escapeTag(ip.device.Location))
+	}
+	for name, value := range ip.device.Labels {
+		if slices.Contains(influxTags, name) {
+			continue
+		}
+		tags = append(tags, escapeTag(name)+"="+escapeTag(value))
+	}
+	// tags sorted by key are faster to write
+	slices.Sort(tags)
+
+	values := []influxField{{"value", r.Value}, {"raw", r.Raw}}
+	if r.Analog() {
+		values = append(values,
+			influxField{"voltage", r.Voltage},
+			influxField{"dry_voltage", r.DryVoltage},
+			influxField{"wet_voltage", r.WetVoltage})
+	} else if r.Moisture() {
+		values = append(values,
+			influxField{"frequency", r.Frequency},
+			influxField{"min_frequency", r.MinFrequency},
+			influxField{"max_frequency", r.MaxFrequency})
+	}
+	fields := []string{}
+	for _, f := range values {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			continue
+		}
+		fields = append(fields, f.name+"="+strconv.FormatFloat(f.value, 'f', -1, 64))
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	timestamp := r.Timestamp.UnixNano() / int64(ip.precision)
+	return fmt.Sprintf("%s,%s %s %d", r.MetricName(), strings.Join(tags, ","), strings.Join(fields, ","), timestamp)
+}
+
+// escapeTag escapes the characters with a special meaning in tag keys and
+// values. Empty values aren't allowed, so they are replaced by "unknown".
+func escapeTag(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
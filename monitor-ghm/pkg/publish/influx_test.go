@@ -0,0 +1,115 @@
+package publish
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+func TestInfluxPublisherLine(t *testing.T) {
+	timestamp := time.Unix(1700000000, 500000000)
+	tests := []struct {
+		name      string
+		precision string
+		device    options.Device
+		reading   Reading
+		want      string
+	}{
+		{
+			name:      "healthy",
+			precision: "s",
+			reading:   Reading{Timestamp: timestamp, Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 25, Frequency: 17.5, MinFrequency: 6.5, MaxFrequency: 25.5},
+			want:      "soil_moisture,connector=25,device=pi1,health=ok,sensor=pilea value=42.5,raw=40,frequency=17.5,min_frequency=6.5,max_frequency=25.5 1700000000",
+		},
+		{
+			name:      "unhealthy without moisture",
+			precision: "ms",
+			reading:   Reading{Timestamp: timestamp, Name: "pilea", Value: math.NaN(), Raw: math.NaN(), Health: "disconnected", Connector: 25, MinFrequency: 6.5, MaxFrequency: 25.5},
+			want:      "soil_moisture,connector=25,device=pi1,health=disconnected,sensor=pilea frequency=0,min_frequency=6.5,max_frequency=25.5 1700000000500",
+		},
+		{
+			name:      "device metadata",
+			precision: "s",
+			device:    options.Device{ID: "pi2", Location: "living room", Labels: map[string]string{"floor": "1", "sensor": "ignored"}},
+			reading:   Reading{Timestamp: timestamp, Name: "pilea", Value: 1, Raw: 1, Health: "ok", Frequency: 1, MinFrequency: 1, MaxFrequency: 2},
+			want:      `soil_moisture,connector=0,device=pi2,floor=1,health=ok,location=living\ room,sensor=pilea value=1,raw=1,frequency=1,min_frequency=1,max_frequency=2 1700000000`,
+		},
+		{
+			name:      "escaped tags",
+			precision: "s",
+			reading:   Reading{Timestamp: timestamp, Name: "big pilea,left=1", Value: 1, Raw: 1, Health: "ok", Frequency: 1, MinFrequency: 1, MaxFrequency: 2},
+			want:      `soil_moisture,connector=0,device=pi1,health=ok,sensor=big\ pilea\,left\=1 value=1,raw=1,frequency=1,min_frequency=1,max_frequency=2 1700000000`,
+		},
+		{
+			name:      "temperature",
+			precision: "s",
+			reading:   Reading{Timestamp: timestamp, Name: "kitchen", Metric: "temperature", Unit: "celsius", Value: 21.5, Raw: 21.5, Health: "ok"},
+			want:      "temperature_celsius,device=pi1,health=ok,sensor=kitchen value=21.5,raw=21.5 1700000000",
+		},
+		{
+			name:      "analog",
+			precision: "s",
+			reading:   Reading{Timestamp: timestamp, Name: "pilea", Metric: "moisture", Unit: "percent", Value: 50, Raw: 50, Health: "ok", Voltage: 2.05, DryVoltage: 2.8, WetVoltage: 1.3},
+			want:      "soil_moisture,device=pi1,health=ok,sensor=pilea value=50,raw=50,voltage=2.05,dry_voltage=2.8,wet_voltage=1.3 1700000000",
+		},
+		{
+			name:      "unhealthy light",
+			precision: "s",
+			reading:   Reading{Timestamp: timestamp, Name: "window", Metric: "light", Unit: "lux", Value: math.NaN(), Raw: math.NaN(), Health: "disconnected"},
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := tt.device
+			if device.ID == "" {
+				device.ID = "pi1"
+			}
+			ip := &InfluxPublisher{precision: influxPrecisions[tt.precision], device: device}
+			if got := ip.line(tt.reading); got != tt.want {
+				t.Errorf("line() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxPublisherWrite(t *testing.T) {
+	server, requests := recordingServer(t)
+
+	publish, _, err := NewInfluxPublisher(options.InfluxConfig{
+		URL:       server.URL,
+		Org:       "home",
+		Bucket:    "plants",
+		Token:     "secret",
+		Precision: "s",
+		BatchSize: 2,
+	}, options.Device{ID: "pi1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"espadas", "pilea"} {
+		err := publish(context.Background(), Reading{Timestamp: time.Now(), Name: name, Value: 50, Raw: 50, Health: "ok"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	if u := got[0].url; u.Path != "/api/v2/write" || u.RawQuery != "bucket=plants&org=home&precision=s" {
+		t.Errorf("request to %s", u)
+	}
+	if h := got[0].header.Get("Authorization"); h != "Token secret" {
+		t.Errorf("Authorization = %q, want %q", h, "Token secret")
+	}
+	if lines := strings.Split(strings.TrimSpace(string(got[0].body)), "\n"); len(lines) != 2 {
+		t.Errorf("got %d lines, want 2: %s", len(lines), got[0].body)
+	}
+}
@@ -0,0 +1,56 @@
+package publish
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grow_monitor_publish_failures_total",
+		Help: "Number of readings that could not be published.",
+	}, []string{"publisher"})
+	lastPublished = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grow_monitor_last_publish_timestamp_seconds",
+		Help: "Unix time of the last reading published successfully.",
+	}, []string{"publisher"})
+)
+
+var (
+	failuresMu sync.Mutex
+	failures   = map[string]int64{}
+)
+
+// Failures returns the number of readings each publisher couldn't publish.
+func Failures() map[string]int64 {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	return maps.Clone(failures)
+}
+
+// Instrument wraps a publisher to count its failures and record the time of
+// its last successful publish.
+func Instrument(name string, p Publisher) Publisher {
+	publishFailures.WithLabelValues(name)
+	failuresMu.Lock()
+	failures[name] += 0
+	failuresMu.Unlock()
+
+	return func(ctx context.Context, r Reading) error {
+		err := p(ctx, r)
+		if err != nil {
+			publishFailures.WithLabelValues(name).Inc()
+			failuresMu.Lock()
+			failures[name]++
+			failuresMu.Unlock()
+			return err
+		}
+		lastPublished.WithLabelValues(name).Set(float64(time.Now().Unix()))
+		return nil
+	}
+}
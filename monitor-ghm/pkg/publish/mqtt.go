@@ -0,0 +1,130 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+type MQTTPublisher struct {
+	client  mqtt.Client
+	topic   string
+	device  options.Device
+	qos     byte
+	retain  bool
+	timeout time.Duration
+}
+
+// NewMQTTPublisher connects to an MQTT broker. The topic is a template where
+// {device}, {sensor} and {connector} are replaced by the reading values.
+func NewMQTTPublisher(config options.MQTTConfig, device options.Device) (Publisher, Closer, error) {
+	if config.QoS > 2 {
+		return nil, nil, fmt.Errorf("invalid MQTT QoS: %d", config.QoS)
+	}
+	broker, err := url.Parse(config.Broker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MQTT broker %s: %w", config.Broker, err)
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = "monitorghm-" + device.ID
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(clientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("disconnected from MQTT", "error", err)
+		}).
+		SetOnConnectHandler(func(_ mqtt.Client) {
+			slog.Info("connected to MQTT", "broker", config.Broker)
+		})
+
+	if broker.Scheme == "ssl" || broker.Scheme == "tls" || broker.Scheme == "mqtts" || config.CAFile != "" || config.CertFile != "" {
+		tlsConfig, err := newTLSConfig(config.CAFile, config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		return nil, nil, fmt.Errorf("timeout connecting to MQTT broker %s", config.Broker)
+	}
+	if token.Error() != nil {
+		return nil, nil, fmt.Errorf("cannot connect to MQTT broker %s: %w", config.Broker, token.Error())
+	}
+
+	mp := &MQTTPublisher{
+		client:  client,
+		topic:   config.Topic,
+		device:  device,
+		qos:     config.QoS,
+		retain:  config.Retain,
+		timeout: 30 * time.Second,
+	}
+	return mp.Publish, mp.Close, nil
+}
+
+func (mp *MQTTPublisher) Publish(ctx context.Context, r Reading) error {
+	topic := mp.topicFor(r)
+	slog.Info("publishing to MQTT", "name", r.Name, "topic", topic)
+
+	payload, err := json.Marshal(readingData(r, mp.device))
+	if err != nil {
+		return fmt.Errorf("could not marshal reading for MQTT: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mp.timeout)
+	defer cancel()
+	token := mp.client.Publish(topic, mp.qos, mp.retain, payload)
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("could not publish to MQTT topic %s: %w", topic, ctx.Err())
+	case <-token.Done():
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("could not publish to MQTT topic %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+// Close disconnects from the broker, waiting for the messages in flight
+// until ctx is done.
+func (mp *MQTTPublisher) Close(ctx context.Context) error {
+	quiesce := uint(mp.timeout.Milliseconds())
+	deadline, found := ctx.Deadline()
+	if found {
+		quiesce = uint(max(time.Until(deadline).Milliseconds(), 0))
+	}
+	mp.client.Disconnect(quiesce)
+	return nil
+}
+
+func (mp *MQTTPublisher) topicFor(r Reading) string {
+	return strings.NewReplacer(
+		"{device}", topicLevel(mp.device.ID),
+		"{sensor}", topicLevel(r.Name),
+		"{connector}", strconv.Itoa(r.Connector),
+	).Replace(mp.topic)
+}
+
+// topicLevel removes the characters with a special meaning in MQTT topics.
+func topicLevel(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
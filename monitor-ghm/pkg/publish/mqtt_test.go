@@ -0,0 +1,203 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+// fakeToken is a completed mqtt.Token.
+type fakeToken struct {
+	err error
+}
+
+func (t fakeToken) Wait() bool                     { return true }
+func (t fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t fakeToken) Error() error                   { return t.err }
+
+func (t fakeToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+type mqttMessage struct {
+	topic   string
+	qos     byte
+	retain  bool
+	payload []byte
+}
+
+// fakeMQTTClient is an mqtt.Client recording the messages published.
+type fakeMQTTClient struct {
+	mqtt.Client
+	err      error
+	messages []mqttMessage
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retain bool, payload any) mqtt.Token {
+	c.messages = append(c.messages, mqttMessage{topic: topic, qos: qos, retain: retain, payload: payload.([]byte)})
+	return fakeToken{err: c.err}
+}
+
+func TestMQTTTopic(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		device   string
+		reading  Reading
+		want     string
+	}{
+		{
+			name:     "default",
+			template: options.DefaultMQTTTopic,
+			device:   "pi-one",
+			reading:  Reading{Name: "pilea"},
+			want:     "grow/pi-one/pilea",
+		},
+		{
+			name:     "connector",
+			template: "home/{device}/{connector}/{sensor}",
+			device:   "pi-one",
+			reading:  Reading{Name: "pilea", Connector: 2},
+			want:     "home/pi-one/2/pilea",
+		},
+		{
+			name:     "no placeholders",
+			template: "grow/readings",
+			device:   "pi-one",
+			reading:  Reading{Name: "pilea"},
+			want:     "grow/readings",
+		},
+		{
+			name:     "special characters",
+			template: options.DefaultMQTTTopic,
+			device:   "pi/one",
+			reading:  Reading{Name: "pilea+#2"},
+			want:     "grow/pi_one/pilea__2",
+		},
+		{
+			name:     "NATS special characters kept",
+			template: options.DefaultMQTTTopic,
+			device:   "pi.one",
+			reading:  Reading{Name: "big pilea"},
+			want:     "grow/pi.one/big pilea",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := &MQTTPublisher{topic: tt.template, device: options.Device{ID: tt.device}}
+			if got := mp.topicFor(tt.reading); got != tt.want {
+				t.Errorf("topicFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMQTTPublisherInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  options.MQTTConfig
+		wantErr string
+	}{
+		{
+			name:    "QoS",
+			config:  options.MQTTConfig{Broker: options.DefaultMQTTBroker, QoS: 3},
+			wantErr: "invalid MQTT QoS: 3",
+		},
+		{
+			name:    "broker",
+			config:  options.MQTTConfig{Broker: "://192.168.1.2:1883"},
+			wantErr: "invalid MQTT broker",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewMQTTPublisher(tt.config, options.Device{ID: "pi-one"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewMQTTPublisher() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		qos     byte
+		retain  bool
+		device  options.Device
+		reading Reading
+		want    map[string]any
+	}{
+		{
+			name:    "moisture",
+			qos:     1,
+			device:  options.Device{ID: "pi-one", Hostname: "pi"},
+			reading: Reading{Timestamp: ts, Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5, MinFrequency: 6.5, MaxFrequency: 25.5},
+			want: map[string]any{
+				"name": "pilea", "metric": "", "unit": "", "health": "ok", "timestamp": "2024-05-01T10:00:00Z",
+				"value": "42.500000000000000", "raw": "40.000000000000000",
+				"connector": "1", "frequency": "17.500000000000000", "min_frequency": "6.500000000000000", "max_frequency": "25.500000000000000",
+				"device": "pi-one", "hostname": "pi",
+			},
+		},
+		{
+			name:    "retained light with location and labels",
+			qos:     0,
+			retain:  true,
+			device:  options.Device{ID: "pi-one", Hostname: "pi", Location: "kitchen", Labels: map[string]string{"room": "north"}},
+			reading: Reading{Timestamp: ts, Name: "window", Metric: "light", Unit: "lux", Value: 120, Raw: 120, Health: "ok"},
+			want: map[string]any{
+				"name": "window", "metric": "light", "unit": "lux", "health": "ok", "timestamp": "2024-05-01T10:00:00Z",
+				"value": "120.000000000000000", "raw": "120.000000000000000",
+				"device": "pi-one", "hostname": "pi", "location": "kitchen", "labels": map[string]any{"room": "north"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeMQTTClient{}
+			mp := &MQTTPublisher{client: client, topic: options.DefaultMQTTTopic, device: tt.device, qos: tt.qos, retain: tt.retain, timeout: time.Second}
+			err := mp.Publish(context.Background(), tt.reading)
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if len(client.messages) != 1 {
+				t.Fatalf("published %d messages, want 1", len(client.messages))
+			}
+			m := client.messages[0]
+			if m.topic != "grow/pi-one/"+tt.reading.Name || m.qos != tt.qos || m.retain != tt.retain {
+				t.Errorf("published to %s, QoS %d, retain %t, want QoS %d, retain %t", m.topic, m.qos, m.retain, tt.qos, tt.retain)
+			}
+
+			payload := map[string]any{}
+			err = json.Unmarshal(m.payload, &payload)
+			if err != nil {
+				t.Fatalf("invalid payload %s: %v", m.payload, err)
+			}
+			got, _ := json.Marshal(payload)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("payload = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMQTTPublishError(t *testing.T) {
+	client := &fakeMQTTClient{err: errors.New("not connected")}
+	mp := &MQTTPublisher{client: client, topic: options.DefaultMQTTTopic, timeout: time.Second}
+	err := mp.Publish(context.Background(), Reading{Name: "pilea"})
+	if err == nil || !strings.Contains(err.Error(), "could not publish to MQTT topic grow//pilea") {
+		t.Errorf("Publish() error = %v", err)
+	}
+}
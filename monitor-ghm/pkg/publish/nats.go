@@ -3,78 +3,243 @@ package publish
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/grow/monitor-ghm/pkg/natsclient"
 	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/outbox"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+const replayInterval = 30 * time.Second
+
+// ErrQueued is returned when a message wasn't sent to NATS but saved to the
+// outbox, to be replayed later.
+var ErrQueued = errors.New("not sent to NATS, queued in the outbox")
+
+// headers with the device identity, labels are sent as Device-Label-<name>
+const (
+	DeviceIDHeader       = "Device-Id"
+	DeviceHostnameHeader = "Device-Hostname"
+	DeviceLocationHeader = "Device-Location"
+	DeviceLabelHeader    = "Device-Label-"
+)
+
 type NATSPublisher struct {
-	nc            *nats.Conn
-	js            jetstream.JetStream
+	*jetStreamSender
 	streamSubject string
+	device        options.Device
 }
 
-func NewNATSPublisher(config options.NATSConfig) (Publisher, error) {
-	nc, err := nats.Connect(config.URL)
-	if err != nil {
-		return nil, fmt.Errorf("cannot connect to nats %s: %w", config.URL, err)
-	}
+// jetStreamSender publishes messages to JetStream. When the outbox isn't
+// nil, messages that can't be published are saved to it and replayed in
+// order once NATS is reachable again.
+type jetStreamSender struct {
+	nc        *nats.Conn
+	js        jetstream.JetStream
+	outbox    *outbox.Outbox
+	done      chan struct{}
+	closeOnce sync.Once
+}
 
+// NewNATSPublisher creates a publisher to a JetStream stream. The subject is
+// a template where {device} and {sensor} are replaced by the device ID and
+// the sensor name. When ob isn't nil, messages that can't be published are
+// saved to it and replayed in order once NATS is reachable again.
+func NewNATSPublisher(nc *nats.Conn, config options.NATSConfig, device options.Device, ob *outbox.Outbox) (Publisher, Closer, error) {
 	js, err := jetstream.New(nc)
 	if err != nil {
-		return nil, fmt.Errorf("cannot connect to jetstream: %w", err)
+		return nil, nil, fmt.Errorf("cannot connect to jetstream: %w", err)
 	}
 
-	// this is an idempotent operation
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	_, err = js.CreateStream(ctx, jetstream.StreamConfig{
-		Name:     config.StreamName,
-		Subjects: []string{config.StreamSubject},
-		Replicas: 3,
-	})
+	err = ensureStream(ctx, js, config.StreamName, config.StreamSubject, config.StreamReplicas)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create stream %s: %w", config.StreamName, err)
+		return nil, nil, err
 	}
 
 	np := &NATSPublisher{
-		nc:            nc,
-		js:            js,
-		streamSubject: config.StreamSubject,
+		jetStreamSender: newJetStreamSender(nc, js, ob),
+		streamSubject:   config.StreamSubject,
+		device:          device,
 	}
+	return np.Publish, np.Close, nil
+}
 
-	return np.Publish, nil
+func newJetStreamSender(nc *nats.Conn, js jetstream.JetStream, ob *outbox.Outbox) *jetStreamSender {
+	s := &jetStreamSender{
+		nc:     nc,
+		js:     js,
+		outbox: ob,
+		done:   make(chan struct{}),
+	}
+	if ob != nil {
+		go func() {
+			ticker := time.NewTicker(replayInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-ticker.C:
+					s.replay(context.Background())
+				}
+			}
+		}()
+	}
+	return s
 }
 
-func (np *NATSPublisher) Publish(r Reading) error {
-	formattedValue := fmt.Sprintf("%.15f", r.Value)
-	slog.Info("publishing to NATS", "name", r.Name, "value", formattedValue)
+func (np *NATSPublisher) Publish(ctx context.Context, r Reading) error {
+	data := readingData(r, np.device)
+	slog.Info("publishing to NATS", "name", r.Name, "value", data["value"])
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could marshall data to send to jetstreams: %w", err)
+	}
+
+	msg := outbox.Message{
+		Subject: np.subjectFor(r),
+		// lets jetstream discard duplicates when a replayed message was
+		// already received
+		Header: map[string]string{
+			jetstream.MsgIDHeader: np.device.ID + "." + r.Name + "." + r.Timestamp.UTC().Format(time.RFC3339Nano),
+			DeviceIDHeader:        np.device.ID,
+			DeviceHostnameHeader:  np.device.Hostname,
+		},
+		Data: rawData,
+	}
+	if np.device.Location != "" {
+		msg.Header[DeviceLocationHeader] = np.device.Location
+	}
+	for name, value := range np.device.Labels {
+		msg.Header[DeviceLabelHeader+name] = value
+	}
+
+	return np.publish(ctx, msg)
+}
+
+// publish sends the message, or saves it to the outbox, returning ErrQueued,
+// when it can't be sent.
+func (s *jetStreamSender) publish(ctx context.Context, msg outbox.Message) error {
+	if s.outbox == nil {
+		return s.send(ctx, msg)
+	}
+
+	// keeps the messages in order while there is a backlog
+	if s.outbox.Len() > 0 {
+		s.replay(ctx)
+	}
+	if s.outbox.Len() == 0 && s.nc.IsConnected() {
+		err := s.send(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("could not publish to NATS, saving to outbox", "error", err)
+	}
+	err := s.outbox.Push(msg)
+	if err != nil {
+		return err
+	}
+	return ErrQueued
+}
+
+func (s *jetStreamSender) send(ctx context.Context, m outbox.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	data := map[string]string{
-		"name":      r.Name,
-		"value":     formattedValue,
-		"timestamp": r.Timestamp.UTC().Format(time.RFC3339),
+	msg := nats.NewMsg(m.Subject)
+	msg.Data = m.Data
+	for k, v := range m.Header {
+		msg.Header.Set(k, v)
 	}
-	rawData, err := json.Marshal(data)
+
+	ack, err := s.js.PublishMsg(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("could marshall data to send to jetstreams: %w", err)
+		return fmt.Errorf("could not send message to %s: %w", m.Subject, err)
 	}
 
-	ack, err := np.js.PublishMsg(ctx, &nats.Msg{
-		Data:    rawData,
-		Subject: np.streamSubject,
+	slog.Debug("published msg to jetstream", "sequence", ack.Sequence, "stream", ack.Stream, "duplicate", ack.Duplicate)
+	return nil
+}
+
+func (np *NATSPublisher) subjectFor(r Reading) string {
+	return strings.NewReplacer(
+		"{device}", natsclient.SubjectToken(np.device.ID),
+		"{sensor}", natsclient.SubjectToken(r.Name),
+	).Replace(np.streamSubject)
+}
+
+// ensureStream creates the stream of the subject template, or adds the subject to it when it already
+// exists with other subjects, keeping the rest of its configuration.
+func ensureStream(ctx context.Context, js jetstream.JetStream, name, subjectTemplate string, replicas int) error {
+	subject := streamFilter(subjectTemplate)
+	_, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     name,
+		Subjects: []string{subject},
+		Replicas: replicas,
 	})
-	if err != nil {
-		return fmt.Errorf("could not send message to %s: %w", np.streamSubject, err)
+	if !errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+		if err != nil {
+			return fmt.Errorf("cannot create stream %s: %w", name, err)
+		}
+		return nil
 	}
 
-	slog.Debug("published msg to jetstream", "sequence", ack.Sequence, "stream", ack.Stream)
+	stream, err := js.Stream(ctx, name)
+	if err != nil {
+		return fmt.Errorf("cannot get stream %s: %w", name, err)
+	}
+	info := stream.CachedInfo()
+	if slices.Contains(info.Config.Subjects, subject) {
+		return nil
+	}
+	slog.Info("adding subject to stream", "stream", name, "subject", subject)
+	streamConfig := info.Config
+	streamConfig.Subjects = append(streamConfig.Subjects, subject)
+	_, err = js.UpdateStream(ctx, streamConfig)
+	if err != nil {
+		return fmt.Errorf("cannot add subject %s to stream %s: %w", subject, name, err)
+	}
 	return nil
 }
+
+// streamFilter returns the subjects filter matching the subject template.
+func streamFilter(subject string) string {
+	return strings.NewReplacer("{device}", "*", "{sensor}", "*").Replace(subject)
+}
+
+// Close stops the outbox replays and closes the outbox, only the first call
+// does. The connection is shared, so it is drained by its owner.
+func (s *jetStreamSender) Close(_ context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.outbox != nil {
+			err = s.outbox.Close()
+		}
+	})
+	return err
+}
+
+// replay publishes the outbox backlog, when connected.
+func (s *jetStreamSender) replay(ctx context.Context) {
+	if s.outbox.Len() == 0 || !s.nc.IsConnected() {
+		return
+	}
+	_, err := s.outbox.Replay(func(m outbox.Message) error {
+		return s.send(ctx, m)
+	})
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("could not replay outbox", "depth", s.outbox.Len(), "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/outbox"
+	"github.com/grow/monitor-ghm/pkg/water"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATSPublisherCloseTwice(t *testing.T) {
+	ob, err := outbox.Open(filepath.Join(t.TempDir(), "outbox.jsonl"), 10, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := &NATSPublisher{jetStreamSender: &jetStreamSender{outbox: ob, done: make(chan struct{})}}
+
+	// closed again when the publishers are restarted
+	for i := 0; i < 2; i++ {
+		err := np.Close(context.Background())
+		if err != nil {
+			t.Errorf("Close() #%d error = %v", i+1, err)
+		}
+	}
+}
+
+func TestNATSPublisherQueued(t *testing.T) {
+	ob, err := outbox.Open(filepath.Join(t.TempDir(), "outbox.jsonl"), 10, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	// never connected
+	np := &NATSPublisher{
+		jetStreamSender: &jetStreamSender{nc: &nats.Conn{}, outbox: ob},
+		streamSubject:   "PlantReadings.{device}.{sensor}",
+		device:          options.Device{ID: "pi-one"},
+	}
+
+	err = np.Publish(context.Background(), Reading{Timestamp: time.Now(), Name: "pilea", Value: 42})
+	if !errors.Is(err, ErrQueued) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrQueued)
+	}
+	if ob.Len() != 1 {
+		t.Errorf("outbox depth = %d, want 1", ob.Len())
+	}
+}
+
+func TestWateringPublisherQueued(t *testing.T) {
+	ob, err := outbox.Open(filepath.Join(t.TempDir(), "outbox.jsonl"), 10, outbox.DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.Close()
+	// never connected
+	wp := &WateringPublisher{
+		jetStreamSender: &jetStreamSender{nc: &nats.Conn{}, outbox: ob},
+		subject:         "GrowWatering.pi-one",
+		device:          options.Device{ID: "pi-one", Location: "kitchen"},
+	}
+
+	err = wp.Publish(context.Background(), water.Event{Timestamp: time.Now(), Sensor: "pilea", Pump: 1, Dose: 1})
+	if !errors.Is(err, ErrQueued) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrQueued)
+	}
+	msgs := []outbox.Message{}
+	_, err = ob.Replay(func(m outbox.Message) error {
+		msgs = append(msgs, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Subject != "GrowWatering.pi-one" || !strings.Contains(string(msgs[0].Data), `"location":"kitchen"`) {
+		t.Errorf("outbox = %+v, want the event of pilea", msgs)
+	}
+}
@@ -0,0 +1,135 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	moistureGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soil_moisture",
+		Help: "Latest soil moisture reading in percent.",
+	}, []string{"name"})
+	rawMoistureGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soil_moisture_raw",
+		Help: "Latest soil moisture reading in percent, before the filters.",
+	}, []string{"name"})
+	frequencyGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soil_moisture_frequency_hz",
+		Help: "Latest soil moisture sensor pulse frequency in Hz.",
+	}, []string{"name", "connector"})
+	voltageGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soil_moisture_voltage_volts",
+		Help: "Latest analog soil moisture sensor voltage.",
+	}, []string{"name"})
+	healthGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sensor_health",
+		Help: "Sensor health, 1 for the current state.",
+	}, []string{"name", "metric", "state"})
+
+	// latest readings of the sensors of other metrics, by metric
+	valueGauges = map[string]*prometheus.GaugeVec{}
+)
+
+func init() {
+	for metric, unit := range grow.Units {
+		if metric == grow.MetricMoisture {
+			continue
+		}
+		r := Reading{Metric: string(metric), Unit: unit}
+		valueGauges[r.Metric] = promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: r.MetricName(),
+			Help: fmt.Sprintf("Latest %s reading.", metric),
+		}, []string{"name"})
+	}
+}
+
+// NewPrometheusPublisher serves the latest readings and the monitor metrics
+// on /metrics, so Prometheus can scrape the device directly.
+func NewPrometheusPublisher(address string) (Publisher, Closer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "address", listener.Addr().String())
+
+	publish := func(_ context.Context, r Reading) error {
+		return setGauges(r)
+	}
+	return publish, server.Shutdown, nil
+}
+
+// setGauges sets the gauges of the reading sensor.
+func setGauges(r Reading) error {
+	metric := r.Metric
+	if r.Moisture() {
+		metric = string(grow.MetricMoisture)
+	}
+	for _, h := range grow.Healths {
+		value := 0.0
+		if string(h) == r.Health {
+			value = 1
+		}
+		healthGauge.WithLabelValues(r.Name, metric, string(h)).Set(value)
+	}
+	if !r.Moisture() {
+		gauge, found := valueGauges[r.Metric]
+		if !found {
+			return fmt.Errorf("unknown metric %s of %s", r.Metric, r.Name)
+		}
+		if r.Health != string(grow.HealthOK) {
+			gauge.DeleteLabelValues(r.Name)
+			return nil
+		}
+		gauge.WithLabelValues(r.Name).Set(r.Value)
+		return nil
+	}
+	if r.Analog() {
+		voltageGauge.WithLabelValues(r.Name).Set(r.Voltage)
+	} else {
+		frequencyGauge.WithLabelValues(r.Name, strconv.Itoa(r.Connector)).Set(r.Frequency)
+	}
+
+	// unhealthy sensors have no moisture, so their series are removed
+	// instead of exposing NaN
+	if r.Health != string(grow.HealthOK) {
+		moistureGauge.DeleteLabelValues(r.Name)
+		rawMoistureGauge.DeleteLabelValues(r.Name)
+		return nil
+	}
+	moistureGauge.WithLabelValues(r.Name).Set(r.Value)
+	rawMoistureGauge.WithLabelValues(r.Name).Set(r.Raw)
+	return nil
+}
+
+// DeleteSensorGauges removes the series of a sensor, so removed sensors, or
+// the old connector of moved ones, aren't exposed with their last reading.
+func DeleteSensorGauges(name string) {
+	labels := prometheus.Labels{"name": name}
+	for _, gauge := range []*prometheus.GaugeVec{moistureGauge, rawMoistureGauge, frequencyGauge, voltageGauge, healthGauge} {
+		gauge.DeletePartialMatch(labels)
+	}
+	for _, gauge := range valueGauges {
+		gauge.DeletePartialMatch(labels)
+	}
+}
@@ -0,0 +1,186 @@
+package publish
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetGauges removes the series set by the previous tests.
+func resetGauges() {
+	for _, gauge := range []*prometheus.GaugeVec{moistureGauge, rawMoistureGauge, frequencyGauge, voltageGauge, healthGauge} {
+		gauge.Reset()
+	}
+	for _, gauge := range valueGauges {
+		gauge.Reset()
+	}
+}
+
+// healthSeries returns the sensor_health series of a sensor in the given
+// state.
+func healthSeries(name, metric, state string) string {
+	series := ""
+	for _, s := range []string{"disconnected", "ok", "out-of-range", "stale"} {
+		value := "0"
+		if s == state {
+			value = "1"
+		}
+		series += `sensor_health{metric="` + metric + `",name="` + name + `",state="` + s + `"} ` + value + "\n"
+	}
+	return series
+}
+
+func TestSetGauges(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []Reading
+		gauge    prometheus.Collector
+		want     string
+	}{
+		{
+			name:     "moisture",
+			readings: []Reading{{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5}},
+			gauge:    moistureGauge,
+			want: `
+# HELP soil_moisture Latest soil moisture reading in percent.
+# TYPE soil_moisture gauge
+soil_moisture{name="pilea"} 42.5
+`,
+		},
+		{
+			name:     "raw moisture",
+			readings: []Reading{{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5}},
+			gauge:    rawMoistureGauge,
+			want: `
+# HELP soil_moisture_raw Latest soil moisture reading in percent, before the filters.
+# TYPE soil_moisture_raw gauge
+soil_moisture_raw{name="pilea"} 40
+`,
+		},
+		{
+			name:     "frequency",
+			readings: []Reading{{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5}},
+			gauge:    frequencyGauge,
+			want: `
+# HELP soil_moisture_frequency_hz Latest soil moisture sensor pulse frequency in Hz.
+# TYPE soil_moisture_frequency_hz gauge
+soil_moisture_frequency_hz{connector="1",name="pilea"} 17.5
+`,
+		},
+		{
+			name:     "voltage",
+			readings: []Reading{{Name: "basil", Value: 50, Raw: 50, Health: "ok", Voltage: 2.05, DryVoltage: 2.8, WetVoltage: 1.3}},
+			gauge:    voltageGauge,
+			want: `
+# HELP soil_moisture_voltage_volts Latest analog soil moisture sensor voltage.
+# TYPE soil_moisture_voltage_volts gauge
+soil_moisture_voltage_volts{name="basil"} 2.05
+`,
+		},
+		{
+			name:     "light",
+			readings: []Reading{{Name: "window", Metric: "light", Unit: "lux", Value: 120, Raw: 120, Health: "ok"}},
+			gauge:    valueGauges["light"],
+			want: `
+# HELP light_lux Latest light reading.
+# TYPE light_lux gauge
+light_lux{name="window"} 120
+`,
+		},
+		{
+			name: "moisture removed while unhealthy",
+			readings: []Reading{
+				{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5},
+				{Name: "basil", Value: 61, Raw: 61, Health: "ok", Connector: 2, Frequency: 12},
+				{Name: "pilea", Health: "disconnected", Connector: 1},
+			},
+			gauge: moistureGauge,
+			want: `
+# HELP soil_moisture Latest soil moisture reading in percent.
+# TYPE soil_moisture gauge
+soil_moisture{name="basil"} 61
+`,
+		},
+		{
+			name: "light removed while unhealthy",
+			readings: []Reading{
+				{Name: "window", Metric: "light", Unit: "lux", Value: 120, Raw: 120, Health: "ok"},
+				{Name: "window", Metric: "light", Unit: "lux", Health: "stale"},
+			},
+			gauge: valueGauges["light"],
+			want:  "",
+		},
+		{
+			name: "health",
+			readings: []Reading{
+				{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5},
+				{Name: "window", Metric: "light", Unit: "lux", Health: "out-of-range"},
+			},
+			gauge: healthGauge,
+			want: `
+# HELP sensor_health Sensor health, 1 for the current state.
+# TYPE sensor_health gauge
+` + healthSeries("pilea", "moisture", "ok") + healthSeries("window", "light", "out-of-range"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGauges()
+			for _, r := range tt.readings {
+				err := setGauges(r)
+				if err != nil {
+					t.Fatalf("setGauges() error = %v", err)
+				}
+			}
+			err := testutil.CollectAndCompare(tt.gauge, strings.NewReader(tt.want))
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSetGaugesUnknownMetric(t *testing.T) {
+	resetGauges()
+	err := setGauges(Reading{Name: "window", Metric: "radiation", Health: "ok"})
+	if err == nil {
+		t.Errorf("setGauges() of an unknown metric didn't fail")
+	}
+}
+
+func TestDeleteSensorGauges(t *testing.T) {
+	resetGauges()
+	for _, r := range []Reading{
+		{Name: "pilea", Value: 42.5, Raw: 40, Health: "ok", Connector: 1, Frequency: 17.5},
+		{Name: "basil", Value: 61, Raw: 61, Health: "ok", Connector: 2, Frequency: 12},
+		{Name: "pilea", Metric: "light", Unit: "lux", Value: 120, Raw: 120, Health: "ok"},
+	} {
+		err := setGauges(r)
+		if err != nil {
+			t.Fatalf("setGauges() error = %v", err)
+		}
+	}
+
+	DeleteSensorGauges("pilea")
+	for _, gauge := range []prometheus.Collector{moistureGauge, rawMoistureGauge, frequencyGauge} {
+		if got := testutil.CollectAndCount(gauge); got != 1 {
+			t.Errorf("%d series left, want the one of basil", got)
+		}
+	}
+	if got := testutil.CollectAndCount(valueGauges["light"]); got != 0 {
+		t.Errorf("%d light series left, want none", got)
+	}
+	if got := testutil.CollectAndCount(healthGauge); got != 4 {
+		t.Errorf("%d health series left, want the 4 of basil", got)
+	}
+	err := testutil.CollectAndCompare(frequencyGauge, strings.NewReader(`
+# HELP soil_moisture_frequency_hz Latest soil moisture sensor pulse frequency in Hz.
+# TYPE soil_moisture_frequency_hz gauge
+soil_moisture_frequency_hz{connector="2",name="basil"} 12
+`))
+	if err != nil {
+		t.Error(err)
+	}
+}
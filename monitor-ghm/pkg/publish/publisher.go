@@ -1,11 +1,100 @@
 package publish
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+// Reading is a sensor reading. When the sensor isn't healthy the values are
+// NaN and Health tells why.
 type Reading struct {
 	Timestamp time.Time
 	Name      string
-	Value     float64
+	Metric    string  // what the sensor measures, like moisture and temperature
+	Unit      string  // unit of the values, like percent and celsius
+	Value     float64 // filtered value
+	Raw       float64 // value before the filters
+	Health    string
+
+	// pulse frequency of the soil moisture sensors
+	Connector    int
+	Frequency    float64 // sensor pulse frequency in Hz
+	MinFrequency float64 // frequency read with wet soil, 100%
+	MaxFrequency float64 // frequency read with dry soil, 0%
+
+	// voltage of the analog soil moisture sensors
+	Voltage    float64
+	DryVoltage float64 // voltage read with dry soil, 0%
+	WetVoltage float64 // voltage read with wet soil, 100%
+}
+
+// Moisture tells whether the reading is of a soil moisture sensor, readings
+// without metric are too.
+func (r Reading) Moisture() bool {
+	return r.Metric == "" || r.Metric == string(grow.MetricMoisture)
+}
+
+// Analog tells whether the reading is of an analog soil moisture sensor,
+// with voltages instead of pulse frequencies. Their calibration voltages
+// are always different.
+func (r Reading) Analog() bool {
+	return r.Moisture() && r.DryVoltage != r.WetVoltage
 }
 
-type Publisher func(Reading) error
+// MetricName returns the name of the reading metric, like
+// temperature_celsius. Soil moisture keeps its soil_moisture name.
+func (r Reading) MetricName() string {
+	if r.Moisture() {
+		return "soil_moisture"
+	}
+	if r.Unit == "" {
+		return r.Metric
+	}
+	return r.Metric + "_" + r.Unit
+}
+
+// Publisher publishes a reading, giving up when ctx is done.
+type Publisher func(context.Context, Reading) error
+
+// Closer sends the pending readings and releases the resources of a
+// publisher, giving up when ctx is done.
+type Closer func(context.Context) error
+
+// readingData returns the reading in the format sent to NATS and MQTT, with
+// the values as strings and the identity of the device.
+func readingData(r Reading, device options.Device) map[string]any {
+	data := map[string]any{
+		"name":      r.Name,
+		"metric":    r.Metric,
+		"unit":      r.Unit,
+		"value":     fmt.Sprintf("%.15f", r.Value),
+		"raw":       fmt.Sprintf("%.15f", r.Raw),
+		"health":    r.Health,
+		"timestamp": r.Timestamp.UTC().Format(time.RFC3339),
+
+		"device":   device.ID,
+		"hostname": device.Hostname,
+	}
+	if r.Analog() {
+		data["voltage"] = fmt.Sprintf("%.15f", r.Voltage)
+		data["dry_voltage"] = fmt.Sprintf("%.15f", r.DryVoltage)
+		data["wet_voltage"] = fmt.Sprintf("%.15f", r.WetVoltage)
+	} else if r.Moisture() {
+		data["connector"] = strconv.Itoa(r.Connector)
+		data["frequency"] = fmt.Sprintf("%.15f", r.Frequency)
+		data["min_frequency"] = fmt.Sprintf("%.15f", r.MinFrequency)
+		data["max_frequency"] = fmt.Sprintf("%.15f", r.MaxFrequency)
+	}
+	if device.Location != "" {
+		data["location"] = device.Location
+	}
+	if len(device.Labels) > 0 {
+		data["labels"] = device.Labels
+	}
+	return data
+}
@@ -0,0 +1,71 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/natsclient"
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/outbox"
+	"github.com/grow/monitor-ghm/pkg/water"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WateringPublisher publishes the watering events to a JetStream stream,
+// like the readings, so they reach the ingestion service after an outage.
+type WateringPublisher struct {
+	*jetStreamSender
+	subject string
+	device  options.Device
+}
+
+// NewWateringPublisher creates a publisher of the watering events to the
+// watering stream, setting the device they come from. The subject is a
+// template where {device} is replaced by the device ID. When ob isn't nil,
+// events that can't be published are saved to it and replayed in order.
+func NewWateringPublisher(nc *nats.Conn, config options.NATSConfig, device options.Device, ob *outbox.Outbox) (*WateringPublisher, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to jetstream: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = ensureStream(ctx, js, config.WateringStream, config.WateringSubject, config.StreamReplicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WateringPublisher{
+		jetStreamSender: newJetStreamSender(nc, js, ob),
+		subject:         strings.ReplaceAll(config.WateringSubject, "{device}", natsclient.SubjectToken(device.ID)),
+		device:          device,
+	}, nil
+}
+
+// Publish sends the event, it returns ErrQueued when it was saved to the
+// outbox instead.
+func (wp *WateringPublisher) Publish(ctx context.Context, event water.Event) error {
+	event.Device = wp.device.ID
+	event.Hostname = wp.device.Hostname
+	event.Location = wp.device.Location
+	event.Labels = wp.device.Labels
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not marshal watering event: %w", err)
+	}
+	return wp.publish(ctx, outbox.Message{
+		Subject: wp.subject,
+		// lets jetstream discard duplicates when a replayed event was
+		// already received
+		Header: map[string]string{
+			jetstream.MsgIDHeader: wp.device.ID + "." + event.Sensor + "." + strconv.Itoa(event.Dose) + "." + event.Timestamp.UTC().Format(time.RFC3339Nano),
+		},
+		Data: data,
+	})
+}
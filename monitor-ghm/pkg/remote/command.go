@@ -0,0 +1,130 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/grow/monitor-ghm/pkg/natsclient"
+	"github.com/nats-io/nats.go"
+)
+
+// Request is the body of a command request. Requests without body are
+// accepted for commands without arguments when no secret is set.
+type Request struct {
+	Secret string          `json:"secret,omitempty"`
+	Args   json.RawMessage `json:"args,omitempty"`
+}
+
+// Reply is the body of a command reply.
+type Reply struct {
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Result  any    `json:"result,omitempty"`
+}
+
+// CommandHandler runs a command with the arguments of the request and returns
+// its result.
+type CommandHandler func(args json.RawMessage) (any, error)
+
+// Commands answers the command requests sent to <subject>.<command>.
+type Commands struct {
+	subject  string
+	secret   string
+	handlers map[string]CommandHandler
+}
+
+// NewCommands creates the command handlers of a device. subject is a
+// template where {device} is replaced by the device ID. When secret isn't
+// empty, the requests must include it, otherwise they are only authorized
+// by the NATS permissions.
+func NewCommands(subject, device, secret string, handlers map[string]CommandHandler) *Commands {
+	device = natsclient.SubjectToken(device)
+	return &Commands{
+		subject:  strings.ReplaceAll(subject, "{device}", device),
+		secret:   secret,
+		handlers: handlers,
+	}
+}
+
+// Subscribe answers the requests until the subscription is closed.
+func (c *Commands) Subscribe(nc *nats.Conn) (*nats.Subscription, error) {
+	sub, err := nc.Subscribe(c.subject+".*", func(msg *nats.Msg) {
+		reply := c.Handle(msg.Subject, msg.Data)
+		data, err := json.Marshal(reply)
+		if err != nil {
+			data, _ = json.Marshal(Reply{Command: reply.Command, Error: fmt.Sprintf("could not marshal result: %s", err)})
+		}
+		err = msg.Respond(data)
+		if err != nil {
+			slog.Warn("could not reply to command", "subject", msg.Subject, "error", err)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to commands on %s: %w", c.subject, err)
+	}
+	return sub, nil
+}
+
+// Handle runs the command of a request sent to subject.
+func (c *Commands) Handle(subject string, data []byte) Reply {
+	command := strings.TrimPrefix(subject, c.subject+".")
+	reply := Reply{Command: command}
+
+	req := Request{}
+	if len(data) > 0 {
+		err := json.Unmarshal(data, &req)
+		if err != nil {
+			reply.Error = fmt.Sprintf("invalid request: %s", err)
+			return reply
+		}
+	}
+	if c.secret != "" && subtle.ConstantTimeCompare([]byte(req.Secret), []byte(c.secret)) != 1 {
+		slog.Warn("unauthorized command", "command", command)
+		reply.Error = "unauthorized"
+		return reply
+	}
+
+	handler, found := c.handlers[command]
+	if !found {
+		names := []string{}
+		for name := range c.handlers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		reply.Error = fmt.Sprintf("unknown command %s, must be one of %s", command, strings.Join(names, ", "))
+		return reply
+	}
+
+	slog.Info("running command", "command", command, "args", string(req.Args))
+	result, err := handler(req.Args)
+	if err != nil {
+		slog.Warn("command failed", "command", command, "error", err)
+		reply.Error = err.Error()
+		return reply
+	}
+	reply.OK = true
+	reply.Result = result
+	return reply
+}
+
+// ParseArgs decodes the arguments of a command into v, failing on unknown
+// fields so typos don't go unnoticed.
+func ParseArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		return errors.New("missing arguments")
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCommandsHandle(t *testing.T) {
+	handlers := map[string]CommandHandler{
+		"echo": func(args json.RawMessage) (any, error) {
+			var v struct{ Text string }
+			err := ParseArgs(args, &v)
+			return v.Text, err
+		},
+		"fail": func(json.RawMessage) (any, error) {
+			return nil, errors.New("broken")
+		},
+	}
+	tests := []struct {
+		name    string
+		secret  string
+		subject string
+		data    string
+		want    Reply
+	}{
+		{
+			name:    "ok",
+			subject: "grow.cmd.pi_one.echo",
+			data:    `{"args": {"text": "hi"}}`,
+			want:    Reply{Command: "echo", OK: true, Result: "hi"},
+		},
+		{
+			name:    "secret",
+			secret:  "s3cret",
+			subject: "grow.cmd.pi_one.echo",
+			data:    `{"secret": "s3cret", "args": {"text": "hi"}}`,
+			want:    Reply{Command: "echo", OK: true, Result: "hi"},
+		},
+		{
+			name:    "wrong secret",
+			secret:  "s3cret",
+			subject: "grow.cmd.pi_one.echo",
+			data:    `{"secret": "guess", "args": {"text": "hi"}}`,
+			want:    Reply{Command: "echo", Error: "unauthorized"},
+		},
+		{
+			name:    "missing secret",
+			secret:  "s3cret",
+			subject: "grow.cmd.pi_one.fail",
+			want:    Reply{Command: "fail", Error: "unauthorized"},
+		},
+		{
+			name:    "unknown command",
+			subject: "grow.cmd.pi_one.reboot",
+			want:    Reply{Command: "reboot", Error: "unknown command reboot, must be one of echo, fail"},
+		},
+		{
+			name:    "invalid request",
+			subject: "grow.cmd.pi_one.echo",
+			data:    `not json`,
+			want:    Reply{Command: "echo", Error: "invalid request: invalid character 'o' in literal null (expecting 'u')"},
+		},
+		{
+			name:    "missing arguments",
+			subject: "grow.cmd.pi_one.echo",
+			want:    Reply{Command: "echo", Error: "missing arguments"},
+		},
+		{
+			name:    "unknown argument",
+			subject: "grow.cmd.pi_one.echo",
+			data:    `{"args": {"txt": "hi"}}`,
+			want:    Reply{Command: "echo", Error: `invalid arguments: json: unknown field "txt"`},
+		},
+		{
+			name:    "command error",
+			subject: "grow.cmd.pi_one.fail",
+			want:    Reply{Command: "fail", Error: "broken"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCommands("grow.cmd.{device}", "pi.one", tt.secret, handlers)
+			got := c.Handle(tt.subject, []byte(tt.data))
+			if got != tt.want {
+				t.Errorf("Handle() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
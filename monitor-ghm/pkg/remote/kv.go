@@ -0,0 +1,142 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// KVConfig loads the sensors and the readings frequency from a NATS
+// KeyValue bucket. The value for the device ID key uses the same format as
+// the config file, but only the sensors, the frequency and the jitter are
+// applied, on top of the options of the flags and the config file.
+type KVConfig struct {
+	kv       jetstream.KeyValue
+	key      string
+	revision uint64
+
+	mu    sync.Mutex
+	value []byte // latest valid value, nil when missing or deleted
+}
+
+func NewKVConfig(nc *nats.Conn, bucket string, deviceID string) (*KVConfig, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to jetstream: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open key value bucket %s: %w", bucket, err)
+	}
+
+	return &KVConfig{kv: kv, key: deviceID}, nil
+}
+
+// Load returns the options with the sensors and frequency from the bucket
+// applied on top of base.
+func (c *KVConfig) Load(base options.Options) (options.Options, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	entry, err := c.kv.Get(ctx, c.key)
+	if err != nil {
+		return base, fmt.Errorf("cannot get key %s from bucket %s: %w", c.key, c.kv.Bucket(), err)
+	}
+	c.revision = entry.Revision()
+	opt, err := merge(entry.Value(), base)
+	if err != nil {
+		return base, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = entry.Value()
+	return opt, nil
+}
+
+// Apply returns the options with the latest configuration of the bucket
+// applied on top of base, base when there is none.
+func (c *KVConfig) Apply(base options.Options) (options.Options, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.value == nil {
+		return base, nil
+	}
+	return merge(c.value, base)
+}
+
+// Watch calls onChange every time the device configuration is updated or
+// deleted in the bucket, use Apply to get the new options. Updates that are
+// invalid on top of base, the options of the flags and the config file, are
+// logged and ignored. The returned function stops the watcher, waiting for a
+// running onChange to return.
+func (c *KVConfig) Watch(base func() options.Options, onChange func()) (func(), error) {
+	w, err := c.kv.Watch(context.Background(), c.key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot watch key %s from bucket %s: %w", c.key, c.kv.Bucket(), err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for entry := range w.Updates() {
+			// nil marks the end of the initial values
+			if entry == nil || entry.Revision() <= c.revision {
+				continue
+			}
+			c.revision = entry.Revision()
+			if entry.Operation() != jetstream.KeyValuePut {
+				slog.Warn("remote config deleted, using the local one", "key", c.key)
+				c.mu.Lock()
+				c.value = nil
+				c.mu.Unlock()
+				onChange()
+				continue
+			}
+
+			_, err := merge(entry.Value(), base())
+			if err != nil {
+				slog.Error("could not reload remote config", "key", c.key, "error", err)
+				continue
+			}
+			c.mu.Lock()
+			c.value = entry.Value()
+			c.mu.Unlock()
+			slog.Info("remote config reloaded", "key", c.key, "revision", entry.Revision())
+			onChange()
+		}
+	}()
+
+	return func() {
+		w.Stop()
+		<-stopped
+	}, nil
+}
+
+func merge(data []byte, base options.Options) (options.Options, error) {
+	fc, err := options.ParseConfig(data)
+	if err != nil {
+		return base, err
+	}
+	if len(fc.Sensors) > 0 {
+		sensors, err := fc.SensorsOptions()
+		if err != nil {
+			return base, err
+		}
+		base.Sensors = sensors
+	}
+	if fc.Frequency != 0 {
+		base.Frequency = fc.Frequency
+	}
+	if fc.Jitter != 0 {
+		base.Jitter = fc.Jitter
+	}
+	return base, base.Validate()
+}
@@ -0,0 +1,148 @@
+package remote
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeEntry is a jetstream.KeyValueEntry of the fakeKV.
+type fakeEntry struct {
+	jetstream.KeyValueEntry
+	value     []byte
+	revision  uint64
+	operation jetstream.KeyValueOp
+}
+
+func (e fakeEntry) Value() []byte                   { return e.value }
+func (e fakeEntry) Revision() uint64                { return e.revision }
+func (e fakeEntry) Operation() jetstream.KeyValueOp { return e.operation }
+
+// fakeKV is a jetstream.KeyValue sending the updates of the watched key to
+// the watcher.
+type fakeKV struct {
+	jetstream.KeyValue
+	updates chan jetstream.KeyValueEntry
+}
+
+func (kv *fakeKV) Watch(context.Context, string, ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	return kv, nil
+}
+
+func (kv *fakeKV) Updates() <-chan jetstream.KeyValueEntry {
+	return kv.updates
+}
+
+func (kv *fakeKV) Stop() error {
+	close(kv.updates)
+	return nil
+}
+
+func (kv *fakeKV) Bucket() string {
+	return "grow"
+}
+
+func TestKVConfigWatch(t *testing.T) {
+	local := options.Options{
+		Frequency:     5 * time.Minute,
+		Workers:       options.DefaultWorkers,
+		ReaderBackend: options.Simulated,
+		PumpDriver:    options.FakePump,
+		Samples:       1,
+		Sensors:       []options.Sensors{{Name: "pilea", Connector: 1}},
+	}
+	kv := &fakeKV{updates: make(chan jetstream.KeyValueEntry)}
+	c := &KVConfig{kv: kv, key: "pi-one"}
+	changes := make(chan options.Options)
+	stop, err := c.Watch(func() options.Options { return local }, func() {
+		opt, err := c.Apply(local)
+		if err != nil {
+			t.Errorf("Apply() error = %v", err)
+		}
+		changes <- opt
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer stop()
+
+	tests := []struct {
+		name      string
+		entry     fakeEntry
+		sensors   []string
+		frequency time.Duration
+	}{
+		{
+			name:      "sensors and frequency",
+			entry:     fakeEntry{value: []byte("frequency: 1m\nsensors: [{name: basil, connector: 2}]"), operation: jetstream.KeyValuePut},
+			sensors:   []string{"basil"},
+			frequency: time.Minute,
+		},
+		{
+			name:      "sensors removed",
+			entry:     fakeEntry{value: []byte("frequency: 2m"), operation: jetstream.KeyValuePut},
+			sensors:   []string{"pilea"},
+			frequency: 2 * time.Minute,
+		},
+		{
+			name:      "deleted",
+			entry:     fakeEntry{operation: jetstream.KeyValueDelete},
+			sensors:   []string{"pilea"},
+			frequency: 5 * time.Minute,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.entry.revision = uint64(i + 1)
+			kv.updates <- tt.entry
+			opt := <-changes
+			names := []string{}
+			for _, s := range opt.Sensors {
+				names = append(names, s.Name)
+			}
+			if !slices.Equal(names, tt.sensors) {
+				t.Errorf("sensors = %q, want %q", names, tt.sensors)
+			}
+			if opt.Frequency != tt.frequency {
+				t.Errorf("frequency = %s, want %s", opt.Frequency, tt.frequency)
+			}
+		})
+	}
+}
+
+func TestKVConfigWatchStop(t *testing.T) {
+	kv := &fakeKV{updates: make(chan jetstream.KeyValueEntry)}
+	c := &KVConfig{kv: kv, key: "pi-one"}
+	reloading := make(chan struct{})
+	release := make(chan struct{})
+	stop, err := c.Watch(func() options.Options { return options.Options{} }, func() {
+		close(reloading)
+		<-release
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	kv.updates <- fakeEntry{revision: 1, operation: jetstream.KeyValueDelete}
+	<-reloading
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("stop returned while reloading")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("stop didn't return after the reload")
+	}
+}
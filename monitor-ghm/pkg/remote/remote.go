@@ -0,0 +1,54 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/nats-io/nats.go"
+)
+
+// Connect opens the NATS connection shared by the publisher and the remote
+// configuration.
+func Connect(config options.NATSConfig) (*nats.Conn, error) {
+	security, err := config.Security.Options()
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(config.URL, append(security,
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("disconnected from nats", "error", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			slog.Info("reconnected to nats", "url", nc.ConnectedUrl())
+		}),
+	)...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to nats %s: %w", config.URL, err)
+	}
+	return nc, nil
+}
+
+// Drain drains the connection, so the pending messages are sent and the
+// subscriptions stopped, and waits for it to close until ctx is done.
+func Drain(ctx context.Context, nc *nats.Conn) error {
+	err := nc.Drain()
+	if err != nil {
+		return fmt.Errorf("cannot drain nats connection: %w", err)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+			return fmt.Errorf("cannot drain nats connection: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// files read by Collect
+const (
+	thermalZone = "/sys/class/thermal/thermal_zone0/temp"
+	loadAvg     = "/proc/loadavg"
+	uptime      = "/proc/uptime"
+	wireless    = "/proc/net/wireless"
+)
+
+// Heartbeat is the periodic status of a device. System values that can't be
+// read are left out.
+type Heartbeat struct {
+	Device   string            `json:"device"`
+	Hostname string            `json:"hostname"`
+	Location string            `json:"location,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+	Interval  float64   `json:"interval"` // seconds until the next heartbeat
+	Version   string    `json:"version"`
+	Uptime    float64   `json:"uptime"` // seconds since the monitor started
+	Sensors   []string  `json:"sensors"`
+
+	PublishErrors map[string]int64 `json:"publish_errors"`
+
+	System
+}
+
+// System is the status of the operating system and the hardware.
+type System struct {
+	SystemUptime   *float64  `json:"system_uptime,omitempty"`   // seconds since boot
+	CPUTemperature *float64  `json:"cpu_temperature,omitempty"` // Celsius
+	Load           []float64 `json:"load,omitempty"`            // 1, 5 and 15 minutes averages
+	DiskFree       *uint64   `json:"disk_free,omitempty"`       // bytes
+	DiskTotal      *uint64   `json:"disk_total,omitempty"`      // bytes
+	WiFi           *WiFi     `json:"wifi,omitempty"`
+}
+
+type WiFi struct {
+	Interface   string  `json:"interface"`
+	LinkQuality float64 `json:"link_quality"`
+	Signal      float64 `json:"signal"` // dBm
+}
+
+// Collect reads the system status. diskPath is the file system checked for
+// free space.
+func Collect(diskPath string) System {
+	s := System{}
+	if v, err := readSystemUptime(uptime); err == nil {
+		s.SystemUptime = &v
+	}
+	if v, err := readTemperature(thermalZone); err == nil {
+		s.CPUTemperature = &v
+	}
+	if v, err := readLoad(loadAvg); err == nil {
+		s.Load = v
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(diskPath, &fs); err == nil {
+		free := fs.Bavail * uint64(fs.Bsize)
+		total := fs.Blocks * uint64(fs.Bsize)
+		s.DiskFree, s.DiskTotal = &free, &total
+	}
+	if v, err := readWiFi(wireless); err == nil {
+		s.WiFi = v
+	}
+	return s
+}
+
+// readTemperature reads a thermal zone, in millidegrees Celsius.
+func readTemperature(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	millis, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature in %s: %w", path, err)
+	}
+	return millis / 1000, nil
+}
+
+func readLoad(path string) ([]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid load in %s: %q", path, data)
+	}
+	load := make([]float64, 3)
+	for i := range load {
+		load[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid load in %s: %w", path, err)
+		}
+	}
+	return load, nil
+}
+
+func readSystemUptime(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid uptime in %s: %q", path, data)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func readWiFi(path string) (*WiFi, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseWireless(f)
+}
+
+// parseWireless returns the first interface in /proc/net/wireless, after
+// the two header lines:
+//
+//	Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+//	 face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+//	 wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0
+func parseWireless(r io.Reader) (*WiFi, error) {
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		name, values, found := strings.Cut(scanner.Text(), ":")
+		fields := strings.Fields(values)
+		if !found || len(fields) < 3 {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link quality %s: %w", fields[1], err)
+		}
+		signal, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signal level %s: %w", fields[2], err)
+		}
+		return &WiFi{Interface: strings.TrimSpace(name), LinkQuality: quality, Signal: signal}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no wireless interface")
+}
@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWireless(t *testing.T) {
+	header := "Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n" +
+		" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n"
+	tests := []struct {
+		name    string
+		content string
+		want    *WiFi
+		wantErr bool
+	}{
+		{
+			name:    "connected",
+			content: header + " wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0\n",
+			want:    &WiFi{Interface: "wlan0", LinkQuality: 70, Signal: -40},
+		},
+		{
+			name:    "no interface",
+			content: header,
+			wantErr: true,
+		},
+		{
+			name:    "invalid signal",
+			content: header + " wlan0: 0000   70.  abc.  -256        0      0      0      0      0        0\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWireless(strings.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWireless() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWireless() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	temperature, err := readTemperature(write("temp", "48312\n"))
+	if err != nil || temperature != 48.312 {
+		t.Errorf("readTemperature() = %v, %v, want 48.312", temperature, err)
+	}
+	load, err := readLoad(write("loadavg", "0.52 0.38 0.21 1/123 4567\n"))
+	if err != nil || !reflect.DeepEqual(load, []float64{0.52, 0.38, 0.21}) {
+		t.Errorf("readLoad() = %v, %v", load, err)
+	}
+	uptime, err := readSystemUptime(write("uptime", "3600.25 7000.10\n"))
+	if err != nil || uptime != 3600.25 {
+		t.Errorf("readSystemUptime() = %v, %v, want 3600.25", uptime, err)
+	}
+	_, err = readTemperature(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Errorf("readTemperature() of a missing file didn't fail")
+	}
+}
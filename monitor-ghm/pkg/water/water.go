@@ -0,0 +1,320 @@
+package water
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+)
+
+// reasons a dose is skipped
+const (
+	SkippedDailyMax  = "daily-max"
+	SkippedUnhealthy = "unhealthy"
+)
+
+// errors of the manual runs
+var (
+	ErrWatering = errors.New("already watering")
+	ErrDailyMax = errors.New("daily watering limit reached")
+	ErrDryRun   = errors.New("pumps aren't run in dry run")
+	ErrClosed   = errors.New("watering stopped")
+)
+
+// Config controls when and how much a plant is watered.
+type Config struct {
+	Threshold float64       // moisture percentage starting a watering
+	Target    float64       // moisture percentage the rechecks water up to
+	Dose      time.Duration // how long the pump runs per dose
+	Soak      time.Duration // wait after a dose before checking the moisture again
+	MaxDoses  int           // doses per watering
+	Cooldown  time.Duration // minimum time between the end of a watering and the next one
+	DailyMax  int           // doses in the last 24 hours, 0 for no limit
+}
+
+// Event is a dose of water given, or skipped, to a plant. The device is set
+// by the publisher.
+type Event struct {
+	Device   string            `json:"device"`
+	Hostname string            `json:"hostname"`
+	Location string            `json:"location,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+	Sensor    string    `json:"sensor"`
+	Pump      int       `json:"pump"`
+	Moisture  float64   `json:"moisture"` // before the dose
+	Dose      int       `json:"dose"`     // of the watering, from 1
+	Duration  float64   `json:"duration"` // seconds the pump ran
+	DryRun    bool      `json:"dry_run"`
+	Skipped   string    `json:"skipped,omitempty"` // why the dose wasn't given
+	Error     string    `json:"error,omitempty"`
+}
+
+// Controller waters the plant of a sensor when its moisture drops below the
+// threshold. A watering gives a dose, lets the soil soak, and checks the
+// moisture again, giving more doses until the target or config.MaxDoses is
+// reached.
+type Controller struct {
+	sensor string
+	pump   int
+	driver grow.Pump
+	dryRun bool
+	read   func() (float64, bool)
+	notify func(Event)
+
+	// replaced by the tests
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+
+	mu       sync.Mutex
+	config   Config
+	watering bool
+	cancel   context.CancelFunc // of the watering in progress
+	done     chan struct{}      // closed when the watering in progress ends
+	closed   bool
+	last     time.Time   // end of the last watering
+	doses    []time.Time // doses given in the last 24 hours
+}
+
+// NewController creates a controller watering with pump, numbered as the
+// pump outputs of the board. read returns a fresh moisture for the rechecks
+// and whether the sensor is healthy. Every dose is passed to notify. In dry
+// run, the doses are notified but the pump isn't run.
+func NewController(sensor string, pump int, driver grow.Pump, config Config, dryRun bool, read func() (float64, bool), notify func(Event)) *Controller {
+	return &Controller{
+		sensor: sensor,
+		pump:   pump,
+		driver: driver,
+		dryRun: dryRun,
+		read:   read,
+		notify: notify,
+		now:    time.Now,
+		sleep:  sleep,
+		config: config,
+	}
+}
+
+// SetConfig changes the config, the watering in progress keeps the previous
+// one.
+func (c *Controller) SetConfig(config Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+}
+
+// Watering tells whether a watering or a pump run is in progress.
+func (c *Controller) Watering() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.watering
+}
+
+// Observe starts a watering in the background when moisture is below the
+// threshold, the controller isn't watering and the cooldown is over. It
+// returns whether the watering started. The watering stops when ctx is done.
+func (c *Controller) Observe(ctx context.Context, moisture float64) bool {
+	ctx, config, ok := c.start(ctx, moisture)
+	if !ok {
+		return false
+	}
+	go c.water(ctx, config, moisture)
+	return true
+}
+
+// Water waters like Observe, but returns once the watering is over.
+func (c *Controller) Water(ctx context.Context, moisture float64) bool {
+	ctx, config, ok := c.start(ctx, moisture)
+	if !ok {
+		return false
+	}
+	c.water(ctx, config, moisture)
+	return true
+}
+
+func (c *Controller) start(ctx context.Context, moisture float64) (context.Context, Config, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.watering || moisture >= c.config.Threshold {
+		return ctx, c.config, false
+	}
+	if !c.last.IsZero() && c.now().Sub(c.last) < c.config.Cooldown {
+		slog.Debug("plant dry, waiting for the cooldown", "sensor", c.sensor, "moisture", moisture, "last", c.last)
+		return ctx, c.config, false
+	}
+	return c.begin(ctx), c.config, true
+}
+
+// begin marks the controller as watering, with c.mu held. The returned
+// context is cancelled by Close.
+func (c *Controller) begin(ctx context.Context) context.Context {
+	c.watering = true
+	c.done = make(chan struct{})
+	ctx, c.cancel = context.WithCancel(ctx)
+	return ctx
+}
+
+// Run runs the pump for duration in the background, like a dose given by
+// hand. The run counts for the daily maximum and the cooldown, and it is
+// notified as an event with dose 0. It fails while watering, in dry run, or
+// when the daily maximum was reached.
+func (c *Controller) Run(ctx context.Context, duration time.Duration) error {
+	if c.dryRun {
+		return ErrDryRun
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.watering {
+		return ErrWatering
+	}
+	if !c.countDose(c.config.DailyMax) {
+		return ErrDailyMax
+	}
+	ctx = c.begin(ctx)
+
+	go func() {
+		defer c.finish()
+		slog.Info("running pump", "sensor", c.sensor, "pump", c.pump, "duration", duration)
+		event := Event{Timestamp: c.now(), Sensor: c.sensor, Pump: c.pump}
+		ran, err := c.driver.Run(ctx, duration)
+		event.Duration = ran.Seconds()
+		if err != nil {
+			slog.Error("could not run pump", "sensor", c.sensor, "pump", c.pump, "error", err)
+			event.Error = err.Error()
+		}
+		c.notify(event)
+	}()
+	return nil
+}
+
+// finish ends a watering or a run, starting the cooldown.
+func (c *Controller) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancel()
+	c.watering = false
+	c.last = c.now()
+	close(c.done)
+}
+
+// Close stops the watering in progress, waits for it to end and closes the
+// pump, so the pump is off when it returns. Waterings and runs aren't
+// started afterwards.
+func (c *Controller) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	var done chan struct{}
+	if c.watering {
+		c.cancel()
+		done = c.done
+	}
+	c.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	return c.driver.Close()
+}
+
+func (c *Controller) water(ctx context.Context, config Config, moisture float64) {
+	defer c.finish()
+
+	slog.Info("watering plant", "sensor", c.sensor, "pump", c.pump, "moisture", moisture, "dry_run", c.dryRun)
+	for dose := 1; dose <= config.MaxDoses && ctx.Err() == nil; dose++ {
+		event := Event{
+			Timestamp: c.now(),
+			Sensor:    c.sensor,
+			Pump:      c.pump,
+			Moisture:  moisture,
+			Dose:      dose,
+			DryRun:    c.dryRun,
+		}
+		if !c.allowDose(config.DailyMax) {
+			slog.Warn("daily watering limit reached", "sensor", c.sensor, "max", config.DailyMax)
+			event.Skipped = SkippedDailyMax
+			c.notify(event)
+			return
+		}
+
+		duration := config.Dose
+		var err error
+		if !c.dryRun {
+			duration, err = c.driver.Run(ctx, config.Dose)
+		}
+		event.Duration = duration.Seconds()
+		if err != nil {
+			slog.Error("could not run pump", "sensor", c.sensor, "pump", c.pump, "error", err)
+			event.Error = err.Error()
+			c.notify(event)
+			return
+		}
+		c.notify(event)
+		if dose == config.MaxDoses {
+			return
+		}
+
+		// lets the water reach the sensor before checking again
+		err = c.sleep(ctx, config.Soak)
+		if err != nil {
+			return
+		}
+		var healthy bool
+		moisture, healthy = c.read()
+		if !healthy {
+			slog.Warn("sensor not healthy, stopping watering", "sensor", c.sensor)
+			c.notify(Event{
+				Timestamp: c.now(),
+				Sensor:    c.sensor,
+				Pump:      c.pump,
+				Dose:      dose + 1,
+				DryRun:    c.dryRun,
+				Skipped:   SkippedUnhealthy,
+			})
+			return
+		}
+		if moisture >= config.Target {
+			slog.Info("plant watered", "sensor", c.sensor, "moisture", moisture, "doses", dose)
+			return
+		}
+	}
+}
+
+// allowDose counts a dose unless max doses were already given in the last
+// 24 hours.
+func (c *Controller) allowDose(max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.countDose(max)
+}
+
+// countDose is allowDose with c.mu held.
+func (c *Controller) countDose(max int) bool {
+	now := c.now()
+	doses := c.doses[:0]
+	for _, d := range c.doses {
+		if now.Sub(d) < 24*time.Hour {
+			doses = append(doses, d)
+		}
+	}
+	c.doses = doses
+	if max > 0 && len(c.doses) >= max {
+		return false
+	}
+	c.doses = append(c.doses, now)
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
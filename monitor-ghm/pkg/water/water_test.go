@@ -0,0 +1,339 @@
+package water
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+)
+
+var testConfig = Config{
+	Threshold: 30,
+	Target:    40,
+	Dose:      2 * time.Second,
+	Soak:      5 * time.Minute,
+	MaxDoses:  3,
+	Cooldown:  time.Hour,
+	DailyMax:  5,
+}
+
+// testController returns a controller on a fake clock, advanced by the
+// soaks, reading the rechecks in order.
+func testController(pump grow.Pump, config Config, dryRun bool, rechecks []float64) (*Controller, *[]Event, *time.Time) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := &[]Event{}
+	c := NewController("pilea", 1, pump, config, dryRun,
+		func() (float64, bool) {
+			if len(rechecks) == 0 {
+				return 0, false
+			}
+			m := rechecks[0]
+			rechecks = rechecks[1:]
+			return m, true
+		},
+		func(e Event) { *events = append(*events, e) })
+	c.now = func() time.Time { return now }
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return ctx.Err()
+	}
+	return c, events, &now
+}
+
+func TestWater(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		dryRun   bool
+		moisture float64
+		rechecks []float64
+		watered  bool
+		runs     int
+		skipped  []string // of each event, "" when given
+	}{
+		{
+			name:     "wet enough",
+			config:   testConfig,
+			moisture: 35,
+			watered:  false,
+		},
+		{
+			name:     "one dose reaches the target",
+			config:   testConfig,
+			moisture: 20,
+			rechecks: []float64{45},
+			watered:  true,
+			runs:     1,
+			skipped:  []string{""},
+		},
+		{
+			name:     "doses until the target",
+			config:   testConfig,
+			moisture: 20,
+			rechecks: []float64{28, 41},
+			watered:  true,
+			runs:     2,
+			skipped:  []string{"", ""},
+		},
+		{
+			name:     "stops after max doses",
+			config:   testConfig,
+			moisture: 10,
+			rechecks: []float64{15, 20, 25},
+			watered:  true,
+			runs:     3,
+			skipped:  []string{"", "", ""},
+		},
+		{
+			name:     "stops when the sensor is unhealthy",
+			config:   testConfig,
+			moisture: 10,
+			watered:  true,
+			runs:     1,
+			skipped:  []string{"", SkippedUnhealthy},
+		},
+		{
+			name:     "daily max",
+			config:   Config{Threshold: 30, Target: 40, Dose: time.Second, MaxDoses: 3, DailyMax: 2},
+			moisture: 10,
+			rechecks: []float64{15, 20},
+			watered:  true,
+			runs:     2,
+			skipped:  []string{"", "", SkippedDailyMax},
+		},
+		{
+			name:     "dry run",
+			config:   testConfig,
+			dryRun:   true,
+			moisture: 20,
+			rechecks: []float64{45},
+			watered:  true,
+			runs:     0,
+			skipped:  []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pump := &grow.FakePump{}
+			c, events, _ := testController(pump, tt.config, tt.dryRun, tt.rechecks)
+
+			watered := c.Water(context.Background(), tt.moisture)
+			if watered != tt.watered {
+				t.Errorf("watered = %v, want %v", watered, tt.watered)
+			}
+			if len(pump.Runs()) != tt.runs {
+				t.Errorf("pump runs = %v, want %d", pump.Runs(), tt.runs)
+			}
+			for _, d := range pump.Runs() {
+				if d != tt.config.Dose {
+					t.Errorf("pump ran %s, want %s", d, tt.config.Dose)
+				}
+			}
+			skipped := []string{}
+			for i, e := range *events {
+				skipped = append(skipped, e.Skipped)
+				if e.Dose != i+1 || e.Sensor != "pilea" || e.Pump != 1 || e.DryRun != tt.dryRun {
+					t.Errorf("unexpected event %+v", e)
+				}
+				if e.Skipped == "" && e.Duration != tt.config.Dose.Seconds() {
+					t.Errorf("event duration = %g, want %g", e.Duration, tt.config.Dose.Seconds())
+				}
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("events skipped = %q, want %q", skipped, tt.skipped)
+			}
+			if c.Watering() {
+				t.Error("still watering")
+			}
+		})
+	}
+}
+
+func TestWaterCooldown(t *testing.T) {
+	pump := &grow.FakePump{}
+	c, _, now := testController(pump, testConfig, false, []float64{45, 45})
+
+	if !c.Water(context.Background(), 20) {
+		t.Fatal("not watered")
+	}
+	*now = now.Add(30 * time.Minute)
+	if c.Water(context.Background(), 20) {
+		t.Error("watered during the cooldown")
+	}
+	*now = now.Add(31 * time.Minute)
+	if !c.Water(context.Background(), 20) {
+		t.Error("not watered after the cooldown")
+	}
+}
+
+func TestWaterDailyMaxExpires(t *testing.T) {
+	config := testConfig
+	config.MaxDoses = 1
+	config.DailyMax = 1
+	config.Cooldown = 0
+	pump := &grow.FakePump{}
+	c, events, now := testController(pump, config, false, nil)
+
+	c.Water(context.Background(), 20)
+	*now = now.Add(time.Hour)
+	c.Water(context.Background(), 20)
+	*now = now.Add(24 * time.Hour)
+	c.Water(context.Background(), 20)
+
+	if len(pump.Runs()) != 2 {
+		t.Errorf("pump runs = %v, want 2", pump.Runs())
+	}
+	if len(*events) != 3 || (*events)[1].Skipped != SkippedDailyMax {
+		t.Errorf("unexpected events %+v", *events)
+	}
+}
+
+func TestWaterPumpError(t *testing.T) {
+	pump := &grow.FakePump{Err: errors.New("line busy")}
+	c, events, _ := testController(pump, testConfig, false, []float64{45})
+
+	c.Water(context.Background(), 20)
+	if len(*events) != 1 || (*events)[0].Error != "line busy" {
+		t.Errorf("unexpected events %+v", *events)
+	}
+}
+
+func TestObserveCancelled(t *testing.T) {
+	pump := &grow.FakePump{Wait: true}
+	config := testConfig
+	config.Dose = time.Minute
+	c := NewController("pilea", 1, pump, config, false, func() (float64, bool) { return 45, true }, func(Event) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if !c.Observe(ctx, 20) {
+		t.Fatal("watering not started")
+	}
+	if c.Observe(ctx, 20) {
+		t.Error("second watering started while watering")
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for c.Watering() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.Watering() {
+		t.Fatal("watering not stopped")
+	}
+	// the dose may not have started when cancelled
+	for _, d := range pump.Runs() {
+		if d >= config.Dose {
+			t.Errorf("pump ran %s, want it stopped early", d)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	config := testConfig
+	config.DailyMax = 2
+	pump := &grow.FakePump{}
+	c, events, _ := testController(pump, config, false, []float64{45})
+
+	// waits for the run in the background
+	wait := func() {
+		deadline := time.Now().Add(time.Second)
+		for c.Watering() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	err := c.Run(context.Background(), 3*time.Second)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	wait()
+	if len(*events) != 1 || (*events)[0].Dose != 0 || (*events)[0].Duration != 3 {
+		t.Errorf("unexpected events %+v", *events)
+	}
+	if c.Water(context.Background(), 20) {
+		t.Error("watered during the cooldown of the run")
+	}
+
+	// the run counts for the daily max
+	c.SetConfig(Config{Threshold: 30, Target: 40, Dose: time.Second, MaxDoses: 1, DailyMax: 2})
+	if !c.Water(context.Background(), 20) {
+		t.Fatal("not watered")
+	}
+	err = c.Run(context.Background(), time.Second)
+	if !errors.Is(err, ErrDailyMax) {
+		t.Errorf("run error = %v, want %v", err, ErrDailyMax)
+	}
+	if len(pump.Runs()) != 2 {
+		t.Errorf("pump runs = %v, want 2", pump.Runs())
+	}
+}
+
+func TestRunWhileWatering(t *testing.T) {
+	pump := &grow.FakePump{Wait: true}
+	config := testConfig
+	config.Dose = time.Minute
+	c := NewController("pilea", 1, pump, config, false, func() (float64, bool) { return 45, true }, func(Event) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !c.Observe(ctx, 20) {
+		t.Fatal("watering not started")
+	}
+	err := c.Run(ctx, time.Second)
+	if !errors.Is(err, ErrWatering) {
+		t.Errorf("run error = %v, want %v", err, ErrWatering)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	pump := &grow.FakePump{}
+	c, events, _ := testController(pump, testConfig, true, nil)
+
+	err := c.Run(context.Background(), time.Second)
+	if !errors.Is(err, ErrDryRun) {
+		t.Errorf("run error = %v, want %v", err, ErrDryRun)
+	}
+	if len(pump.Runs()) != 0 || len(*events) != 0 || c.Watering() {
+		t.Errorf("pump ran in dry run, runs %v, events %+v", pump.Runs(), *events)
+	}
+}
+
+func TestClose(t *testing.T) {
+	pump := &grow.FakePump{Wait: true}
+	config := testConfig
+	config.Dose = time.Minute
+	c := NewController("pilea", 1, pump, config, false, func() (float64, bool) { return 45, true }, func(Event) {})
+
+	if !c.Observe(context.Background(), 20) {
+		t.Fatal("watering not started")
+	}
+	start := time.Now()
+	err := c.Close()
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Close() waited %s for the dose", time.Since(start))
+	}
+	if c.Watering() {
+		t.Error("still watering after Close()")
+	}
+	if !pump.Closed() {
+		t.Error("pump not closed")
+	}
+	for _, d := range pump.Runs() {
+		if d >= config.Dose {
+			t.Errorf("pump ran %s, want it stopped early", d)
+		}
+	}
+
+	if c.Observe(context.Background(), 20) {
+		t.Error("watering started after Close()")
+	}
+	err = c.Run(context.Background(), time.Second)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("run error = %v, want %v", err, ErrClosed)
+	}
+}
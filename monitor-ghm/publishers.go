@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/outbox"
+	"github.com/grow/monitor-ghm/pkg/publish"
+	"github.com/nats-io/nats.go"
+)
+
+// publisherSet holds the running publishers, so they can be restarted with
+// new settings. Hooks, like the watering, get the readings too but aren't
+// restarted.
+type publisherSet struct {
+	nc *nats.Conn
+
+	mu         sync.Mutex
+	opt        options.Options
+	publishers []publish.Publisher
+	closers    []publish.Closer
+	hooks      []publish.Publisher
+}
+
+// Publishers returns a snapshot of the publishers and the hooks.
+func (ps *publisherSet) Publishers() []publish.Publisher {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append(slices.Clone(ps.publishers), ps.hooks...)
+}
+
+func (ps *publisherSet) AddHook(p publish.Publisher) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.hooks = append(ps.hooks, p)
+}
+
+// Options returns the options the publishers were opened with.
+func (ps *publisherSet) Options() options.Options {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.opt
+}
+
+// Restart closes the publishers, sending their pending readings, and opens
+// them again with the publishers and settings of opt. When they can't be
+// opened, the previous ones are opened again. The NATS connection is kept.
+func (ps *publisherSet) Restart(ctx context.Context, opt options.Options) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	err := closePublishers(ctx, ps.closers)
+	if err != nil {
+		slog.Warn("could not close publishers", "error", err)
+	}
+	ps.publishers, ps.closers = nil, nil
+
+	publishers, closers, err := newPublishers(ps.nc, opt)
+	if err != nil {
+		slog.Error("could not restart publishers, opening the previous ones", "error", err)
+		publishers, closers, prevErr := newPublishers(ps.nc, ps.opt)
+		if prevErr != nil {
+			return errors.Join(err, fmt.Errorf("could not open the previous publishers: %w", prevErr))
+		}
+		ps.publishers, ps.closers = publishers, closers
+		return err
+	}
+	slog.Info("publishers restarted", "publishers", opt.Publishers)
+	ps.opt = opt
+	ps.publishers, ps.closers = publishers, closers
+	return nil
+}
+
+// Close closes the publishers, sending their pending readings.
+func (ps *publisherSet) Close(ctx context.Context) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	err := closePublishers(ctx, ps.closers)
+	ps.publishers, ps.closers = nil, nil
+	return err
+}
+
+func closePublishers(ctx context.Context, closers []publish.Closer) error {
+	errs := []error{}
+	for _, c := range closers {
+		err := c(ctx)
+		if err != nil {
+			slog.Warn("could not close publisher", "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newPublishers opens the publishers of opt, closing the ones already opened
+// when one fails.
+func newPublishers(nc *nats.Conn, opt options.Options) ([]publish.Publisher, []publish.Closer, error) {
+	publishers := []publish.Publisher{}
+	closers := []publish.Closer{}
+	for _, pt := range opt.Publishers {
+		var p publish.Publisher
+		var c publish.Closer
+		var err error
+		switch pt {
+		case options.Console:
+			p = publish.NewConsolePublisher()
+		case options.NATS:
+			if nc == nil {
+				err = errors.New("not connected to NATS")
+				break
+			}
+			var ob *outbox.Outbox
+			ob, err = openOutbox(opt.NATS)
+			if err != nil {
+				break
+			}
+			p, c, err = publish.NewNATSPublisher(nc, opt.NATS, opt.Device, ob)
+			if err != nil && ob != nil {
+				ob.Close()
+			}
+		case options.MQTT:
+			p, c, err = publish.NewMQTTPublisher(opt.MQTT, opt.Device)
+		case options.HTTP:
+			p, c, err = publish.NewHTTPPublisher(opt.HTTP, opt.Device)
+		case options.Influx:
+			p, c, err = publish.NewInfluxPublisher(opt.Influx, opt.Device)
+		case options.Prometheus:
+			p, c, err = publish.NewPrometheusPublisher(opt.MetricsAddress)
+		default:
+			continue
+		}
+		if err != nil {
+			closePublishers(context.Background(), closers)
+			return nil, nil, fmt.Errorf("could not init publisher %s: %w", pt, err)
+		}
+		publishers = append(publishers, publish.Instrument(pt, p))
+		if c != nil {
+			closers = append(closers, c)
+		}
+	}
+	return publishers, closers, nil
+}
+
+// openWateringPublisher opens the publisher of the watering events, with its
+// own outbox next to the readings one, when enabled.
+func openWateringPublisher(nc *nats.Conn, opt options.Options) (*publish.WateringPublisher, error) {
+	config := opt.NATS
+	if config.OutboxPath != "" {
+		ext := filepath.Ext(config.OutboxPath)
+		config.OutboxPath = strings.TrimSuffix(config.OutboxPath, ext) + "-watering" + ext
+	}
+	ob, err := openOutbox(config)
+	if err != nil {
+		return nil, err
+	}
+	wp, err := publish.NewWateringPublisher(nc, config, opt.Device, ob)
+	if err != nil && ob != nil {
+		ob.Close()
+	}
+	return wp, err
+}
+
+// openOutbox opens the NATS outbox, when enabled.
+func openOutbox(config options.NATSConfig) (*outbox.Outbox, error) {
+	if config.OutboxPath == "" {
+		return nil, nil
+	}
+	ob, err := outbox.Open(config.OutboxPath, config.OutboxSize, config.OutboxEviction)
+	if err != nil {
+		return nil, fmt.Errorf("could not open NATS outbox: %w", err)
+	}
+	return ob, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/filter"
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// readerSet keeps the running readers in sync with the configured sensors,
+// so they can be changed while the monitor is running.
+type readerSet struct {
+	mu      sync.Mutex
+	closed  bool
+	sensors []options.Sensors
+	readers []*sensorReader
+}
+
+// sensorReader is a running reader with the configuration and the filter
+// chain of its sensor.
+type sensorReader struct {
+	Reader
+
+	mu           sync.Mutex
+	sensor       options.Sensors
+	healthConfig grow.HealthConfig
+	health       grow.Health
+	filters      filter.Chain
+	last         *readingStatus
+}
+
+// readingStatus is the latest reading of a sensor and the result of its
+// publish, shown by the status page.
+type readingStatus struct {
+	Reading  publish.Reading
+	Rejected bool  // by the filters, so not published
+	Err      error // of the publishers
+}
+
+// Sample measures the sensor and returns an unfiltered reading, with the
+// frequency and the calibration the moisture was computed from. The moisture
+// is computed by the reader, with the bounds set by Calibrate. Readings of
+// unhealthy sensors have NaN values.
+func (sr *sensorReader) Sample() publish.Reading {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	switch r := sr.Reader.(type) {
+	case AnalogReader:
+		return sr.sampleAnalog(r)
+	case grow.ValueReader:
+		return sr.sampleValue(r)
+	}
+
+	now := time.Now()
+	mr := sr.Reader.(MoistureReader)
+	m := mr.Measure()
+	health := grow.Diagnose(m, sr.sensor.MinMoisture, sr.sensor.MaxMoisture, now, sr.healthConfig)
+	if health != sr.health {
+		slog.Info("sensor health changed", "name", sr.sensor.Name, "from", sr.health, "to", health, "frequency", m.Frequency)
+		sr.health = health
+	}
+
+	moisture := math.NaN()
+	if health == grow.HealthOK {
+		moisture = mr.Read()
+	}
+	return publish.Reading{
+		Timestamp:    now,
+		Name:         sr.sensor.Name,
+		Metric:       string(grow.MetricMoisture),
+		Unit:         grow.Units[grow.MetricMoisture],
+		Value:        moisture,
+		Raw:          moisture,
+		Health:       string(health),
+		Connector:    sr.sensor.Connector,
+		Frequency:    m.Frequency,
+		MinFrequency: sr.sensor.MinMoisture,
+		MaxFrequency: sr.sensor.MaxMoisture,
+	}
+}
+
+// sampleAnalog reads the voltage of an analog sensor and computes the
+// moisture with the calibration of the sensor.
+func (sr *sensorReader) sampleAnalog(ar AnalogReader) publish.Reading {
+	now := time.Now()
+	adc := sr.sensor.ADC
+	voltage, err := ar.ReadVoltage()
+	health := grow.DiagnoseVoltage(voltage, err, adc.DryVoltage, adc.WetVoltage, sr.healthConfig)
+	if health != sr.health {
+		slog.Info("sensor health changed", "name", sr.sensor.Name, "from", sr.health, "to", health, "voltage", voltage, "error", err)
+		sr.health = health
+	}
+
+	moisture := math.NaN()
+	if health == grow.HealthOK {
+		moisture = ar.Moisture(voltage)
+	}
+	return publish.Reading{
+		Timestamp:  now,
+		Name:       sr.sensor.Name,
+		Metric:     string(grow.MetricMoisture),
+		Unit:       grow.Units[grow.MetricMoisture],
+		Value:      moisture,
+		Raw:        moisture,
+		Health:     string(health),
+		Voltage:    voltage,
+		DryVoltage: adc.DryVoltage,
+		WetVoltage: adc.WetVoltage,
+	}
+}
+
+// sampleValue reads a sensor reporting values directly, the reading fails
+// when the sensor can't be reached.
+func (sr *sensorReader) sampleValue(vr grow.ValueReader) publish.Reading {
+	now := time.Now()
+	value, err := vr.Read()
+	health := grow.DiagnoseValue(vr.Metric(), value, err)
+	if health != sr.health {
+		slog.Info("sensor health changed", "name", sr.sensor.Name, "from", sr.health, "to", health, "value", value, "error", err)
+		sr.health = health
+	}
+
+	if health != grow.HealthOK {
+		value = math.NaN()
+	}
+	return publish.Reading{
+		Timestamp: now,
+		Name:      sr.sensor.Name,
+		Metric:    string(vr.Metric()),
+		Unit:      grow.Units[vr.Metric()],
+		Value:     value,
+		Raw:       value,
+		Health:    string(health),
+	}
+}
+
+// Close closes the reader once the sample in progress is over.
+func (sr *sensorReader) Close() error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.Reader.Close()
+}
+
+// Filter passes a reading through the sensor filters. The bool is false when
+// the reading was rejected.
+func (sr *sensorReader) Filter(value float64) (float64, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.filters.Apply(value)
+}
+
+// Schedule returns how frequently the sensor is read and the maximum random
+// delay of each reading.
+func (sr *sensorReader) Schedule() (time.Duration, time.Duration) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.sensor.Interval, sr.sensor.Jitter
+}
+
+// Last returns the latest reading of the sensor, false when it wasn't read
+// yet.
+func (sr *sensorReader) Last() (readingStatus, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.last == nil {
+		return readingStatus{}, false
+	}
+	return *sr.last, true
+}
+
+func (sr *sensorReader) setLast(status readingStatus) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.last = &status
+}
+
+func (sr *sensorReader) setFilters(filters filter.Chain) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.filters = filters
+}
+
+func (sr *sensorReader) setSensor(sensor options.Sensors, healthConfig grow.HealthConfig) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.sensor = sensor
+	sr.healthConfig = healthConfig
+}
+
+// Apply opens readers for new sensors, closes the ones that were removed and
+// recalibrates the ones with new moisture bounds. Filters are reset when
+// their configuration changes. Sensors moved to another connector, backend,
+// metric or address are reopened. Sensors that fail to open are left out and
+// reported in the returned error, like the ones with invalid filters, which
+// keep their running reader and previous configuration instead when they
+// weren't moved.
+func (rs *readerSet) Apply(opt options.Options) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.closed {
+		return errors.New("readers already closed")
+	}
+
+	healthConfig := grow.HealthConfig{
+		DisconnectAfter: opt.DisconnectAfter,
+		RangeTolerance:  opt.RangeTolerance,
+	}
+	sensors := make([]options.Sensors, len(opt.Sensors))
+	for i, s := range opt.Sensors {
+		s.Backend = opt.SensorBackend(s)
+		s.Metric = opt.SensorMetric(s)
+		s.Interval = opt.SensorInterval(s)
+		s.Jitter = opt.SensorJitter(s)
+		sensors[i] = s
+	}
+
+	current := map[string]int{}
+	for i, s := range rs.sensors {
+		current[s.Name] = i
+	}
+	wanted := map[string]options.Sensors{}
+	for _, s := range sensors {
+		wanted[s.Name] = s
+	}
+
+	// closes the removed and moved readers first, so their lines are free
+	// to be requested again
+	for i, s := range rs.sensors {
+		w, found := wanted[s.Name]
+		if !found || !sameSource(w, s) {
+			slog.Info("closing reader", "name", s.Name, "connector", s.Connector)
+			rs.readers[i].Close()
+			publish.DeleteSensorGauges(s.Name)
+		}
+	}
+
+	applied := []options.Sensors{}
+	readers := []*sensorReader{}
+	errs := []error{}
+	for _, s := range sensors {
+		i, found := current[s.Name]
+		kept := found && sameSource(rs.sensors[i], s)
+
+		filters, err := filter.NewChain(s.Filters)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid filters for %s: %w", s.Name, err))
+			if kept {
+				applied = append(applied, rs.sensors[i])
+				readers = append(readers, rs.readers[i])
+			}
+			continue
+		}
+
+		if kept {
+			r := rs.readers[i]
+			mr, moisture := r.Reader.(MoistureReader)
+			if moisture && (rs.sensors[i].MinMoisture != s.MinMoisture || rs.sensors[i].MaxMoisture != s.MaxMoisture) {
+				slog.Info("recalibrating reader", "name", s.Name, "min", s.MinMoisture, "max", s.MaxMoisture)
+				mr.Calibrate(s.MinMoisture, s.MaxMoisture)
+			}
+			ar, analog := r.Reader.(AnalogReader)
+			if analog && (rs.sensors[i].ADC.DryVoltage != s.ADC.DryVoltage || rs.sensors[i].ADC.WetVoltage != s.ADC.WetVoltage) {
+				slog.Info("recalibrating reader", "name", s.Name, "dry", s.ADC.DryVoltage, "wet", s.ADC.WetVoltage)
+				ar.Calibrate(s.ADC.DryVoltage, s.ADC.WetVoltage)
+			}
+			if !slices.EqualFunc(rs.sensors[i].Filters, s.Filters, filterConfigEqual) {
+				slog.Info("resetting filters", "name", s.Name, "filters", len(s.Filters))
+				r.setFilters(filters)
+			}
+			r.setSensor(s, healthConfig)
+			applied = append(applied, s)
+			readers = append(readers, r)
+			continue
+		}
+
+		slog.Info("opening reader", "name", s.Name, "connector", s.Connector, "backend", s.Backend)
+		r, err := newReader(s, opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not init reader %s: %w", s.Name, err))
+			continue
+		}
+		applied = append(applied, s)
+		readers = append(readers, &sensorReader{Reader: r, sensor: s, healthConfig: healthConfig, filters: filters})
+	}
+
+	rs.sensors = applied
+	rs.readers = readers
+	return errors.Join(errs...)
+}
+
+// sameSource tells whether two sensors are read from the same hardware, so
+// the reader can be kept.
+func sameSource(a, b options.Sensors) bool {
+	return a.Connector == b.Connector && a.Backend == b.Backend && a.Metric == b.Metric && a.Address == b.Address &&
+		a.ADC.Channel == b.ADC.Channel && a.ADC.Gain == b.ADC.Gain && a.ADC.SampleRate == b.ADC.SampleRate
+}
+
+func filterConfigEqual(a, b filter.Config) bool {
+	return a.Type == b.Type && a.Size == b.Size && a.Alpha == b.Alpha &&
+		floatPtrEqual(a.Min, b.Min) && floatPtrEqual(a.Max, b.Max) &&
+		a.MaxDelta == b.MaxDelta && a.MaxRejections == b.MaxRejections
+}
+
+func floatPtrEqual(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func newReader(s options.Sensors, opt options.Options) (Reader, error) {
+	switch s.Backend {
+	case options.LTR559, options.BME280, options.ADS1115:
+		return newI2CReader(s, opt)
+	case options.Simulated:
+		config := grow.DefaultSimulationConfig
+		config.Speed = opt.SimulationSpeed
+		return grow.NewSimulatedMoistureReader(s.Name, s.MinMoisture, s.MaxMoisture, config), nil
+	default:
+		config := grow.MeasurementConfig{
+			Window:     opt.SamplingWindow,
+			StaleAfter: opt.StaleTimeout,
+		}
+		return grow.NewGrowHatMoistureReader(s.Name, opt.GPIOChip, s.Connector, s.MinMoisture, s.MaxMoisture, config)
+	}
+}
+
+// newI2CReader opens the I2C bus for the sensor, closed with the reader.
+func newI2CReader(s options.Sensors, opt options.Options) (Reader, error) {
+	bus, err := grow.OpenI2CBus(opt.I2CBus)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Reader
+	addr := s.Address
+	switch s.Backend {
+	case options.LTR559:
+		if addr == 0 {
+			addr = grow.LTR559Address
+		}
+		r, err = grow.NewLTR559Reader(s.Name, bus, addr, s.Metric)
+	case options.BME280:
+		if addr == 0 {
+			addr = grow.BME280Address
+		}
+		r, err = grow.NewBME280Reader(s.Name, bus, addr, s.Metric)
+	default:
+		if addr == 0 {
+			addr = grow.ADS1115Address
+		}
+		config := grow.ADS1115Config{
+			Channel:    s.ADC.Channel,
+			Gain:       s.ADC.Gain,
+			SampleRate: s.ADC.SampleRate,
+		}
+		r, err = grow.NewADS1115Reader(s.Name, bus, addr, config, s.ADC.DryVoltage, s.ADC.WetVoltage)
+	}
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Readers returns a snapshot of the running readers.
+func (rs *readerSet) Readers() []*sensorReader {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return slices.Clone(rs.readers)
+}
+
+// Close closes the readers, releasing their GPIO lines. Changes applied
+// afterwards are rejected.
+func (rs *readerSet) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	errs := []error{}
+	for _, r := range rs.readers {
+		err := r.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not close reader %s: %w", r.Name(), err))
+		}
+	}
+	rs.closed = true
+	rs.sensors = nil
+	rs.readers = nil
+	return errors.Join(errs...)
+}
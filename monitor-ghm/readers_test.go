@@ -0,0 +1,212 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/filter"
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+)
+
+// fakeReader is a grow.ValueReader returning value, counting its reads.
+type fakeReader struct {
+	name  string
+	value float64
+	err   error
+
+	mu     sync.Mutex
+	reads  int
+	closed bool
+}
+
+func (r *fakeReader) Read() (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reads++
+	return r.value, r.err
+}
+
+func (r *fakeReader) Metric() grow.Metric {
+	return grow.MetricLight
+}
+
+func (r *fakeReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *fakeReader) Name() string {
+	return r.name
+}
+
+func (r *fakeReader) Reads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reads
+}
+
+func (r *fakeReader) Closed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// simulatedOptions returns options reading sensors with the simulated
+// backend, every interval.
+func simulatedOptions(interval time.Duration, names ...string) options.Options {
+	opt := options.Options{
+		Frequency:     interval,
+		ReaderBackend: options.Simulated,
+		Workers:       options.DefaultWorkers,
+	}
+	for _, name := range names {
+		opt.Sensors = append(opt.Sensors, options.Sensors{Name: name, MinMoisture: options.MinMoisture, MaxMoisture: options.MaxMoisture})
+	}
+	return opt
+}
+
+func TestReaderSetApply(t *testing.T) {
+	readers := &readerSet{}
+	err := readers.Apply(simulatedOptions(time.Minute, "pilea", "basil"))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	running := readers.Readers()
+	if len(running) != 2 {
+		t.Fatalf("Apply() opened %d readers, want 2", len(running))
+	}
+	pilea := &fakeReader{name: "pilea"}
+	basil := &fakeReader{name: "basil"}
+	running[0].Reader = pilea
+	running[1].Reader = basil
+
+	// pilea kept, basil removed, ficus added
+	opt := simulatedOptions(2*time.Minute, "pilea", "ficus")
+	err = readers.Apply(opt)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	running = readers.Readers()
+	if len(running) != 2 || running[0].Reader != pilea || running[1].Name() != "ficus" {
+		t.Fatalf("Apply() readers = %v", running)
+	}
+	if interval, _ := running[0].Schedule(); interval != 2*time.Minute {
+		t.Errorf("pilea interval = %s, want 2m", interval)
+	}
+	if pilea.Closed() || !basil.Closed() {
+		t.Errorf("closed pilea %t, basil %t, want false, true", pilea.Closed(), basil.Closed())
+	}
+
+	err = readers.Close()
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !pilea.Closed() {
+		t.Errorf("pilea not closed by Close()")
+	}
+	if readers.Apply(opt) == nil {
+		t.Errorf("Apply() after Close() didn't fail")
+	}
+}
+
+func TestReaderSetApplyFilters(t *testing.T) {
+	median := []filter.Config{{Type: filter.Median, Size: 3}}
+	readers := &readerSet{}
+	opt := simulatedOptions(time.Minute, "pilea")
+	opt.Sensors[0].Filters = median
+	err := readers.Apply(opt)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	pilea := readers.Readers()[0]
+
+	tests := []struct {
+		name    string
+		filters []filter.Config // applied before the values
+		values  []float64
+		want    float64
+	}{
+		{
+			name:   "median",
+			values: []float64{10, 50, 20},
+			want:   20,
+		},
+		{
+			name:    "same filters kept",
+			filters: median,
+			values:  []float64{60},
+			want:    50,
+		},
+		{
+			name:    "changed filters reset",
+			filters: []filter.Config{{Type: filter.Median, Size: 5}},
+			values:  []float64{60},
+			want:    60,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filters != nil {
+				opt.Sensors[0].Filters = tt.filters
+				err := readers.Apply(opt)
+				if err != nil {
+					t.Fatalf("Apply() error = %v", err)
+				}
+				if readers.Readers()[0] != pilea {
+					t.Fatalf("Apply() reopened pilea")
+				}
+			}
+			var got float64
+			for _, v := range tt.values {
+				got, _ = pilea.Filter(v)
+			}
+			if got != tt.want {
+				t.Errorf("Filter() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderSetApplyInvalidFilters(t *testing.T) {
+	readers := &readerSet{}
+	opt := simulatedOptions(time.Minute, "pilea")
+	opt.Sensors[0].Filters = []filter.Config{{Type: filter.Median, Size: 3}}
+	err := readers.Apply(opt)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	pilea := &fakeReader{name: "pilea"}
+	readers.Readers()[0].Reader = pilea
+
+	// the invalid filters keep the running reader and its configuration
+	invalid := simulatedOptions(2*time.Minute, "pilea", "basil")
+	invalid.Sensors[0].Filters = []filter.Config{{Type: filter.Median}}
+	invalid.Sensors[1].Filters = []filter.Config{{Type: "unknown"}}
+	err = readers.Apply(invalid)
+	if err == nil {
+		t.Fatalf("Apply() with invalid filters didn't fail")
+	}
+	running := readers.Readers()
+	if len(running) != 1 || running[0].Reader != pilea {
+		t.Fatalf("Apply() readers = %v, want pilea kept", running)
+	}
+	if pilea.Closed() {
+		t.Errorf("pilea closed")
+	}
+	if interval, _ := running[0].Schedule(); interval != time.Minute {
+		t.Errorf("pilea interval = %s, want the previous 1m", interval)
+	}
+
+	// the kept reader is still closed when removed
+	err = readers.Apply(simulatedOptions(time.Minute))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !pilea.Closed() {
+		t.Errorf("pilea not closed when removed")
+	}
+}
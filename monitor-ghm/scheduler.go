@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// maxIdle is how long the scheduler waits at most before checking the
+// readers again, so new sensors and intervals are picked up.
+const maxIdle = time.Second
+
+// scheduler reads each sensor on its own interval, aligned to the wall
+// clock, and publishes the readings with a bounded pool of workers.
+type scheduler struct {
+	readers    *readerSet
+	publishers func() []publish.Publisher
+	jobs       chan *sensorReader
+	workers    sync.WaitGroup
+
+	// ctx is used by the readings in flight, so they can finish after Run
+	// returns
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	busy  map[*sensorReader]bool
+	slots map[*sensorReader]slot
+}
+
+// slot is the next time a sensor is due and the schedule it was computed
+// with, to reschedule the sensor when it changes.
+type slot struct {
+	due      time.Time
+	interval time.Duration
+	jitter   time.Duration
+}
+
+func newScheduler(readers *readerSet, publishers func() []publish.Publisher, workers int) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scheduler{
+		readers:    readers,
+		publishers: publishers,
+		jobs:       make(chan *sensorReader, workers),
+		ctx:        ctx,
+		cancel:     cancel,
+		busy:       map[*sensorReader]bool{},
+		slots:      map[*sensorReader]slot{},
+	}
+	s.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Run schedules the readings until ctx is done. The readings already queued
+// keep running, use Wait to wait for them.
+func (s *scheduler) Run(ctx context.Context) {
+	defer close(s.jobs)
+	for {
+		next := s.dispatch(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// Wait waits for the readings in flight after Run returns. When ctx is done
+// first, their publishes are cancelled.
+func (s *scheduler) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// dispatch queues the sensors that are due and returns when the scheduler
+// has to run again.
+func (s *scheduler) dispatch(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := now.Add(maxIdle)
+	readers := s.readers.Readers()
+	running := map[*sensorReader]bool{}
+	for _, r := range readers {
+		running[r] = true
+		interval, jitter := r.Schedule()
+		sl, found := s.slots[r]
+		if !found {
+			// new sensors are read right away, then on the ticks
+			sl = slot{due: now, interval: interval, jitter: jitter}
+		} else if sl.interval != interval || sl.jitter != jitter {
+			sl = slot{due: nextTick(now, interval, jitter), interval: interval, jitter: jitter}
+		}
+
+		if !now.Before(sl.due) {
+			s.submit(r)
+			sl.due = nextTick(now, interval, jitter)
+		}
+		s.slots[r] = sl
+		if sl.due.Before(next) {
+			next = sl.due
+		}
+	}
+
+	// forgets the removed readers
+	for r := range s.slots {
+		if !running[r] {
+			delete(s.slots, r)
+		}
+	}
+	return next
+}
+
+// submit queues a reading unless the previous one of the same sensor is
+// still running or all the workers are busy, so slow publishers don't pile
+// up readings.
+func (s *scheduler) submit(r *sensorReader) {
+	if s.busy[r] {
+		slog.Warn("skipping reading, previous one still running", "name", r.Name())
+		return
+	}
+	select {
+	case s.jobs <- r:
+		s.busy[r] = true
+	default:
+		slog.Warn("skipping reading, all workers busy", "name", r.Name())
+	}
+}
+
+// ReadNow reads and publishes a sensor outside of its schedule, marking it
+// busy so the scheduler doesn't read it at the same time. It fails when a
+// reading of the sensor is already running.
+func (s *scheduler) ReadNow(ctx context.Context, r *sensorReader) (publish.Reading, error) {
+	s.mu.Lock()
+	if s.busy[r] {
+		s.mu.Unlock()
+		return publish.Reading{Name: r.Name(), Value: math.NaN(), Raw: math.NaN()}, errors.New("reading already running")
+	}
+	s.busy[r] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.busy, r)
+		s.mu.Unlock()
+	}()
+	return readAndPublish(ctx, r, s.publishers())
+}
+
+func (s *scheduler) work() {
+	defer s.workers.Done()
+	for r := range s.jobs {
+		readAndPublish(s.ctx, r, s.publishers())
+		s.mu.Lock()
+		delete(s.busy, r)
+		s.mu.Unlock()
+	}
+}
+
+// nextTick returns the first multiple of interval after now, counted from
+// the Unix epoch, plus a random delay up to jitter.
+func nextTick(now time.Time, interval, jitter time.Duration) time.Time {
+	tick := now.Truncate(interval).Add(interval)
+	if jitter > 0 {
+		tick = tick.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	return tick
+}
+
+// readAndPublish samples the sensor and publishes the reading, returning
+// the errors of all the publishers.
+func readAndPublish(ctx context.Context, reader *sensorReader, publishers []publish.Publisher) (publish.Reading, error) {
+	reading := reader.Sample()
+
+	// readings of unhealthy sensors are published without filtering,
+	// so they don't change the filters state
+	if reading.Health == string(grow.HealthOK) {
+		value, ok := reader.Filter(reading.Raw)
+		slog.Debug("reading", "name", reading.Name, "value", value, "raw", reading.Raw, "frequency", reading.Frequency)
+		if !ok {
+			slog.Warn("reading rejected by filters", "name", reading.Name, "raw", reading.Raw)
+			reader.setLast(readingStatus{Reading: reading, Rejected: true})
+			return reading, nil
+		}
+		reading.Value = value
+	} else {
+		slog.Warn("sensor not healthy", "name", reading.Name, "metric", reading.Metric, "health", reading.Health, "frequency", reading.Frequency)
+	}
+
+	errs := []error{}
+	for _, publisher := range publishers {
+		err := publisher(ctx, reading)
+		if err != nil {
+			slog.Error("could not publish", "error", err)
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	reader.setLast(readingStatus{Reading: reading, Err: err})
+	return reading, err
+}
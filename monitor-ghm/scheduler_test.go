@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// testScheduler returns a scheduler without workers, so the tests run the
+// queued readings themselves, and the clock is the time given to dispatch.
+func testScheduler(readers *readerSet, publishers []publish.Publisher, workers int) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{
+		readers:    readers,
+		publishers: func() []publish.Publisher { return publishers },
+		jobs:       make(chan *sensorReader, workers),
+		ctx:        ctx,
+		cancel:     cancel,
+		busy:       map[*sensorReader]bool{},
+		slots:      map[*sensorReader]slot{},
+	}
+}
+
+// queued returns the names of the queued readings, finishing them like the
+// workers do.
+func queued(s *scheduler) []string {
+	names := []string{}
+	for {
+		select {
+		case r := <-s.jobs:
+			names = append(names, r.Name())
+			s.mu.Lock()
+			delete(s.busy, r)
+			s.mu.Unlock()
+		default:
+			return names
+		}
+	}
+}
+
+// fakeReaders opens simulated readers for names, read every interval, and
+// replaces them with fake readers.
+func fakeReaders(t *testing.T, interval time.Duration, names ...string) (*readerSet, []*fakeReader) {
+	t.Helper()
+	readers := &readerSet{}
+	err := readers.Apply(simulatedOptions(interval, names...))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	fakes := []*fakeReader{}
+	for i, r := range readers.Readers() {
+		fake := &fakeReader{name: names[i], value: float64(i)}
+		r.Reader = fake
+		fakes = append(fakes, fake)
+	}
+	return readers, fakes
+}
+
+func TestNextTick(t *testing.T) {
+	tests := []struct {
+		name     string
+		now      time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{
+			name:     "between ticks",
+			now:      time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC),
+			interval: time.Minute,
+			want:     time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC),
+		},
+		{
+			name:     "on a tick",
+			now:      time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC),
+			interval: time.Minute,
+			want:     time.Date(2024, 5, 1, 10, 2, 0, 0, time.UTC),
+		},
+		{
+			name:     "aligned to the interval",
+			now:      time.Date(2024, 5, 1, 10, 7, 12, 0, time.UTC),
+			interval: 5 * time.Minute,
+			want:     time.Date(2024, 5, 1, 10, 10, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextTick(tt.now, tt.interval, 0)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextTick() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextTickJitter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC)
+	tick := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	jitter := 10 * time.Second
+	for i := 0; i < 100; i++ {
+		got := nextTick(now, time.Minute, jitter)
+		if got.Before(tick) || !got.Before(tick.Add(jitter)) {
+			t.Fatalf("nextTick() = %s, want in [%s, %s)", got, tick, tick.Add(jitter))
+		}
+	}
+}
+
+func TestSchedulerDispatch(t *testing.T) {
+	readers, _ := fakeReaders(t, time.Minute, "pilea", "basil")
+	s := testScheduler(readers, nil, 2)
+	start := time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC)
+
+	// new sensors are read right away
+	next := s.dispatch(start)
+	if got := queued(s); !slices.Equal(got, []string{"pilea", "basil"}) {
+		t.Errorf("dispatch() queued %v, want pilea and basil", got)
+	}
+	if want := start.Add(maxIdle); !next.Equal(want) {
+		t.Errorf("dispatch() next = %s, want %s", next, want)
+	}
+
+	// then on the ticks
+	s.dispatch(start.Add(20 * time.Second))
+	if got := queued(s); len(got) != 0 {
+		t.Errorf("dispatch() before the tick queued %v", got)
+	}
+	next = s.dispatch(start.Add(29*time.Second + 500*time.Millisecond))
+	tick := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	if !next.Equal(tick) {
+		t.Errorf("dispatch() next = %s, want the tick %s", next, tick)
+	}
+	s.dispatch(tick)
+	if got := queued(s); !slices.Equal(got, []string{"pilea", "basil"}) {
+		t.Errorf("dispatch() on the tick queued %v, want pilea and basil", got)
+	}
+}
+
+func TestSchedulerDispatchBusy(t *testing.T) {
+	readers, _ := fakeReaders(t, time.Minute, "pilea")
+	s := testScheduler(readers, nil, 2)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	s.dispatch(start)
+	if len(s.jobs) != 1 {
+		t.Fatalf("dispatch() queued %d readings, want 1", len(s.jobs))
+	}
+
+	// the previous reading is still running, so the tick is skipped
+	s.dispatch(start.Add(time.Minute))
+	if len(s.jobs) != 1 {
+		t.Errorf("dispatch() queued %d readings while busy, want 1", len(s.jobs))
+	}
+	queued(s)
+	s.dispatch(start.Add(time.Minute + time.Second))
+	if got := queued(s); len(got) != 0 {
+		t.Errorf("dispatch() queued the skipped tick %v", got)
+	}
+	s.dispatch(start.Add(2 * time.Minute))
+	if got := queued(s); !slices.Equal(got, []string{"pilea"}) {
+		t.Errorf("dispatch() on the next tick queued %v, want pilea", got)
+	}
+}
+
+func TestSchedulerDispatchWorkersBusy(t *testing.T) {
+	readers, _ := fakeReaders(t, time.Minute, "pilea", "basil")
+	s := testScheduler(readers, nil, 1)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	s.dispatch(start)
+	if got := queued(s); !slices.Equal(got, []string{"pilea"}) {
+		t.Errorf("dispatch() queued %v, want only pilea", got)
+	}
+	s.mu.Lock()
+	busy := s.busy[readers.Readers()[1]]
+	s.mu.Unlock()
+	if busy {
+		t.Errorf("basil busy after being skipped")
+	}
+}
+
+func TestSchedulerDispatchChanges(t *testing.T) {
+	readers, _ := fakeReaders(t, time.Minute, "pilea", "basil")
+	s := testScheduler(readers, nil, 2)
+	start := time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC)
+	s.dispatch(start)
+	queued(s)
+	basil := readers.Readers()[1]
+
+	// pilea's interval changed and basil removed
+	err := readers.Apply(simulatedOptions(5*time.Minute, "pilea"))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	s.dispatch(start.Add(30 * time.Second))
+	if got := queued(s); len(got) != 0 {
+		t.Errorf("dispatch() on the old tick queued %v", got)
+	}
+	s.mu.Lock()
+	_, found := s.slots[basil]
+	due := s.slots[readers.Readers()[0]].due
+	s.mu.Unlock()
+	if found {
+		t.Errorf("removed basil still scheduled")
+	}
+	if want := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC); !due.Equal(want) {
+		t.Errorf("pilea due = %s, want %s", due, want)
+	}
+}
+
+func TestSchedulerReadNow(t *testing.T) {
+	var mu sync.Mutex
+	published := []publish.Reading{}
+	publisher := func(_ context.Context, r publish.Reading) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, r)
+		return nil
+	}
+	readers, fakes := fakeReaders(t, time.Minute, "pilea")
+	s := testScheduler(readers, []publish.Publisher{publisher}, 1)
+	pilea := readers.Readers()[0]
+
+	// fails while the scheduled reading is running
+	s.dispatch(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	_, err := s.ReadNow(context.Background(), pilea)
+	if err == nil {
+		t.Errorf("ReadNow() while busy didn't fail")
+	}
+	if fakes[0].Reads() != 0 {
+		t.Errorf("ReadNow() while busy read the sensor")
+	}
+
+	queued(s)
+	reading, err := s.ReadNow(context.Background(), pilea)
+	if err != nil {
+		t.Fatalf("ReadNow() error = %v", err)
+	}
+	if reading.Name != "pilea" || fakes[0].Reads() != 1 || len(published) != 1 {
+		t.Errorf("ReadNow() = %+v, %d reads, %d published", reading, fakes[0].Reads(), len(published))
+	}
+	s.mu.Lock()
+	busy := s.busy[pilea]
+	s.mu.Unlock()
+	if busy {
+		t.Errorf("pilea busy after ReadNow()")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// statusRefresh is how frequently the status page reloads itself.
+const statusRefresh = 10 * time.Second
+
+//go:embed status.html
+var statusHTML string
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"number": func(v *float64, format string) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf(format, *v)
+	},
+}).Parse(statusHTML))
+
+// sensorStatus is the latest reading of a sensor in the status API. Values
+// of unhealthy sensors and rejected readings are null, as the timestamp of
+// the sensors not read yet.
+type sensorStatus struct {
+	Name      string         `json:"name"`
+	Metric    string         `json:"metric,omitempty"`
+	Unit      string         `json:"unit,omitempty"`
+	Value     *float64       `json:"value"`
+	Raw       *float64       `json:"raw"`
+	Frequency *float64       `json:"frequency,omitempty"`
+	Voltage   *float64       `json:"voltage,omitempty"`
+	Health    string         `json:"health,omitempty"`
+	Timestamp *time.Time     `json:"timestamp"`
+	Publish   *publishStatus `json:"publish,omitempty"`
+}
+
+// publishStatus is the result of the latest publish of a sensor.
+type publishStatus struct {
+	OK       bool   `json:"ok"`
+	Rejected bool   `json:"rejected,omitempty"` // by the filters
+	Error    string `json:"error,omitempty"`
+}
+
+// statusPage is the data of the status page template.
+type statusPage struct {
+	Device        options.Device
+	Version       string
+	Uptime        time.Duration
+	Refresh       int
+	Sensors       []sensorStatus
+	PublishErrors map[string]int64
+}
+
+func sensorStatuses(readers *readerSet) []sensorStatus {
+	statuses := []sensorStatus{}
+	for _, r := range readers.Readers() {
+		last, found := r.Last()
+		if !found {
+			statuses = append(statuses, sensorStatus{Name: r.Name()})
+			continue
+		}
+		reading := last.Reading
+		status := sensorStatus{
+			Name:      reading.Name,
+			Metric:    reading.Metric,
+			Unit:      reading.Unit,
+			Value:     jsonFloat(reading.Value),
+			Raw:       jsonFloat(reading.Raw),
+			Health:    reading.Health,
+			Timestamp: &reading.Timestamp,
+			Publish:   &publishStatus{OK: !last.Rejected && last.Err == nil, Rejected: last.Rejected},
+		}
+		if last.Rejected {
+			status.Value = nil
+		}
+		if last.Err != nil {
+			status.Publish.Error = last.Err.Error()
+		}
+		if reading.Analog() {
+			status.Voltage = jsonFloat(reading.Voltage)
+		} else if reading.Moisture() {
+			status.Frequency = jsonFloat(reading.Frequency)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// serveStatus serves the status page and API of statusHandler on address.
+func serveStatus(address string, current *currentOptions, readers *readerSet) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: statusHandler(current, readers), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("status server stopped", "error", err)
+		}
+	}()
+	slog.Info("serving status", "address", listener.Addr().String())
+	return server, nil
+}
+
+// statusHandler serves the status page of the sensors on /, and their latest
+// readings and the current options on /api/sensors and /api/config.
+func statusHandler(current *currentOptions, readers *readerSet) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sensors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, sensorStatuses(readers))
+	})
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, configResult(current.Get()))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := statusPage{
+			Device:        current.Get().Device,
+			Version:       version,
+			Uptime:        time.Since(startTime).Truncate(time.Second),
+			Refresh:       int(statusRefresh.Seconds()),
+			Sensors:       sensorStatuses(readers),
+			PublishErrors: publish.Failures(),
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := statusTemplate.Execute(w, page)
+		if err != nil {
+			slog.Warn("could not render status page", "error", err)
+		}
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Warn("could not write status response", "error", err)
+	}
+}
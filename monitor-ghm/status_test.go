@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+)
+
+// statusServer serves the status of pilea, read and published, basil, read
+// with a failing publisher, and ficus, not read yet.
+func statusServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	readers, _ := fakeReaders(t, time.Minute, "pilea", "basil", "ficus")
+	running := readers.Readers()
+	readAndPublish(context.Background(), running[0], nil)
+	failing := func(context.Context, publish.Reading) error { return errors.New("broker down") }
+	readAndPublish(context.Background(), running[1], []publish.Publisher{failing})
+
+	opt := simulatedOptions(time.Minute, "pilea", "basil", "ficus")
+	opt.Device = options.Device{ID: "pi-one", Hostname: "pi"}
+	opt.LogLevel = &slog.LevelVar{}
+	server := httptest.NewServer(statusHandler(&currentOptions{opt: opt}, readers))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", url, err)
+	}
+	return resp, string(body)
+}
+
+func TestStatusSensors(t *testing.T) {
+	server := statusServer(t)
+	resp, body := get(t, server.URL+"/api/sensors")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /api/sensors = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	statuses := []sensorStatus{}
+	err := json.Unmarshal([]byte(body), &statuses)
+	if err != nil {
+		t.Fatalf("invalid /api/sensors %s: %v", body, err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("GET /api/sensors = %s, want 3 sensors", body)
+	}
+	pilea, basil, ficus := statuses[0], statuses[1], statuses[2]
+	if pilea.Value == nil || *pilea.Value != 0 || pilea.Publish == nil || !pilea.Publish.OK {
+		t.Errorf("pilea = %+v, want 0 published", pilea)
+	}
+	if basil.Value == nil || *basil.Value != 1 || basil.Publish == nil || basil.Publish.OK || basil.Publish.Error != "broker down" {
+		t.Errorf("basil = %+v, want 1 not published", basil)
+	}
+	if ficus.Name != "ficus" || ficus.Timestamp != nil || ficus.Publish != nil {
+		t.Errorf("ficus = %+v, want not read", ficus)
+	}
+}
+
+func TestStatusConfig(t *testing.T) {
+	server := statusServer(t)
+	resp, body := get(t, server.URL+"/api/config")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/config = %d", resp.StatusCode)
+	}
+
+	config := struct {
+		Device  struct{ ID string }
+		Sensors []struct{ Name string }
+	}{}
+	err := json.Unmarshal([]byte(body), &config)
+	if err != nil {
+		t.Fatalf("invalid /api/config %s: %v", body, err)
+	}
+	if config.Device.ID != "pi-one" || len(config.Sensors) != 3 {
+		t.Errorf("GET /api/config = %s, want pi-one with 3 sensors", body)
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	server := statusServer(t)
+	resp, body := get(t, server.URL+"/")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET / = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{"pi-one", "pilea", "basil", "ficus", "broker down"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("status page doesn't show %s", want)
+		}
+	}
+
+	resp, _ = get(t, server.URL+"/missing")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /missing = %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/grow/monitor-ghm/pkg/grow"
+	"github.com/grow/monitor-ghm/pkg/options"
+	"github.com/grow/monitor-ghm/pkg/publish"
+	"github.com/grow/monitor-ghm/pkg/water"
+)
+
+// waterSet keeps a watering controller for every sensor with a pump, in sync
+// with the configured sensors. The controllers are fed with the readings of
+// their sensor.
+type waterSet struct {
+	readers *readerSet
+	notify  func(water.Event)
+	driver  string
+	chip    string
+	dryRun  bool
+
+	// ctx stops the waterings in progress when closing
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	controllers map[string]*pumpController
+}
+
+// pumpController is a controller with the config of its pump.
+type pumpController struct {
+	*water.Controller
+	config options.WateringConfig
+}
+
+func newWaterSet(readers *readerSet, notify func(water.Event), opt options.Options) *waterSet {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &waterSet{
+		readers:     readers,
+		notify:      notify,
+		driver:      opt.PumpDriver,
+		chip:        opt.GPIOChip,
+		dryRun:      opt.WateringDryRun,
+		ctx:         ctx,
+		cancel:      cancel,
+		controllers: map[string]*pumpController{},
+	}
+}
+
+// Apply creates the controllers of the sensors with a pump and removes the
+// ones of the sensors without. Controllers moved to another pump or speed
+// get a new pump, the others only a new config.
+func (ws *waterSet) Apply(opt options.Options) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.ctx.Err() != nil {
+		return errors.New("watering already closed")
+	}
+
+	wanted := map[string]options.WateringConfig{}
+	for _, s := range opt.Sensors {
+		if s.Watering.Pump != 0 {
+			wanted[s.Name] = s.Watering
+		}
+	}
+
+	// closes the removed and moved pumps first, so their lines are free to
+	// be requested again, stopping their waterings
+	for name, c := range ws.controllers {
+		w, found := wanted[name]
+		if !found || w.Pump != c.config.Pump || w.Speed != c.config.Speed {
+			slog.Info("closing pump", "name", name, "pump", c.config.Pump)
+			err := c.Close()
+			if err != nil {
+				slog.Warn("could not close pump", "name", name, "pump", c.config.Pump, "error", err)
+			}
+			delete(ws.controllers, name)
+		}
+	}
+
+	errs := []error{}
+	for name, w := range wanted {
+		c, found := ws.controllers[name]
+		if found {
+			c.SetConfig(w.Config)
+			c.config = w
+			continue
+		}
+
+		slog.Info("opening pump", "name", name, "pump", w.Pump, "driver", ws.driver, "dry_run", ws.dryRun)
+		pump, err := ws.newPump(w)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not init pump %d of %s: %w", w.Pump, name, err))
+			continue
+		}
+		ws.controllers[name] = &pumpController{
+			Controller: water.NewController(name, w.Pump, pump, w.Config, ws.dryRun, ws.moisture(name), ws.notify),
+			config:     w,
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ws *waterSet) newPump(w options.WateringConfig) (grow.Pump, error) {
+	// dry runs don't need the pumps connected
+	if ws.driver == options.FakePump || ws.dryRun {
+		return &grow.FakePump{Wait: true}, nil
+	}
+	return grow.NewGPIOPump(ws.chip, grow.Pumps[w.Pump], w.Speed)
+}
+
+// moisture returns a fresh, unfiltered, moisture of the sensor for the
+// rechecks after soaking.
+func (ws *waterSet) moisture(name string) func() (float64, bool) {
+	return func() (float64, bool) {
+		for _, r := range ws.readers.Readers() {
+			if r.Name() == name {
+				reading := r.Sample()
+				return reading.Raw, reading.Health == string(grow.HealthOK)
+			}
+		}
+		return 0, false
+	}
+}
+
+// Publish passes the healthy moisture readings to the controller of their
+// sensor, which starts watering when they are too low. It is added to the
+// publishers, after the filters.
+func (ws *waterSet) Publish(ctx context.Context, r publish.Reading) error {
+	if !r.Moisture() || r.Health != string(grow.HealthOK) {
+		return nil
+	}
+	ws.mu.Lock()
+	c, found := ws.controllers[r.Name]
+	ws.mu.Unlock()
+	if found {
+		c.Observe(ws.ctx, r.Value)
+	}
+	return nil
+}
+
+// Close stops the waterings in progress and closes the pumps.
+func (ws *waterSet) Close() error {
+	ws.cancel()
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	errs := []error{}
+	for name, c := range ws.controllers {
+		err := c.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not close pump of %s: %w", name, err))
+		}
+	}
+	ws.controllers = map[string]*pumpController{}
+	return errors.Join(errs...)
+}
+
+// wateringNotifier logs the watering events and publishes them with send,
+// when connected to NATS.
+func wateringNotifier(send func(water.Event) error) func(water.Event) {
+	return func(e water.Event) {
+		slog.Info("watering event", "sensor", e.Sensor, "pump", e.Pump, "dose", e.Dose, "duration", e.Duration, "skipped", e.Skipped, "error", e.Error, "dry_run", e.DryRun)
+		if send == nil {
+			return
+		}
+		err := send(e)
+		if errors.Is(err, publish.ErrQueued) {
+			slog.Info("watering event queued in the outbox", "sensor", e.Sensor, "dose", e.Dose)
+		} else if err != nil {
+			slog.Warn("could not publish watering event", "error", err)
+		}
+	}
+}
+
+// RunPump runs a pump for duration in the background, with the limits of
+// the controller of its sensor, see water.Controller.Run. It returns the
+// sensor of the pump.
+func (ws *waterSet) RunPump(pump int, duration time.Duration) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for name, c := range ws.controllers {
+		if c.config.Pump != pump {
+			continue
+		}
+		err := c.Run(ws.ctx, duration)
+		if err != nil {
+			return name, fmt.Errorf("could not run pump %d of %s: %w", pump, name, err)
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("pump %d not used by any sensor", pump)
+}